@@ -0,0 +1,224 @@
+//go:build cgofuse
+
+package main
+
+import (
+	"errors"
+	"io"
+	iofs "io/fs"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/rakoo/codecfs/codecfs"
+	"github.com/winfsp/cgofuse/fuse"
+)
+
+// cgofuseFS serves the tree of a root through cgofuse, which speaks to
+// libfuse, macFUSE or WinFsp rather than to the kernel. cgofuse names files
+// by path, so it goes through the io/fs view of the tree, as the other
+// transports do.
+type cgofuseFS struct {
+	fuse.FileSystemBase
+	tree iofs.FS
+
+	mu      sync.Mutex
+	handles map[uint64]iofs.File
+	lastFh  uint64
+}
+
+// treeName gives the io/fs name of a cgofuse path
+func treeName(path string) string {
+	name := strings.Trim(path, "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// errno gives the negated error number cgofuse expects for err
+func errno(err error) int {
+	switch {
+	case errors.Is(err, iofs.ErrNotExist):
+		return -fuse.ENOENT
+	case errors.Is(err, iofs.ErrPermission):
+		return -fuse.EACCES
+	case errors.Is(err, iofs.ErrInvalid):
+		return -fuse.EINVAL
+	}
+	return -fuse.EIO
+}
+
+func fillStat(stat *fuse.Stat_t, info iofs.FileInfo) {
+	*stat = fuse.Stat_t{
+		Mode:  uint32(info.Mode().Perm()),
+		Nlink: 1,
+		Size:  info.Size(),
+		Mtim:  fuse.NewTimespec(info.ModTime()),
+	}
+	stat.Atim, stat.Ctim, stat.Birthtim = stat.Mtim, stat.Mtim, stat.Mtim
+	if info.IsDir() {
+		stat.Mode |= fuse.S_IFDIR
+		stat.Nlink = 2
+	} else {
+		stat.Mode |= fuse.S_IFREG
+	}
+	// Files belong to whoever looks at them, as the tree is read-only
+	stat.Uid, stat.Gid, _ = fuse.Getcontext()
+}
+
+func (c *cgofuseFS) Statfs(path string, stat *fuse.Statfs_t) int {
+	*stat = fuse.Statfs_t{Bsize: 4096, Frsize: 4096, Namemax: 255}
+	return 0
+}
+
+func (c *cgofuseFS) Getattr(path string, stat *fuse.Stat_t, fh uint64) int {
+	info, err := iofs.Stat(c.tree, treeName(path))
+	if err != nil {
+		return errno(err)
+	}
+	fillStat(stat, info)
+	return 0
+}
+
+// open keeps f under a new handle number
+func (c *cgofuseFS) open(f iofs.File) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastFh++
+	c.handles[c.lastFh] = f
+	return c.lastFh
+}
+
+func (c *cgofuseFS) handle(fh uint64) (iofs.File, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, ok := c.handles[fh]
+	return f, ok
+}
+
+func (c *cgofuseFS) release(fh uint64) int {
+	c.mu.Lock()
+	f, ok := c.handles[fh]
+	delete(c.handles, fh)
+	c.mu.Unlock()
+	if !ok {
+		return -fuse.EBADF
+	}
+	if err := f.Close(); err != nil {
+		return errno(err)
+	}
+	return 0
+}
+
+func (c *cgofuseFS) Open(path string, flags int) (int, uint64) {
+	if flags&fuse.O_ACCMODE != fuse.O_RDONLY {
+		return -fuse.EROFS, ^uint64(0)
+	}
+	f, err := c.tree.Open(treeName(path))
+	if err != nil {
+		return errno(err), ^uint64(0)
+	}
+	return 0, c.open(f)
+}
+
+func (c *cgofuseFS) Read(path string, buff []byte, ofst int64, fh uint64) int {
+	f, ok := c.handle(fh)
+	if !ok {
+		return -fuse.EBADF
+	}
+	r, ok := f.(io.ReaderAt)
+	if !ok {
+		return -fuse.EISDIR
+	}
+	n, err := r.ReadAt(buff, ofst)
+	if err != nil && err != io.EOF && n == 0 {
+		return errno(err)
+	}
+	return n
+}
+
+func (c *cgofuseFS) Release(path string, fh uint64) int {
+	return c.release(fh)
+}
+
+func (c *cgofuseFS) Opendir(path string) (int, uint64) {
+	f, err := c.tree.Open(treeName(path))
+	if err != nil {
+		return errno(err), ^uint64(0)
+	}
+	if _, ok := f.(iofs.ReadDirFile); !ok {
+		f.Close()
+		return -fuse.ENOTDIR, ^uint64(0)
+	}
+	return 0, c.open(f)
+}
+
+func (c *cgofuseFS) Readdir(path string, fill func(name string, stat *fuse.Stat_t, ofst int64) bool, ofst int64, fh uint64) int {
+	f, ok := c.handle(fh)
+	if !ok {
+		return -fuse.EBADF
+	}
+	ents, err := f.(iofs.ReadDirFile).ReadDir(-1)
+	if err != nil {
+		return errno(err)
+	}
+	fill(".", nil, 0)
+	fill("..", nil, 0)
+	for _, ent := range ents {
+		var stat fuse.Stat_t
+		info, err := ent.Info()
+		if err != nil {
+			continue
+		}
+		fillStat(&stat, info)
+		if !fill(ent.Name(), &stat, 0) {
+			break
+		}
+	}
+	return 0
+}
+
+func (c *cgofuseFS) Releasedir(path string, fh uint64) int {
+	return c.release(fh)
+}
+
+// serveCgofuse mounts the trees of roots on the mountpoints of specs
+// through cgofuse, and serves them until they are all unmounted
+func serveCgofuse(specs []mountSpec, roots []*codecfs.Root, volumeName string, allowOther, allowRoot bool, extra fuseOptions) {
+	var wg sync.WaitGroup
+	for i, spec := range specs {
+		if !supervised() {
+			if err := lockMountpoint(spec.Mountpoint); err != nil {
+				fatal("Can't lock mountpoint", "mountpoint", spec.Mountpoint, "err", err)
+			}
+		}
+		opts := []string{"ro", "fsname=codecfs", "subtype=codecfs"}
+		if volumeName != "" {
+			opts = append(opts, "volname="+volumeName)
+		}
+		switch {
+		case allowOther:
+			opts = append(opts, "allow_other")
+		case allowRoot:
+			opts = append(opts, "allow_root")
+		}
+		opts = append(opts, extra...)
+		host := fuse.NewFileSystemHost(&cgofuseFS{tree: roots[i].FS(), handles: make(map[uint64]iofs.File)})
+		wg.Add(1)
+		go func(mountpoint string) {
+			defer wg.Done()
+			// It returns once unmounted, which it does itself on SIGINT
+			// and SIGTERM
+			if !host.Mount(mountpoint, []string{"-o", strings.Join(opts, ",")}) {
+				fatal("Mount failed", "mountpoint", mountpoint)
+			}
+			slog.Info("Unmounted", "mountpoint", mountpoint)
+		}(spec.Mountpoint)
+	}
+	if err := sdNotify("READY=1"); err != nil {
+		slog.Warn("Can't notify systemd", "err", err)
+	}
+	wg.Wait()
+	sdNotify("STOPPING=1")
+}
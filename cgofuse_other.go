@@ -0,0 +1,9 @@
+//go:build !cgofuse
+
+package main
+
+import "github.com/rakoo/codecfs/codecfs"
+
+func serveCgofuse(specs []mountSpec, roots []*codecfs.Root, volumeName string, allowOther, allowRoot bool, extra fuseOptions) {
+	fatal("-cgofuse needs codecfs built with -tags cgofuse")
+}
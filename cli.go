@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rakoo/codecfs/codecfs"
+)
+
+func controlFlag(flags *flag.FlagSet) *string {
+	return flags.String("control-socket", "", "Control socket of the mount, as given to codecfs mount")
+}
+
+// controlCallOrExit is ControlCall for commands, which have nothing better to
+// do than to exit on errors
+func controlCallOrExit(socket string, req codecfs.ControlRequest) codecfs.ControlResponse {
+	if socket == "" {
+		fmt.Fprintln(os.Stderr, "Missing -control-socket")
+		os.Exit(2)
+	}
+	resp, err := codecfs.ControlCall(socket, req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	return resp
+}
+
+func runStats(args []string) {
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	socket := controlFlag(flags)
+	flags.Parse(args)
+
+	resp := controlCallOrExit(*socket, codecfs.ControlRequest{Cmd: "list"})
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tPID\tPROFILE\tRUNNING\tBYTES\tSOURCE")
+	for _, t := range resp.Transcodes {
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%d\t%s\n", t.ID, t.Pid, t.Profile, time.Since(t.Started).Round(time.Second), t.Produced, t.Source)
+	}
+	w.Flush()
+}
+
+func runCache(args []string) {
+	flags := flag.NewFlagSet("cache", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: codecfs cache [flags] ls|prune")
+		flags.PrintDefaults()
+	}
+	socket := controlFlag(flags)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	switch flags.Arg(0) {
+	case "ls":
+		resp := controlCallOrExit(*socket, codecfs.ControlRequest{Cmd: "sizes"})
+		names := make([]string, 0, len(resp.Sizes))
+		for name := range resp.Sizes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("%d\t%s\n", resp.Sizes[name], name)
+		}
+	case "prune":
+		controlCallOrExit(*socket, codecfs.ControlRequest{Cmd: "prune"})
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+}
+
+// runReload has a mount reload its configuration, as SIGHUP does
+func runReload(args []string) {
+	flags := flag.NewFlagSet("reload", flag.ExitOnError)
+	socket := controlFlag(flags)
+	flags.Parse(args)
+
+	controlCallOrExit(*socket, codecfs.ControlRequest{Cmd: "reload"})
+}
+
+// runHealthcheck checks a mount, exiting with 1 when it is unhealthy
+func runHealthcheck(args []string) {
+	flags := flag.NewFlagSet("healthcheck", flag.ExitOnError)
+	socket := controlFlag(flags)
+	flags.Parse(args)
+
+	resp := controlCallOrExit(*socket, codecfs.ControlRequest{Cmd: "health"})
+	healthy := true
+	for _, c := range resp.Health {
+		if c.Error != "" {
+			fmt.Printf("%s: %s\n", c.Name, c.Error)
+			healthy = false
+		} else {
+			fmt.Printf("%s: ok\n", c.Name)
+		}
+	}
+	if !healthy {
+		os.Exit(1)
+	}
+}
+
+// runProbe tells how a source file would be exposed, without mounting
+// anything
+func runProbe(args []string) {
+	flags := flag.NewFlagSet("probe", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: codecfs probe [flags] <file>")
+		flags.PrintDefaults()
+	}
+	opts := codecfs.DefaultOptions()
+	opts.AddProfileFlags(flags)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if err := codecfs.Probe(os.Stdout, flags.Arg(0), opts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
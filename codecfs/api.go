@@ -0,0 +1,97 @@
+package codecfs
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"bazil.org/fuse"
+)
+
+// The REST API lets schedulers drive transcodes over HTTP:
+//
+//	POST /transcode {"path": "opus-96/Artist/Album"}
+//	DELETE /transcode/{id}
+//	GET /queue
+//
+// POST queues the files under a path of the mounts to be transcoded in the
+// background, to learn their exact sizes, and answers 202, or 422 when
+// there's no size cache to keep them in. DELETE cancels a
+// running transcode and answers 204. GET lists the running transcodes, and
+// the paths waiting to be transcoded, the first one being in progress.
+// Errors come with a JSON object holding an error message.
+type apiQueue struct {
+	Transcodes []TranscodeStatus `json:"transcodes"`
+	Queued     []string          `json:"queued"`
+}
+
+type apiTranscodeRequest struct {
+	Path string `json:"path"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// ListenAPI serves the REST API on addr
+func ListenAPI(addr string) error {
+	return http.ListenAndServe(addr, apiHandler())
+}
+
+func apiHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /transcode", apiTranscode)
+	mux.HandleFunc("DELETE /transcode/{id}", apiCancel)
+	mux.HandleFunc("GET /queue", apiListQueue)
+	return mux
+}
+
+func apiTranscode(w http.ResponseWriter, r *http.Request) {
+	var req apiTranscodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apiReply(w, http.StatusBadRequest, apiError{err.Error()})
+		return
+	}
+	err := warm(r.Context(), req.Path)
+	switch {
+	case errors.Is(err, fuse.ENOENT):
+		apiReply(w, http.StatusNotFound, apiError{"no " + req.Path + " in the mounts"})
+	case errors.Is(err, errNothingToWarm), errors.Is(err, errNoSizeCache):
+		apiReply(w, http.StatusUnprocessableEntity, apiError{err.Error()})
+	case err != nil:
+		apiReply(w, http.StatusInternalServerError, apiError{err.Error()})
+	default:
+		apiReply(w, http.StatusAccepted, req)
+	}
+}
+
+func apiCancel(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		apiReply(w, http.StatusBadRequest, apiError{"invalid transcode id"})
+		return
+	}
+	err = cancelTranscode(id)
+	switch {
+	case errors.Is(err, errNoTranscode):
+		apiReply(w, http.StatusNotFound, apiError{err.Error()})
+	case err != nil:
+		apiReply(w, http.StatusInternalServerError, apiError{err.Error()})
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func apiListQueue(w http.ResponseWriter, r *http.Request) {
+	apiReply(w, http.StatusOK, apiQueue{
+		Transcodes: activeTranscodeStatus(),
+		Queued:     queuedWarms(),
+	})
+}
+
+func apiReply(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,98 @@
+package codecfs
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func apiRequest(t *testing.T, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	apiHandler().ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return w
+}
+
+// withSizeCache opens a size cache for the duration of the test
+func withSizeCache(t *testing.T) {
+	t.Helper()
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "sizes.db"), 0644, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(sizeBucket)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	sizeDB = db
+	t.Cleanup(func() {
+		sizeDB = nil
+		db.Close()
+	})
+}
+
+func TestAPIWarmNeedsSizeCache(t *testing.T) {
+	w := apiRequest(t, "POST", "/transcode", `{"path": "ogg"}`)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("warming without a size cache: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestAPIWarm(t *testing.T) {
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "notes.txt"), []byte("text"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := DefaultOptions()
+	opts.SizeCache = ""
+	opts.Watch = false
+	if _, err := New(src, opts); err != nil {
+		t.Fatal(err)
+	}
+	withSizeCache(t)
+
+	for _, c := range []struct {
+		body string
+		code int
+	}{
+		{`{"path": "ogg/missing.flac"}`, http.StatusNotFound},
+		// Shown as it is
+		{`{"path": "ogg/notes.txt"}`, http.StatusUnprocessableEntity},
+		{`{"path":`, http.StatusBadRequest},
+	} {
+		if w := apiRequest(t, "POST", "/transcode", c.body); w.Code != c.code {
+			t.Errorf("%s: %d %s, want %d", c.body, w.Code, w.Body, c.code)
+		}
+	}
+}
+
+func TestAPICancel(t *testing.T) {
+	if w := apiRequest(t, "DELETE", "/transcode/bogus", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid id: %d", w.Code)
+	}
+	if w := apiRequest(t, "DELETE", "/transcode/1099511627776", ""); w.Code != http.StatusNotFound {
+		t.Errorf("missing transcode: %d", w.Code)
+	}
+}
+
+func TestAPIQueue(t *testing.T) {
+	w := apiRequest(t, "GET", "/queue", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("%d %s", w.Code, w.Body)
+	}
+	var queue apiQueue
+	if err := json.Unmarshal(w.Body.Bytes(), &queue); err != nil {
+		t.Fatal(err)
+	}
+	if queue.Transcodes == nil || queue.Queued == nil {
+		t.Errorf("missing lists in %s", w.Body)
+	}
+}
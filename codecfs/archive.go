@@ -0,0 +1,280 @@
+package codecfs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// archiveFS shows the zip and tar archives of the wrapped source as
+// directories of their members. Archives are indexed when first looked
+// into, and again when they change.
+type archiveFS struct {
+	sourceFS
+}
+
+// An archiveIndex describes the members of an archive
+type archiveIndex struct {
+	size  int64
+	mtime time.Time
+	// Members by path relative to the archive, with their implicit parent
+	// directories
+	members map[string]remoteInfo
+	// Names of the entries of each directory of the archive, "." being the
+	// top level
+	children map[string][]string
+}
+
+// Indexes by archive path
+var archiveIndexes sync.Map
+
+func isArchive(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".zip" || ext == ".tar"
+}
+
+// split finds the archive p is in, or is, and the path of p in it. ok is
+// false when p isn't in an archive.
+func (a archiveFS) split(p string) (archive, member string, ok bool) {
+	for i := 0; i < len(p); {
+		j := strings.IndexByte(p[i+1:], '/')
+		if j < 0 {
+			j = len(p)
+		} else {
+			j += i + 1
+		}
+		if isArchive(p[i:j]) {
+			// Directories named like archives are left alone
+			if stat, err := a.sourceFS.Stat(p[:j]); err == nil && stat.Mode().IsRegular() {
+				return p[:j], strings.TrimPrefix(p[j:], "/"), true
+			}
+		}
+		i = j
+	}
+	return "", "", false
+}
+
+// index gives the index of archive, building it when needed
+func (a archiveFS) index(archive string) (*archiveIndex, error) {
+	stat, err := a.sourceFS.Stat(archive)
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := archiveIndexes.Load(archive); ok {
+		idx := v.(*archiveIndex)
+		if idx.size == stat.Size() && idx.mtime.Equal(stat.ModTime()) {
+			return idx, nil
+		}
+	}
+
+	idx := &archiveIndex{
+		size:     stat.Size(),
+		mtime:    stat.ModTime(),
+		members:  map[string]remoteInfo{},
+		children: map[string][]string{".": nil},
+	}
+	var add func(name string, info remoteInfo)
+	add = func(name string, info remoteInfo) {
+		name = memberName(name)
+		if name == "" {
+			return
+		}
+		if _, ok := idx.members[name]; !ok {
+			// Parents come first so that they are listed
+			parent := path.Dir(name)
+			if parent != "." {
+				add(parent, remoteInfo{dir: true, mtime: stat.ModTime()})
+			}
+			idx.children[parent] = append(idx.children[parent], path.Base(name))
+		}
+		info.name = path.Base(name)
+		idx.members[name] = info
+	}
+
+	f, err := a.sourceFS.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if strings.EqualFold(path.Ext(archive), ".zip") {
+		zr, err := zip.NewReader(randomAccess(a.sourceFS, archive, f), stat.Size())
+		if err != nil {
+			return nil, err
+		}
+		for _, zf := range zr.File {
+			info := zf.FileInfo()
+			add(zf.Name, remoteInfo{size: info.Size(), mtime: info.ModTime(), dir: info.IsDir()})
+		}
+	} else {
+		tr := tar.NewReader(f)
+		for {
+			h, err := tr.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			switch h.Typeflag {
+			case tar.TypeDir:
+				add(h.Name, remoteInfo{dir: true, mtime: h.ModTime})
+			case tar.TypeReg:
+				add(h.Name, remoteInfo{size: h.Size, mtime: h.ModTime})
+			}
+		}
+	}
+	archiveIndexes.Store(archive, idx)
+	return idx, nil
+}
+
+// member gives the stat of the member of archive
+func (a archiveFS) member(archive, member string) (os.FileInfo, error) {
+	idx, err := a.index(archive)
+	if err != nil {
+		return nil, err
+	}
+	if member == "" {
+		return remoteInfo{name: path.Base(archive), dir: true, mtime: idx.mtime}, nil
+	}
+	info, ok := idx.members[member]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return info, nil
+}
+
+func (a archiveFS) Stat(p string) (os.FileInfo, error) {
+	archive, member, ok := a.split(p)
+	if !ok {
+		return a.sourceFS.Stat(p)
+	}
+	stat, err := a.member(archive, member)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: p, Err: err}
+	}
+	return stat, nil
+}
+
+func (a archiveFS) Lstat(p string) (os.FileInfo, error) {
+	if _, _, ok := a.split(p); ok {
+		return a.Stat(p)
+	}
+	return a.sourceFS.Lstat(p)
+}
+
+func (a archiveFS) ReadDirNames(p string) ([]string, error) {
+	archive, member, ok := a.split(p)
+	if !ok {
+		return a.sourceFS.ReadDirNames(p)
+	}
+	idx, err := a.index(archive)
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: p, Err: err}
+	}
+	if member == "" {
+		member = "."
+	}
+	names, ok := idx.children[member]
+	if !ok {
+		return nil, &os.PathError{Op: "readdir", Path: p, Err: fs.ErrNotExist}
+	}
+	return append([]string(nil), names...), nil
+}
+
+func (a archiveFS) Open(p string) (sourceFile, error) {
+	archive, member, ok := a.split(p)
+	if !ok {
+		return a.sourceFS.Open(p)
+	}
+	f, err := a.openMember(archive, member)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: p, Err: err}
+	}
+	return f, nil
+}
+
+// archiveMember reads a member of an opened archive
+type archiveMember struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (m archiveMember) Close() error {
+	var err error
+	for _, c := range m.closers {
+		err = errors.Join(err, c.Close())
+	}
+	return err
+}
+
+func (a archiveFS) openMember(archive, member string) (sourceFile, error) {
+	idx, err := a.index(archive)
+	if err != nil {
+		return nil, err
+	}
+	if info, ok := idx.members[member]; !ok {
+		return nil, fs.ErrNotExist
+	} else if info.dir {
+		return nil, errors.New("is a directory")
+	}
+
+	f, err := a.sourceFS.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(path.Ext(archive), ".zip") {
+		ra := randomAccess(a.sourceFS, archive, f)
+		zr, err := zip.NewReader(ra, idx.size)
+		if err != nil {
+			ra.Close()
+			return nil, err
+		}
+		for _, zf := range zr.File {
+			if memberName(zf.Name) != member {
+				continue
+			}
+			r, err := zf.Open()
+			if err != nil {
+				ra.Close()
+				return nil, err
+			}
+			return archiveMember{r, []io.Closer{r, ra}}, nil
+		}
+		ra.Close()
+		return nil, fs.ErrNotExist
+	}
+
+	tr := tar.NewReader(f)
+	for {
+		h, err := tr.Next()
+		if err != nil {
+			f.Close()
+			if err == io.EOF {
+				err = fs.ErrNotExist
+			}
+			return nil, err
+		}
+		if h.Typeflag == tar.TypeReg && memberName(h.Name) == member {
+			return archiveMember{tr, []io.Closer{f}}, nil
+		}
+	}
+}
+
+// memberName cleans the name of a member as found in the archive
+func memberName(name string) string {
+	return path.Clean("/" + name)[1:]
+}
+
+// Members can't be read by ffmpeg directly, so they are fed to it
+func (a archiveFS) Input(p string) string {
+	if _, _, ok := a.split(p); ok {
+		return ""
+	}
+	return a.sourceFS.Input(p)
+}
@@ -0,0 +1,42 @@
+package codecfs
+
+import (
+	"hash/fnv"
+	"os"
+
+	"bazil.org/fuse"
+)
+
+// setAttr copies the timestamps of a source file of r to the attributes of
+// its virtual counterpart, along with its ownership and permissions unless
+// ForceReadOnly is set. a.Mode must already hold the type of the node.
+func (r *Root) setAttr(a *fuse.Attr, stat os.FileInfo) {
+	a.Mtime = stat.ModTime()
+	a.Atime, a.Ctime = statTimes(stat)
+	if r.opts.ForceReadOnly {
+		return
+	}
+
+	// Nothing can be written through the mount, whatever the source says
+	a.Mode = a.Mode&^os.ModePerm | stat.Mode().Perm()&^0222
+	if uid, gid, ok := statOwner(stat); ok {
+		a.Uid = uid
+		a.Gid = gid
+	}
+}
+
+// inode derives a stable inode number from the position of a node in the
+// virtual tree, so that it is the same in Attr and ReadDirAll and across
+// restarts
+func inode(profile, path string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(profile))
+	h.Write([]byte{0})
+	h.Write([]byte(path))
+	ino := h.Sum64()
+	// 0 is invalid and 1 belongs to the root
+	if ino <= 1 {
+		ino += 2
+	}
+	return ino
+}
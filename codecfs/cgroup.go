@@ -0,0 +1,13 @@
+package codecfs
+
+// Cgroup v2 directory, delegated to us, under which each encoder gets a
+// cgroup of its own capped by encoderCPU and encoderMemory. Empty to leave
+// encoders in our cgroup. Linux only.
+var cgroupParent string
+
+// How many CPUs an encoder can use, e.g. 1.5, and how many bytes of memory,
+// 0 for no limit
+var (
+	encoderCPU    float64
+	encoderMemory int64
+)
@@ -0,0 +1,95 @@
+package codecfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// encoderCgroup is the cgroup of an encoder
+type encoderCgroup struct {
+	path string
+	dir  *os.File
+}
+
+// newEncoderCgroup creates the cgroup of transcode id under cgroupParent,
+// with its limits
+func newEncoderCgroup(id uint64) (*encoderCgroup, error) {
+	path := filepath.Join(cgroupParent, fmt.Sprintf("codecfs-%d-%d", os.Getpid(), id))
+	if err := os.Mkdir(path, 0755); err != nil {
+		return nil, err
+	}
+	g := &encoderCgroup{path: path}
+	if encoderCPU > 0 {
+		const period = 100000
+		quota := strconv.Itoa(int(encoderCPU*period)) + " " + strconv.Itoa(period)
+		if err := os.WriteFile(filepath.Join(path, "cpu.max"), []byte(quota), 0); err != nil {
+			g.remove()
+			return nil, err
+		}
+	}
+	if encoderMemory > 0 {
+		limit := []byte(strconv.FormatInt(encoderMemory, 10))
+		if err := os.WriteFile(filepath.Join(path, "memory.max"), limit, 0); err != nil {
+			g.remove()
+			return nil, err
+		}
+		// Swapping would only make it slower to hit the limit. Not
+		// every kernel has swap accounting.
+		os.WriteFile(filepath.Join(path, "memory.swap.max"), []byte("0"), 0)
+	}
+	dir, err := os.Open(path)
+	if err != nil {
+		g.remove()
+		return nil, err
+	}
+	g.dir = dir
+	return g, nil
+}
+
+// attach makes cmd start in the cgroup, so that it is capped from its very
+// first instruction
+func (g *encoderCgroup) attach(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(g.dir.Fd())
+}
+
+// remove deletes the cgroup, once the encoder has exited
+func (g *encoderCgroup) remove() {
+	if g.dir != nil {
+		g.dir.Close()
+	}
+	os.Remove(g.path)
+}
+
+// checkCgroupParent tells whether encoders can get cgroups under
+// cgroupParent, with the controllers their limits need
+func checkCgroupParent() error {
+	enabled, err := os.ReadFile(filepath.Join(cgroupParent, "cgroup.subtree_control"))
+	if err != nil {
+		return fmt.Errorf("not a cgroup v2 directory: %v", err)
+	}
+	controllers := strings.Fields(string(enabled))
+	if encoderCPU > 0 && !slices.Contains(controllers, "cpu") {
+		return errors.New("the cpu controller isn't enabled for its children")
+	}
+	if encoderMemory > 0 && !slices.Contains(controllers, "memory") {
+		return errors.New("the memory controller isn't enabled for its children")
+	}
+	// Try it out, since delegation can be partial
+	g, err := newEncoderCgroup(0)
+	if err != nil {
+		return err
+	}
+	g.remove()
+	return nil
+}
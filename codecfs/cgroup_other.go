@@ -0,0 +1,23 @@
+//go:build !linux
+// +build !linux
+
+package codecfs
+
+import (
+	"errors"
+	"os/exec"
+)
+
+type encoderCgroup struct{}
+
+func newEncoderCgroup(id uint64) (*encoderCgroup, error) {
+	return nil, errors.New("cgroups need Linux")
+}
+
+func (g *encoderCgroup) attach(cmd *exec.Cmd) {}
+
+func (g *encoderCgroup) remove() {}
+
+func checkCgroupParent() error {
+	return errors.New("cgroups need Linux")
+}
@@ -0,0 +1,176 @@
+package codecfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+)
+
+// Extensions of the audiobooks looked into for chapters
+var chapterExts = map[string]bool{".m4b": true, ".m4a": true}
+
+type chapter struct {
+	title      string
+	start, end time.Duration
+}
+
+type chapterEntry struct {
+	mtime    time.Time
+	chapters []chapter
+}
+
+// Chapters of audiobooks, by path
+var bookChapters sync.Map
+
+// sourceChapters gives the chapters of the source at path in r, which was
+// modified at mtime, when it is an audiobook with more than one and r shows
+// audiobooks as directories holding one file per chapter
+func (r *Root) sourceChapters(path string, mtime time.Time) []chapter {
+	if !r.opts.Chapters || !chapterExts[strings.ToLower(filepath.Ext(path))] {
+		return nil
+	}
+	if v, ok := bookChapters.Load(path); ok && v.(chapterEntry).mtime.Equal(mtime) {
+		return v.(chapterEntry).chapters
+	}
+	entry := chapterEntry{mtime: mtime}
+	if out, err := r.probe(path, "-v", "error", "-show_chapters", "-of", "json"); err == nil {
+		entry.chapters = parseChapters(out)
+	}
+	if len(entry.chapters) < 2 {
+		entry.chapters = nil
+	}
+	bookChapters.Store(path, entry)
+	return entry.chapters
+}
+
+// parseChapters reads the chapters out of the JSON output of ffprobe
+func parseChapters(out []byte) []chapter {
+	var probed struct {
+		Chapters []struct {
+			Start string            `json:"start_time"`
+			End   string            `json:"end_time"`
+			Tags  map[string]string `json:"tags"`
+		} `json:"chapters"`
+	}
+	if json.Unmarshal(out, &probed) != nil {
+		return nil
+	}
+	var chapters []chapter
+	for _, c := range probed.Chapters {
+		start, err1 := strconv.ParseFloat(c.Start, 64)
+		end, err2 := strconv.ParseFloat(c.End, 64)
+		if err1 != nil || err2 != nil || end <= start {
+			return nil
+		}
+		chapters = append(chapters, chapter{
+			title: c.Tags["title"],
+			start: time.Duration(start * float64(time.Second)),
+			end:   time.Duration(end * float64(time.Second)),
+		})
+	}
+	return chapters
+}
+
+// bookDirName gives the name of the directory of the chapters of the book
+// called name
+func bookDirName(name string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+var _ fs.HandleReadDirAller = &chapterDir{}
+var _ fs.NodeStringLookuper = &chapterDir{}
+
+// chapterDir holds the chapters of an audiobook, each transcoded on its own
+type chapterDir struct {
+	readOnly
+	// Path of the audiobook in the source
+	source  string
+	profile *profile
+}
+
+func (d *chapterDir) Attr(ctx context.Context, a *fuse.Attr) error {
+	stat, err := d.profile.root.statSource(d.source)
+	if err != nil {
+		return err
+	}
+	a.Inode = inode(d.profile.name, d.source)
+	a.Mode = os.ModeDir | 0555
+	d.profile.root.setAttr(a, stat)
+	// Searchable by whoever can read the book
+	a.Mode |= a.Mode & 0444 >> 2
+	return nil
+}
+
+// chapters gives the chapters of the book, along with the names of their
+// files
+func (d *chapterDir) chapters() ([]chapter, []string, error) {
+	r := d.profile.root
+	stat, err := r.statSource(d.source)
+	if err != nil {
+		return nil, nil, err
+	}
+	chapters := r.sourceChapters(d.source, stat.ModTime())
+	names := make([]string, len(chapters))
+	for i, c := range chapters {
+		title := c.title
+		if title == "" {
+			title = fmt.Sprintf("Chapter %d", i+1)
+		}
+		name := fmt.Sprintf("%0*d %s%s", len(strconv.Itoa(len(chapters))), i+1, strings.ReplaceAll(title, "/", "-"), d.profile.ext)
+		if r.opts.SanitizeNames {
+			name = r.sanitizeName(name)
+		}
+		names[i] = r.normalizeName(name)
+	}
+	return chapters, names, nil
+}
+
+func (d *chapterDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	_, names, err := d.chapters()
+	if err != nil {
+		return nil, err
+	}
+	ents := make([]fuse.Dirent, len(names))
+	for i, name := range names {
+		ents[i] = fuse.Dirent{
+			Inode: inode(d.profile.name, filepath.Join(d.source, name)),
+			Type:  fuse.DT_File,
+			Name:  name,
+		}
+	}
+	return ents, nil
+}
+
+func (d *chapterDir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	chapters, names, err := d.chapters()
+	if err != nil {
+		return nil, err
+	}
+	name = d.profile.root.normalizeName(name)
+	for i, n := range names {
+		if n != name && !(d.profile.root.opts.IgnoreCase && strings.EqualFold(n, name)) {
+			continue
+		}
+		c := chapters[i]
+		virtualName := filepath.Join(d.source, n)
+		return node(d.profile.key(virtualName), func() fs.Node {
+			clip := *d.profile
+			clip.clipStart, clip.clipEnd = c.start, c.end
+			return &file{
+				name:    virtualName,
+				source:  d.source,
+				profile: &clip,
+			}
+		}), nil
+	}
+	return nil, fuse.ENOENT
+}
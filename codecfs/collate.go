@@ -0,0 +1,99 @@
+package codecfs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Orders of directory listings, which simple players play files in:
+//
+//   - bytes: byte order, so 10 comes before 2
+//   - natural: runs of digits compared as numbers, so 2 comes before 10
+//   - folded: natural, also ignoring case and accents
+var sortOrders = []string{"bytes", "natural", "folded"}
+
+func validSortOrder(order string) error {
+	for _, known := range sortOrders {
+		if order == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown sort order %q, must be one of %s", order, strings.Join(sortOrders, ", "))
+}
+
+// sortNames sorts names in the order of the listings of r
+func (r *Root) sortNames(names []string) {
+	if r.opts.Sort == "bytes" {
+		sort.Strings(names)
+		return
+	}
+	sort.SliceStable(names, func(i, j int) bool { return r.lessNames(names[i], names[j]) })
+}
+
+// lessNames tells whether name a comes before name b in the listings of r.
+// Names that collate the same are ordered by bytes, so that the order is
+// the same on every listing.
+func (r *Root) lessNames(a, b string) bool {
+	if r.opts.Sort == "bytes" {
+		return a < b
+	}
+	if c := collate(a, b, r.opts.Sort == "folded"); c != 0 {
+		return c < 0
+	}
+	return a < b
+}
+
+// collate compares a and b with numbers compared by value, and case and
+// accents ignored when folded
+func collate(a, b string, folded bool) int {
+	if folded {
+		a, b = foldName(a), foldName(b)
+	}
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			na, nb := digits(a), digits(b)
+			// Compare the values without leading zeroes, by length
+			// first so that they can be as long as they please
+			va, vb := strings.TrimLeft(na, "0"), strings.TrimLeft(nb, "0")
+			if len(va) != len(vb) {
+				return len(va) - len(vb)
+			}
+			if c := strings.Compare(va, vb); c != 0 {
+				return c
+			}
+			a, b = a[len(na):], b[len(nb):]
+			continue
+		}
+		if a[0] != b[0] {
+			return int(a[0]) - int(b[0])
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) - len(b)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// digits gives the run of digits s starts with
+func digits(s string) string {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i]
+}
+
+// foldName lowers the case of name and drops its accents
+func foldName(name string) string {
+	var b strings.Builder
+	for _, r := range nfd(name) {
+		if !unicode.Is(unicode.Mn, r) {
+			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,123 @@
+package codecfs
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Name of the file that can be dropped in any source directory to override
+// the profile for that directory and everything below it
+const dirConfigName = ".codecfs.toml"
+
+// dirConfig is the content of a dirConfigName file. Every field is optional;
+// unset fields are inherited from the parent directory.
+type dirConfig struct {
+	Quality     *float64 `toml:"quality"`
+	Bitrate     *string  `toml:"bitrate"`
+	RateControl *string  `toml:"rate_control"`
+	Opus        struct {
+		Application   *string  `toml:"application"`
+		FrameDuration *float64 `toml:"frame_duration"`
+		Complexity    *int     `toml:"complexity"`
+	} `toml:"opus"`
+	MinBitrate *int64   `toml:"min_bitrate"`
+	SampleRate *int     `toml:"sample_rate"`
+	Channels   *int     `toml:"channels"`
+	Exclude    []string `toml:"exclude"`
+	Include    []string `toml:"include"`
+}
+
+// forDir returns the profile to use for the source directory at path: p
+// itself if there's no override, or a modified copy of p otherwise
+func (p *profile) forDir(path string) *profile {
+	if p.passthrough {
+		return p
+	}
+
+	var conf dirConfig
+	f, err := p.root.src.Open(filepath.Join(path, dirConfigName))
+	if err == nil {
+		_, err = toml.NewDecoder(f).Decode(&conf)
+		f.Close()
+	}
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("Ignoring invalid directory config", "path", filepath.Join(path, dirConfigName), "err", err)
+		}
+		return p
+	}
+
+	override := *p
+	if conf.Quality != nil && !p.fixedQuality {
+		override.quality = strconv.FormatFloat(*conf.Quality, 'g', -1, 64)
+	}
+	if conf.Bitrate != nil && !p.fixedQuality {
+		override.bitrate = *conf.Bitrate
+	}
+	if conf.RateControl != nil && !p.fixedQuality {
+		override.rateControl = *conf.RateControl
+	}
+	if err := validRateControl(override.rateControl, override.bitrate); err != nil {
+		slog.Warn("Ignoring invalid rate control", "path", filepath.Join(path, dirConfigName), "err", err)
+		override.rateControl = p.rateControl
+	}
+	if conf.Opus.Application != nil {
+		override.opus.application = *conf.Opus.Application
+	}
+	if conf.Opus.FrameDuration != nil {
+		override.opus.frameDuration = strconv.FormatFloat(*conf.Opus.FrameDuration, 'g', -1, 64)
+	}
+	if conf.Opus.Complexity != nil {
+		override.opus.complexity = strconv.Itoa(*conf.Opus.Complexity)
+	}
+	if err := override.opus.validate(); err != nil {
+		slog.Warn("Ignoring invalid opus settings", "path", filepath.Join(path, dirConfigName), "err", err)
+		override.opus = p.opus
+	}
+	// Lossless and wav trees are for the files as they sound, not as small
+	// as they can be
+	if conf.MinBitrate != nil && override.audio() && !override.lossless && override.pcmCodec == "" {
+		override.minBitrate = *conf.MinBitrate
+	}
+	if conf.SampleRate != nil {
+		override.sampleRate = *conf.SampleRate
+	}
+	if conf.Channels != nil {
+		override.channels = *conf.Channels
+	}
+	if err := validOutput(override.sampleRate, override.channels, override.minBitrate); err != nil {
+		slog.Warn("Ignoring invalid output settings", "path", filepath.Join(path, dirConfigName), "err", err)
+		override.sampleRate, override.channels, override.minBitrate = p.sampleRate, p.channels, p.minBitrate
+	}
+	// Lists whose patterns are all invalid are ignored as a whole, so that
+	// inclusions don't turn into including everything
+	if conf.Exclude != nil {
+		if exclude := validGlobs(filepath.Join(path, dirConfigName), conf.Exclude); len(exclude) > 0 || len(conf.Exclude) == 0 {
+			override.exclude = exclude
+		}
+	}
+	if conf.Include != nil {
+		if include := validGlobs(filepath.Join(path, dirConfigName), conf.Include); len(include) > 0 || len(conf.Include) == 0 {
+			override.include = include
+		}
+	}
+	return &override
+}
+
+// validGlobs gives the patterns that are valid globs, warning about the
+// others, found in the config at path
+func validGlobs(path string, patterns []string) []string {
+	valid := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			slog.Warn("Ignoring invalid pattern", "path", path, "pattern", pattern, "err", err)
+			continue
+		}
+		valid = append(valid, pattern)
+	}
+	return valid
+}
@@ -0,0 +1,48 @@
+package codecfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirConfigInvalidOutput(t *testing.T) {
+	src := t.TempDir()
+	conf := "channels = 5\nsample_rate = -1\nmin_bitrate = -8\n"
+	if err := os.WriteFile(filepath.Join(src, dirConfigName), []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := DefaultOptions()
+	opts.SizeCache = ""
+	opts.Watch = false
+	r, err := New(src, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := r.ogg.forDir(src)
+	if p.channels != r.ogg.channels || p.sampleRate != r.ogg.sampleRate || p.minBitrate != r.ogg.minBitrate {
+		t.Errorf("invalid settings were applied: channels %d, sample rate %d, min bitrate %d", p.channels, p.sampleRate, p.minBitrate)
+	}
+}
+
+func TestDirConfigInvalidGlobs(t *testing.T) {
+	src := t.TempDir()
+	conf := "exclude = [\"[\", \"*.log\"]\ninclude = [\"a[\"]\n"
+	if err := os.WriteFile(filepath.Join(src, dirConfigName), []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := DefaultOptions()
+	opts.SizeCache = ""
+	opts.Watch = false
+	r, err := New(src, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := r.ogg.forDir(src)
+	if len(p.exclude) != 1 || p.exclude[0] != "*.log" {
+		t.Errorf("exclude patterns %q", p.exclude)
+	}
+	if len(p.include) != len(r.ogg.include) {
+		t.Errorf("include patterns %q", p.include)
+	}
+}
@@ -0,0 +1,215 @@
+package codecfs
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"time"
+)
+
+// The control socket speaks JSON, one object per line in each direction.
+// Requests look like
+//
+//	{"cmd": "list"}
+//	{"cmd": "cancel", "id": 3}
+//	{"cmd": "sizes"}
+//	{"cmd": "prune"}
+//	{"cmd": "health"}
+//	{"cmd": "reload"}
+//
+// and each gets one response.
+type ControlRequest struct {
+	Cmd string `json:"cmd"`
+	ID  uint64 `json:"id,omitempty"`
+}
+
+// ControlResponse is the response to a ControlRequest, with an Error if it
+// failed
+type ControlResponse struct {
+	Error      string            `json:"error,omitempty"`
+	Transcodes []TranscodeStatus `json:"transcodes,omitempty"`
+	Sizes      map[string]uint64 `json:"sizes,omitempty"`
+	Health     []HealthCheck     `json:"health,omitempty"`
+}
+
+// TranscodeStatus describes a running transcode
+type TranscodeStatus struct {
+	ID       uint64    `json:"id"`
+	Source   string    `json:"source"`
+	Profile  string    `json:"profile"`
+	Pid      int       `json:"pid"`
+	Started  time.Time `json:"started"`
+	Produced int64     `json:"bytes_produced"`
+	// Estimated from how long the output encoded so far plays, when
+	// known
+	Percent float64 `json:"percent,omitempty"`
+}
+
+func activeTranscodeStatus() []TranscodeStatus {
+	out := []TranscodeStatus{}
+	transcodes.Range(func(k, v interface{}) bool {
+		t := v.(*transcode)
+		percent, _ := t.percent()
+		out = append(out, TranscodeStatus{
+			ID:       t.id,
+			Source:   t.source,
+			Profile:  t.profile.name,
+			Pid:      t.cmd.Process.Pid,
+			Started:  t.started,
+			Produced: t.produced.Load(),
+			Percent:  percent,
+		})
+		return true
+	})
+	return out
+}
+
+// ListenControl listens for control connections on the unix socket at path.
+// It only returns when the socket can't be opened.
+func ListenControl(path string) error {
+	l, err := listenUnix(path)
+	if err != nil {
+		return err
+	}
+	var delay time.Duration
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			delay = acceptBackoff(delay)
+			slog.Warn("Control socket error", "err", err, "retry", delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		go handleControl(conn)
+	}
+}
+
+// listenUnix listens on the unix socket at path. A socket left there by a
+// previous run would make it fail, so it is removed first, but nothing else
+// is.
+func listenUnix(path string) (net.Listener, error) {
+	if stat, err := os.Lstat(path); err == nil && stat.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	return net.Listen("unix", path)
+}
+
+// acceptBackoff gives how long to wait after a failed Accept, the previous
+// wait being delay, so that running out of file descriptors doesn't spin
+func acceptBackoff(delay time.Duration) time.Duration {
+	if delay == 0 {
+		return 5 * time.Millisecond
+	}
+	return min(2*delay, time.Second)
+}
+
+func handleControl(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	enc := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var req ControlRequest
+		var resp ControlResponse
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			resp.Error = err.Error()
+		} else {
+			resp = handleControlRequest(req)
+		}
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+func handleControlRequest(req ControlRequest) ControlResponse {
+	switch req.Cmd {
+	case "list":
+		return ControlResponse{Transcodes: activeTranscodeStatus()}
+	case "cancel":
+		if err := cancelTranscode(req.ID); err != nil {
+			return ControlResponse{Error: err.Error()}
+		}
+		return ControlResponse{}
+	case "sizes":
+		sizes := make(map[string]uint64)
+		allSizes.Range(func(k, v interface{}) bool {
+			key := k.(sizeKey)
+			sizes[key.profile+":"+key.name] = v.(knownSize).size
+			return true
+		})
+		return ControlResponse{Sizes: sizes}
+	case "prune":
+		pruneCaches()
+		return ControlResponse{}
+	case "reload":
+		if err := Reload(); err != nil {
+			return ControlResponse{Error: err.Error()}
+		}
+		return ControlResponse{}
+	case "health":
+		checks, _ := CheckHealth()
+		return ControlResponse{Health: checks}
+	default:
+		return ControlResponse{Error: fmt.Sprintf("unknown command %q", req.Cmd)}
+	}
+}
+
+// errNoTranscode is returned for transcode IDs that aren't running
+var errNoTranscode = errors.New("no transcode with id")
+
+// cancelTranscode kills the encoder of the running transcode with the given
+// ID
+func cancelTranscode(id uint64) error {
+	v, ok := transcodes.Load(id)
+	if !ok {
+		return fmt.Errorf("%w %d", errNoTranscode, id)
+	}
+	if err := v.(*transcode).cancel(); err != nil {
+		return err
+	}
+	slog.Info("Transcode cancelled", "id", id)
+	return nil
+}
+
+// pruneCaches forgets the exact sizes and the listings known
+func pruneCaches() {
+	allSizes.Range(func(k, v interface{}) bool {
+		allSizes.Delete(k)
+		return true
+	})
+	clearPersistedSizes()
+	dirCache.Range(func(k, v interface{}) bool {
+		dirCache.Delete(k)
+		return true
+	})
+	slog.Info("Caches pruned")
+	emit(Event{Type: eventCacheEvicted})
+}
+
+// ControlCall sends one request to the control socket at path
+func ControlCall(path string, req ControlRequest) (ControlResponse, error) {
+	var resp ControlResponse
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return resp, err
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return resp, err
+	}
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return resp, err
+	}
+	if resp.Error != "" {
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
@@ -0,0 +1,70 @@
+package codecfs
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startControl serves the control socket at path, and waits for it to
+// answer
+func startControl(t *testing.T, path string) {
+	t.Helper()
+	go ListenControl(path)
+	for i := 0; ; i++ {
+		if _, err := ControlCall(path, ControlRequest{Cmd: "list"}); err == nil {
+			return
+		} else if i == 100 {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestControlSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control")
+	startControl(t, path)
+
+	resp, err := ControlCall(path, ControlRequest{Cmd: "list"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Transcodes) != 0 {
+		t.Errorf("transcodes running: %v", resp.Transcodes)
+	}
+	_, err = ControlCall(path, ControlRequest{Cmd: "cancel", ID: 1 << 40})
+	if err == nil || !strings.HasPrefix(err.Error(), errNoTranscode.Error()) {
+		t.Errorf("cancelling a missing transcode: %v", err)
+	}
+	if _, err := ControlCall(path, ControlRequest{Cmd: "bogus"}); err == nil {
+		t.Error("unknown command accepted")
+	}
+}
+
+func TestControlSocketReplacesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control")
+	l, err := listenUnix(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Left behind as by a crash
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	startControl(t, path)
+}
+
+func TestControlSocketKeepsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control")
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ListenControl(path); err == nil {
+		t.Fatal("listening over a regular file")
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "data" {
+		t.Errorf("regular file replaced: %q, %v", data, err)
+	}
+}
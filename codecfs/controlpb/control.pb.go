@@ -0,0 +1,778 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: codecfs/controlpb/control.proto
+
+package controlpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Transcode is a running transcode.
+type Transcode struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    uint64                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	// Path of the source file.
+	Source string `protobuf:"bytes,2,opt,name=source,proto3" json:"source,omitempty"`
+	// Tree the file is read from, as in opus-96.
+	Profile string `protobuf:"bytes,3,opt,name=profile,proto3" json:"profile,omitempty"`
+	// Process ID of the encoder.
+	Pid           int32                  `protobuf:"varint,4,opt,name=pid,proto3" json:"pid,omitempty"`
+	Started       *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=started,proto3" json:"started,omitempty"`
+	BytesProduced int64                  `protobuf:"varint,6,opt,name=bytes_produced,json=bytesProduced,proto3" json:"bytes_produced,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Transcode) Reset() {
+	*x = Transcode{}
+	mi := &file_codecfs_controlpb_control_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Transcode) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Transcode) ProtoMessage() {}
+
+func (x *Transcode) ProtoReflect() protoreflect.Message {
+	mi := &file_codecfs_controlpb_control_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Transcode.ProtoReflect.Descriptor instead.
+func (*Transcode) Descriptor() ([]byte, []int) {
+	return file_codecfs_controlpb_control_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Transcode) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Transcode) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *Transcode) GetProfile() string {
+	if x != nil {
+		return x.Profile
+	}
+	return ""
+}
+
+func (x *Transcode) GetPid() int32 {
+	if x != nil {
+		return x.Pid
+	}
+	return 0
+}
+
+func (x *Transcode) GetStarted() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Started
+	}
+	return nil
+}
+
+func (x *Transcode) GetBytesProduced() int64 {
+	if x != nil {
+		return x.BytesProduced
+	}
+	return 0
+}
+
+type ListTranscodesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListTranscodesRequest) Reset() {
+	*x = ListTranscodesRequest{}
+	mi := &file_codecfs_controlpb_control_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListTranscodesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListTranscodesRequest) ProtoMessage() {}
+
+func (x *ListTranscodesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_codecfs_controlpb_control_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListTranscodesRequest.ProtoReflect.Descriptor instead.
+func (*ListTranscodesRequest) Descriptor() ([]byte, []int) {
+	return file_codecfs_controlpb_control_proto_rawDescGZIP(), []int{1}
+}
+
+type ListTranscodesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Transcodes    []*Transcode           `protobuf:"bytes,1,rep,name=transcodes,proto3" json:"transcodes,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListTranscodesResponse) Reset() {
+	*x = ListTranscodesResponse{}
+	mi := &file_codecfs_controlpb_control_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListTranscodesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListTranscodesResponse) ProtoMessage() {}
+
+func (x *ListTranscodesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_codecfs_controlpb_control_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListTranscodesResponse.ProtoReflect.Descriptor instead.
+func (*ListTranscodesResponse) Descriptor() ([]byte, []int) {
+	return file_codecfs_controlpb_control_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ListTranscodesResponse) GetTranscodes() []*Transcode {
+	if x != nil {
+		return x.Transcodes
+	}
+	return nil
+}
+
+type CancelTranscodeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            uint64                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CancelTranscodeRequest) Reset() {
+	*x = CancelTranscodeRequest{}
+	mi := &file_codecfs_controlpb_control_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CancelTranscodeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CancelTranscodeRequest) ProtoMessage() {}
+
+func (x *CancelTranscodeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_codecfs_controlpb_control_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CancelTranscodeRequest.ProtoReflect.Descriptor instead.
+func (*CancelTranscodeRequest) Descriptor() ([]byte, []int) {
+	return file_codecfs_controlpb_control_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *CancelTranscodeRequest) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+type CancelTranscodeResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CancelTranscodeResponse) Reset() {
+	*x = CancelTranscodeResponse{}
+	mi := &file_codecfs_controlpb_control_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CancelTranscodeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CancelTranscodeResponse) ProtoMessage() {}
+
+func (x *CancelTranscodeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_codecfs_controlpb_control_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CancelTranscodeResponse.ProtoReflect.Descriptor instead.
+func (*CancelTranscodeResponse) Descriptor() ([]byte, []int) {
+	return file_codecfs_controlpb_control_proto_rawDescGZIP(), []int{4}
+}
+
+// Size is the exact size of a transcoded file.
+type Size struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Tree          string                 `protobuf:"bytes,1,opt,name=tree,proto3" json:"tree,omitempty"`
+	Name          string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Size          uint64                 `protobuf:"varint,3,opt,name=size,proto3" json:"size,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Size) Reset() {
+	*x = Size{}
+	mi := &file_codecfs_controlpb_control_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Size) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Size) ProtoMessage() {}
+
+func (x *Size) ProtoReflect() protoreflect.Message {
+	mi := &file_codecfs_controlpb_control_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Size.ProtoReflect.Descriptor instead.
+func (*Size) Descriptor() ([]byte, []int) {
+	return file_codecfs_controlpb_control_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Size) GetTree() string {
+	if x != nil {
+		return x.Tree
+	}
+	return ""
+}
+
+func (x *Size) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Size) GetSize() uint64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+type ListSizesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListSizesRequest) Reset() {
+	*x = ListSizesRequest{}
+	mi := &file_codecfs_controlpb_control_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListSizesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListSizesRequest) ProtoMessage() {}
+
+func (x *ListSizesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_codecfs_controlpb_control_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListSizesRequest.ProtoReflect.Descriptor instead.
+func (*ListSizesRequest) Descriptor() ([]byte, []int) {
+	return file_codecfs_controlpb_control_proto_rawDescGZIP(), []int{6}
+}
+
+type ListSizesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Sizes         []*Size                `protobuf:"bytes,1,rep,name=sizes,proto3" json:"sizes,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListSizesResponse) Reset() {
+	*x = ListSizesResponse{}
+	mi := &file_codecfs_controlpb_control_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListSizesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListSizesResponse) ProtoMessage() {}
+
+func (x *ListSizesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_codecfs_controlpb_control_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListSizesResponse.ProtoReflect.Descriptor instead.
+func (*ListSizesResponse) Descriptor() ([]byte, []int) {
+	return file_codecfs_controlpb_control_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ListSizesResponse) GetSizes() []*Size {
+	if x != nil {
+		return x.Sizes
+	}
+	return nil
+}
+
+type PruneCacheRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PruneCacheRequest) Reset() {
+	*x = PruneCacheRequest{}
+	mi := &file_codecfs_controlpb_control_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PruneCacheRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PruneCacheRequest) ProtoMessage() {}
+
+func (x *PruneCacheRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_codecfs_controlpb_control_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PruneCacheRequest.ProtoReflect.Descriptor instead.
+func (*PruneCacheRequest) Descriptor() ([]byte, []int) {
+	return file_codecfs_controlpb_control_proto_rawDescGZIP(), []int{8}
+}
+
+type PruneCacheResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PruneCacheResponse) Reset() {
+	*x = PruneCacheResponse{}
+	mi := &file_codecfs_controlpb_control_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PruneCacheResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PruneCacheResponse) ProtoMessage() {}
+
+func (x *PruneCacheResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_codecfs_controlpb_control_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PruneCacheResponse.ProtoReflect.Descriptor instead.
+func (*PruneCacheResponse) Descriptor() ([]byte, []int) {
+	return file_codecfs_controlpb_control_proto_rawDescGZIP(), []int{9}
+}
+
+type WarmRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Slash-separated path of a file or directory, from the top of the
+	// mount, as in opus-96/Artist/Album.
+	Path          string `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WarmRequest) Reset() {
+	*x = WarmRequest{}
+	mi := &file_codecfs_controlpb_control_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WarmRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WarmRequest) ProtoMessage() {}
+
+func (x *WarmRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_codecfs_controlpb_control_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WarmRequest.ProtoReflect.Descriptor instead.
+func (*WarmRequest) Descriptor() ([]byte, []int) {
+	return file_codecfs_controlpb_control_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *WarmRequest) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+type WarmResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WarmResponse) Reset() {
+	*x = WarmResponse{}
+	mi := &file_codecfs_controlpb_control_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WarmResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WarmResponse) ProtoMessage() {}
+
+func (x *WarmResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_codecfs_controlpb_control_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WarmResponse.ProtoReflect.Descriptor instead.
+func (*WarmResponse) Descriptor() ([]byte, []int) {
+	return file_codecfs_controlpb_control_proto_rawDescGZIP(), []int{11}
+}
+
+type SetLogLevelRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// debug, info, warn or error.
+	Level         string `protobuf:"bytes,1,opt,name=level,proto3" json:"level,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetLogLevelRequest) Reset() {
+	*x = SetLogLevelRequest{}
+	mi := &file_codecfs_controlpb_control_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetLogLevelRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetLogLevelRequest) ProtoMessage() {}
+
+func (x *SetLogLevelRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_codecfs_controlpb_control_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetLogLevelRequest.ProtoReflect.Descriptor instead.
+func (*SetLogLevelRequest) Descriptor() ([]byte, []int) {
+	return file_codecfs_controlpb_control_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *SetLogLevelRequest) GetLevel() string {
+	if x != nil {
+		return x.Level
+	}
+	return ""
+}
+
+type SetLogLevelResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Level before the change.
+	Previous      string `protobuf:"bytes,1,opt,name=previous,proto3" json:"previous,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetLogLevelResponse) Reset() {
+	*x = SetLogLevelResponse{}
+	mi := &file_codecfs_controlpb_control_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetLogLevelResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetLogLevelResponse) ProtoMessage() {}
+
+func (x *SetLogLevelResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_codecfs_controlpb_control_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetLogLevelResponse.ProtoReflect.Descriptor instead.
+func (*SetLogLevelResponse) Descriptor() ([]byte, []int) {
+	return file_codecfs_controlpb_control_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *SetLogLevelResponse) GetPrevious() string {
+	if x != nil {
+		return x.Previous
+	}
+	return ""
+}
+
+var File_codecfs_controlpb_control_proto protoreflect.FileDescriptor
+
+const file_codecfs_controlpb_control_proto_rawDesc = "" +
+	"\n" +
+	"\x1fcodecfs/controlpb/control.proto\x12\x12codecfs.control.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xbc\x01\n" +
+	"\tTranscode\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x04R\x02id\x12\x16\n" +
+	"\x06source\x18\x02 \x01(\tR\x06source\x12\x18\n" +
+	"\aprofile\x18\x03 \x01(\tR\aprofile\x12\x10\n" +
+	"\x03pid\x18\x04 \x01(\x05R\x03pid\x124\n" +
+	"\astarted\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\astarted\x12%\n" +
+	"\x0ebytes_produced\x18\x06 \x01(\x03R\rbytesProduced\"\x17\n" +
+	"\x15ListTranscodesRequest\"W\n" +
+	"\x16ListTranscodesResponse\x12=\n" +
+	"\n" +
+	"transcodes\x18\x01 \x03(\v2\x1d.codecfs.control.v1.TranscodeR\n" +
+	"transcodes\"(\n" +
+	"\x16CancelTranscodeRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x04R\x02id\"\x19\n" +
+	"\x17CancelTranscodeResponse\"B\n" +
+	"\x04Size\x12\x12\n" +
+	"\x04tree\x18\x01 \x01(\tR\x04tree\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x12\n" +
+	"\x04size\x18\x03 \x01(\x04R\x04size\"\x12\n" +
+	"\x10ListSizesRequest\"C\n" +
+	"\x11ListSizesResponse\x12.\n" +
+	"\x05sizes\x18\x01 \x03(\v2\x18.codecfs.control.v1.SizeR\x05sizes\"\x13\n" +
+	"\x11PruneCacheRequest\"\x14\n" +
+	"\x12PruneCacheResponse\"!\n" +
+	"\vWarmRequest\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\"\x0e\n" +
+	"\fWarmResponse\"*\n" +
+	"\x12SetLogLevelRequest\x12\x14\n" +
+	"\x05level\x18\x01 \x01(\tR\x05level\"1\n" +
+	"\x13SetLogLevelResponse\x12\x1a\n" +
+	"\bprevious\x18\x01 \x01(\tR\bprevious2\xc0\x04\n" +
+	"\aControl\x12g\n" +
+	"\x0eListTranscodes\x12).codecfs.control.v1.ListTranscodesRequest\x1a*.codecfs.control.v1.ListTranscodesResponse\x12j\n" +
+	"\x0fCancelTranscode\x12*.codecfs.control.v1.CancelTranscodeRequest\x1a+.codecfs.control.v1.CancelTranscodeResponse\x12X\n" +
+	"\tListSizes\x12$.codecfs.control.v1.ListSizesRequest\x1a%.codecfs.control.v1.ListSizesResponse\x12[\n" +
+	"\n" +
+	"PruneCache\x12%.codecfs.control.v1.PruneCacheRequest\x1a&.codecfs.control.v1.PruneCacheResponse\x12I\n" +
+	"\x04Warm\x12\x1f.codecfs.control.v1.WarmRequest\x1a .codecfs.control.v1.WarmResponse\x12^\n" +
+	"\vSetLogLevel\x12&.codecfs.control.v1.SetLogLevelRequest\x1a'.codecfs.control.v1.SetLogLevelResponseB,Z*github.com/rakoo/codecfs/codecfs/controlpbb\x06proto3"
+
+var (
+	file_codecfs_controlpb_control_proto_rawDescOnce sync.Once
+	file_codecfs_controlpb_control_proto_rawDescData []byte
+)
+
+func file_codecfs_controlpb_control_proto_rawDescGZIP() []byte {
+	file_codecfs_controlpb_control_proto_rawDescOnce.Do(func() {
+		file_codecfs_controlpb_control_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_codecfs_controlpb_control_proto_rawDesc), len(file_codecfs_controlpb_control_proto_rawDesc)))
+	})
+	return file_codecfs_controlpb_control_proto_rawDescData
+}
+
+var file_codecfs_controlpb_control_proto_msgTypes = make([]protoimpl.MessageInfo, 14)
+var file_codecfs_controlpb_control_proto_goTypes = []any{
+	(*Transcode)(nil),               // 0: codecfs.control.v1.Transcode
+	(*ListTranscodesRequest)(nil),   // 1: codecfs.control.v1.ListTranscodesRequest
+	(*ListTranscodesResponse)(nil),  // 2: codecfs.control.v1.ListTranscodesResponse
+	(*CancelTranscodeRequest)(nil),  // 3: codecfs.control.v1.CancelTranscodeRequest
+	(*CancelTranscodeResponse)(nil), // 4: codecfs.control.v1.CancelTranscodeResponse
+	(*Size)(nil),                    // 5: codecfs.control.v1.Size
+	(*ListSizesRequest)(nil),        // 6: codecfs.control.v1.ListSizesRequest
+	(*ListSizesResponse)(nil),       // 7: codecfs.control.v1.ListSizesResponse
+	(*PruneCacheRequest)(nil),       // 8: codecfs.control.v1.PruneCacheRequest
+	(*PruneCacheResponse)(nil),      // 9: codecfs.control.v1.PruneCacheResponse
+	(*WarmRequest)(nil),             // 10: codecfs.control.v1.WarmRequest
+	(*WarmResponse)(nil),            // 11: codecfs.control.v1.WarmResponse
+	(*SetLogLevelRequest)(nil),      // 12: codecfs.control.v1.SetLogLevelRequest
+	(*SetLogLevelResponse)(nil),     // 13: codecfs.control.v1.SetLogLevelResponse
+	(*timestamppb.Timestamp)(nil),   // 14: google.protobuf.Timestamp
+}
+var file_codecfs_controlpb_control_proto_depIdxs = []int32{
+	14, // 0: codecfs.control.v1.Transcode.started:type_name -> google.protobuf.Timestamp
+	0,  // 1: codecfs.control.v1.ListTranscodesResponse.transcodes:type_name -> codecfs.control.v1.Transcode
+	5,  // 2: codecfs.control.v1.ListSizesResponse.sizes:type_name -> codecfs.control.v1.Size
+	1,  // 3: codecfs.control.v1.Control.ListTranscodes:input_type -> codecfs.control.v1.ListTranscodesRequest
+	3,  // 4: codecfs.control.v1.Control.CancelTranscode:input_type -> codecfs.control.v1.CancelTranscodeRequest
+	6,  // 5: codecfs.control.v1.Control.ListSizes:input_type -> codecfs.control.v1.ListSizesRequest
+	8,  // 6: codecfs.control.v1.Control.PruneCache:input_type -> codecfs.control.v1.PruneCacheRequest
+	10, // 7: codecfs.control.v1.Control.Warm:input_type -> codecfs.control.v1.WarmRequest
+	12, // 8: codecfs.control.v1.Control.SetLogLevel:input_type -> codecfs.control.v1.SetLogLevelRequest
+	2,  // 9: codecfs.control.v1.Control.ListTranscodes:output_type -> codecfs.control.v1.ListTranscodesResponse
+	4,  // 10: codecfs.control.v1.Control.CancelTranscode:output_type -> codecfs.control.v1.CancelTranscodeResponse
+	7,  // 11: codecfs.control.v1.Control.ListSizes:output_type -> codecfs.control.v1.ListSizesResponse
+	9,  // 12: codecfs.control.v1.Control.PruneCache:output_type -> codecfs.control.v1.PruneCacheResponse
+	11, // 13: codecfs.control.v1.Control.Warm:output_type -> codecfs.control.v1.WarmResponse
+	13, // 14: codecfs.control.v1.Control.SetLogLevel:output_type -> codecfs.control.v1.SetLogLevelResponse
+	9,  // [9:15] is the sub-list for method output_type
+	3,  // [3:9] is the sub-list for method input_type
+	3,  // [3:3] is the sub-list for extension type_name
+	3,  // [3:3] is the sub-list for extension extendee
+	0,  // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_codecfs_controlpb_control_proto_init() }
+func file_codecfs_controlpb_control_proto_init() {
+	if File_codecfs_controlpb_control_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_codecfs_controlpb_control_proto_rawDesc), len(file_codecfs_controlpb_control_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   14,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_codecfs_controlpb_control_proto_goTypes,
+		DependencyIndexes: file_codecfs_controlpb_control_proto_depIdxs,
+		MessageInfos:      file_codecfs_controlpb_control_proto_msgTypes,
+	}.Build()
+	File_codecfs_controlpb_control_proto = out.File
+	file_codecfs_controlpb_control_proto_goTypes = nil
+	file_codecfs_controlpb_control_proto_depIdxs = nil
+}
@@ -0,0 +1,5 @@
+// Package controlpb is the gRPC API managing a running codecfs, served with
+// -grpc-addr.
+package controlpb
+
+//go:generate protoc -I ../.. --go_out=../.. --go_opt=paths=source_relative --go-grpc_out=../.. --go-grpc_opt=paths=source_relative codecfs/controlpb/control.proto
@@ -0,0 +1,78 @@
+package codecfs
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"syscall"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/webdav"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+)
+
+// davFS exposes the tree under root to the WebDAV handler, read-only
+type davFS struct {
+	root fs.Node
+}
+
+func (d davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return os.ErrPermission
+}
+
+func (d davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, os.ErrPermission
+	}
+	f, err := openPath(ctx, d.root, name)
+	return f, davError(err)
+}
+
+func (d davFS) RemoveAll(ctx context.Context, name string) error {
+	return os.ErrPermission
+}
+
+func (d davFS) Rename(ctx context.Context, oldName, newName string) error {
+	return os.ErrPermission
+}
+
+func (d davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	f, err := openPath(ctx, d.root, name)
+	if err != nil {
+		return nil, davError(err)
+	}
+	return f.Stat()
+}
+
+// ContentType spares the WebDAV handler from reading files to sniff their
+// type, which would start an encoder for each file of a listing
+func (fi treeFileInfo) ContentType(ctx context.Context) (string, error) {
+	if t := fi.contentType(); t != "" {
+		return t, nil
+	}
+	return "", webdav.ErrNotImplemented
+}
+
+// davError turns the errors of the tree into system errors, which the
+// WebDAV handler knows how to report
+func davError(err error) error {
+	var errno fuse.Errno
+	if errors.As(err, &errno) {
+		return syscall.Errno(errno)
+	}
+	return err
+}
+
+// ListenWebDAV serves the tree over WebDAV on addr
+func (r *Root) ListenWebDAV(addr string) error {
+	return http.ListenAndServe(addr, r.davHandler())
+}
+
+func (r *Root) davHandler() http.Handler {
+	return &webdav.Handler{
+		FileSystem: davFS{r},
+		LockSystem: webdav.NewMemLS(),
+	}
+}
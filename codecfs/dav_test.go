@@ -0,0 +1,85 @@
+package codecfs
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// davRequest sends a request to the WebDAV handler of a source with a text
+// file and an album directory
+func davRequest(t *testing.T, method, path string, header map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	src := t.TempDir()
+	if err := os.Mkdir(filepath.Join(src, "album"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "notes.txt"), []byte("liner notes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := DefaultOptions()
+	opts.SizeCache = ""
+	opts.Watch = false
+	r, err := New(src, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(method, path, strings.NewReader(""))
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	r.davHandler().ServeHTTP(w, req)
+	return w
+}
+
+func TestWebDAVGet(t *testing.T) {
+	w := davRequest(t, "GET", "/ogg/notes.txt", nil)
+	if w.Code != http.StatusOK || w.Body.String() != "liner notes" {
+		t.Errorf("answered %d: %q", w.Code, w.Body)
+	}
+	w = davRequest(t, "GET", "/ogg/notes.txt", map[string]string{"Range": "bytes=6-"})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "notes" {
+		t.Errorf("answered %d to a range: %q", w.Code, w.Body)
+	}
+	if w := davRequest(t, "GET", "/ogg/missing.txt", nil); w.Code != http.StatusNotFound {
+		t.Errorf("answered %d for a missing file", w.Code)
+	}
+}
+
+func TestWebDAVPropfind(t *testing.T) {
+	w := davRequest(t, "PROPFIND", "/ogg/", map[string]string{"Depth": "1"})
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("answered %d: %s", w.Code, w.Body)
+	}
+	var ms struct {
+		Responses []struct {
+			Href string `xml:"href"`
+		} `xml:"response"`
+	}
+	if err := xml.NewDecoder(w.Body).Decode(&ms); err != nil {
+		t.Fatal(err)
+	}
+	var hrefs []string
+	for _, r := range ms.Responses {
+		hrefs = append(hrefs, r.Href)
+	}
+	slices.Sort(hrefs)
+	if !slices.Equal(hrefs, []string{"/ogg/", "/ogg/album/", "/ogg/notes.txt"}) {
+		t.Errorf("listed %q", hrefs)
+	}
+}
+
+func TestWebDAVReadOnly(t *testing.T) {
+	for _, method := range []string{"PUT", "MKCOL", "DELETE"} {
+		w := davRequest(t, method, "/ogg/new.txt", nil)
+		if w.Code/100 == 2 {
+			t.Errorf("%s answered %d", method, w.Code)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package codecfs
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// ListenDebug exposes the pprof endpoints on addr, under /debug/pprof/
+func ListenDebug(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return http.ListenAndServe(addr, mux)
+}
@@ -0,0 +1,45 @@
+package codecfs
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"bazil.org/fuse"
+)
+
+type dirListing struct {
+	mtime   time.Time
+	expires time.Time
+	ents    []fuse.Dirent
+}
+
+// Complete listings, by the nodeKey of their directory. They are reused for
+// DirCacheTTL, or until the mtime of their source directory changes.
+var dirCache sync.Map
+
+func cachedListing(d *dir, stat os.FileInfo) ([]fuse.Dirent, bool) {
+	key := d.profile.key(d.dir)
+	v, ok := dirCache.Load(key)
+	if !ok {
+		return nil, false
+	}
+	listing := v.(dirListing)
+	if !listing.mtime.Equal(stat.ModTime()) || time.Now().After(listing.expires) {
+		dirCache.Delete(key)
+		return nil, false
+	}
+	return listing.ents, true
+}
+
+func cacheListing(d *dir, mtime time.Time, ents []fuse.Dirent) {
+	ttl := d.profile.root.opts.DirCacheTTL
+	if ttl <= 0 {
+		return
+	}
+	dirCache.Store(d.profile.key(d.dir), dirListing{
+		mtime:   mtime,
+		expires: time.Now().Add(ttl),
+		ents:    ents,
+	})
+}
@@ -0,0 +1,442 @@
+package codecfs
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"bazil.org/fuse/fs"
+)
+
+// The DLNA server announces itself over SSDP and lets devices browse the
+// tree through the UPnP ContentDirectory service. Object ids are paths in the
+// tree, except for the root which is "0" as devices expect. Files are
+// streamed by the HTTP handler under /media.
+
+const (
+	ssdpAddr   = "239.255.255.250:1900"
+	ssdpMaxAge = 1800
+
+	dlnaDeviceType = "urn:schemas-upnp-org:device:MediaServer:1"
+	dlnaCDS        = "urn:schemas-upnp-org:service:ContentDirectory:1"
+	dlnaCM         = "urn:schemas-upnp-org:service:ConnectionManager:1"
+)
+
+type dlnaServer struct {
+	root fs.Node
+	name string
+	uuid string
+	// Port of the HTTP server
+	port  int
+	media http.Handler
+}
+
+// ListenDLNA serves the tree as a DLNA media server called name, whose HTTP
+// side listens on addr
+func (r *Root) ListenDLNA(addr, name string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	hostname, _ := os.Hostname()
+	sum := sha1.Sum([]byte(hostname + r.dir))
+	s := &dlnaServer{
+		root:  r,
+		name:  name,
+		uuid:  fmt.Sprintf("uuid:%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16]),
+		port:  l.Addr().(*net.TCPAddr).Port,
+		media: http.StripPrefix("/media", httpHandler{r}),
+	}
+	go s.ssdp()
+	slog.Info("Serving DLNA", "addr", l.Addr().String(), "name", name)
+	return http.Serve(l, s)
+}
+
+func (s *dlnaServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/media/"):
+		s.media.ServeHTTP(w, r)
+	case r.URL.Path == "/rootDesc.xml":
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		fmt.Fprintf(w, dlnaRootDesc, xmlEscape(s.name), s.uuid)
+	case r.URL.Path == "/ContentDirectory.xml":
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		io.WriteString(w, dlnaCDSDesc)
+	case r.URL.Path == "/ConnectionManager.xml":
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		io.WriteString(w, dlnaCMDesc)
+	case r.URL.Path == "/ctl/ContentDirectory" || r.URL.Path == "/ctl/ConnectionManager":
+		s.control(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// A SOAP action, with the arguments we care about
+type soapEnvelope struct {
+	Body struct {
+		Action struct {
+			XMLName        xml.Name
+			ObjectID       string
+			BrowseFlag     string
+			StartingIndex  int
+			RequestedCount int
+		} `xml:",any"`
+	}
+}
+
+// control answers the SOAP actions of both services
+func (s *dlnaServer) control(w http.ResponseWriter, r *http.Request) {
+	var env soapEnvelope
+	if err := xml.NewDecoder(r.Body).Decode(&env); err != nil {
+		http.Error(w, "Invalid SOAP request", http.StatusBadRequest)
+		return
+	}
+	action := env.Body.Action
+	var args [][2]string
+	switch action.XMLName.Local {
+	case "Browse":
+		result, returned, total, err := s.browse(r.Context(), r, action.ObjectID, action.BrowseFlag, action.StartingIndex, action.RequestedCount)
+		if err != nil {
+			soapFault(w, 701, "No such object")
+			return
+		}
+		args = [][2]string{
+			{"Result", result},
+			{"NumberReturned", strconv.Itoa(returned)},
+			{"TotalMatches", strconv.Itoa(total)},
+			{"UpdateID", "1"},
+		}
+	case "GetSystemUpdateID":
+		args = [][2]string{{"Id", "1"}}
+	case "GetSortCapabilities":
+		args = [][2]string{{"SortCaps", ""}}
+	case "GetSearchCapabilities":
+		args = [][2]string{{"SearchCaps", ""}}
+	case "GetProtocolInfo":
+		args = [][2]string{{"Source", dlnaProtocolInfo()}, {"Sink", ""}}
+	case "GetCurrentConnectionIDs":
+		args = [][2]string{{"ConnectionIDs", "0"}}
+	default:
+		soapFault(w, 401, "Invalid Action")
+		return
+	}
+
+	var body bytes.Buffer
+	fmt.Fprintf(&body, `<u:%sResponse xmlns:u="%s">`, action.XMLName.Local, action.XMLName.Space)
+	for _, arg := range args {
+		fmt.Fprintf(&body, "<%s>%s</%s>", arg[0], xmlEscape(arg[1]), arg[0])
+	}
+	fmt.Fprintf(&body, "</u:%sResponse>", action.XMLName.Local)
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	fmt.Fprintf(w, soapTemplate, body.String())
+}
+
+// browse gives the DIDL-Lite description of the object id, or of its
+// children
+func (s *dlnaServer) browse(ctx context.Context, r *http.Request, id, flag string, start, count int) (string, int, int, error) {
+	p := id
+	if id == "0" {
+		p = "/"
+	}
+	f, err := openPath(ctx, s.root, p)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	defer f.Close()
+	fi := treeFileInfo{f.name, f.node, f.attr}
+	base := "http://" + r.Host + "/media"
+
+	var didl bytes.Buffer
+	didl.WriteString(`<DIDL-Lite xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:upnp="urn:schemas-upnp-org:metadata-1-0/upnp/">`)
+	if flag == "BrowseMetadata" {
+		parent := "-1"
+		if id != "0" {
+			parent = dlnaID(path.Dir(p))
+		}
+		s.writeObject(&didl, base, p, parent, fi)
+		didl.WriteString("</DIDL-Lite>")
+		return didl.String(), 1, 1, nil
+	}
+
+	all, err := f.Readdir(-1)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	// The status directory means nothing to a media player
+	var infos []os.FileInfo
+	for _, child := range all {
+		if !strings.HasPrefix(child.Name(), ".") {
+			infos = append(infos, child)
+		}
+	}
+	total := len(infos)
+	if start > len(infos) {
+		start = len(infos)
+	}
+	infos = infos[start:]
+	if count > 0 && count < len(infos) {
+		infos = infos[:count]
+	}
+	for _, child := range infos {
+		s.writeObject(&didl, base, path.Join(p, child.Name()), id, child.(treeFileInfo))
+	}
+	didl.WriteString("</DIDL-Lite>")
+	return didl.String(), len(infos), total, nil
+}
+
+func (s *dlnaServer) writeObject(w *bytes.Buffer, base, p, parent string, fi treeFileInfo) {
+	title := fi.name
+	if p == "/" {
+		title = s.name
+	}
+	if fi.IsDir() {
+		fmt.Fprintf(w, `<container id="%s" parentID="%s" restricted="1"><dc:title>%s</dc:title><upnp:class>object.container.storageFolder</upnp:class></container>`,
+			xmlEscape(dlnaID(p)), xmlEscape(parent), xmlEscape(title))
+		return
+	}
+
+	ctype, _, _ := strings.Cut(fi.contentType(), ";")
+	class := "object.item"
+	switch {
+	case strings.HasPrefix(ctype, "audio/"):
+		class = "object.item.audioItem.musicTrack"
+		title = strings.TrimSuffix(title, path.Ext(title))
+	case strings.HasPrefix(ctype, "video/"):
+		class = "object.item.videoItem"
+	case strings.HasPrefix(ctype, "image/"):
+		class = "object.item.imageItem.photo"
+	case ctype == "":
+		ctype = "application/octet-stream"
+	}
+	u := url.URL{Path: p}
+	fmt.Fprintf(w, `<item id="%s" parentID="%s" restricted="1"><dc:title>%s</dc:title><upnp:class>%s</upnp:class><res protocolInfo="http-get:*:%s:*">%s</res></item>`,
+		xmlEscape(dlnaID(p)), xmlEscape(parent), xmlEscape(title), class, ctype, xmlEscape(base+u.EscapedPath()))
+}
+
+// dlnaID gives the object id of the path p in the tree
+func dlnaID(p string) string {
+	if p == "/" {
+		return "0"
+	}
+	return p
+}
+
+// dlnaProtocolInfo lists the formats the server can stream
+func dlnaProtocolInfo() string {
+	var out []string
+	for _, t := range formatTypes {
+		out = append(out, "http-get:*:"+t+":*")
+	}
+	return strings.Join(out, ",")
+}
+
+func soapFault(w http.ResponseWriter, code int, desc string) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusInternalServerError)
+	fmt.Fprintf(w, soapTemplate, fmt.Sprintf(`<s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode><errorDescription>%s</errorDescription></UPnPError></detail></s:Fault>`, code, desc))
+}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// ssdp answers searches for media servers and announces the server
+// periodically
+func (s *dlnaServer) ssdp() {
+	group, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		slog.Warn("Can't resolve SSDP address", "err", err)
+		return
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		slog.Warn("Can't listen for SSDP, devices won't discover the server", "err", err)
+		return
+	}
+	defer conn.Close()
+
+	go func() {
+		for {
+			s.notify(group)
+			time.Sleep(ssdpMaxAge / 2 * time.Second)
+		}
+	}()
+
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			slog.Warn("SSDP error", "err", err)
+			return
+		}
+		req := string(buf[:n])
+		if !strings.HasPrefix(req, "M-SEARCH ") {
+			continue
+		}
+		st := ssdpHeader(req, "ST")
+		for _, target := range s.targets() {
+			if st == "ssdp:all" || st == target[0] {
+				s.respond(from, target[0], target[1])
+			}
+		}
+	}
+}
+
+// targets gives the search targets of the server and the matching USNs
+func (s *dlnaServer) targets() [][2]string {
+	return [][2]string{
+		{"upnp:rootdevice", s.uuid + "::upnp:rootdevice"},
+		{s.uuid, s.uuid},
+		{dlnaDeviceType, s.uuid + "::" + dlnaDeviceType},
+		{dlnaCDS, s.uuid + "::" + dlnaCDS},
+		{dlnaCM, s.uuid + "::" + dlnaCM},
+	}
+}
+
+// location gives the URL of the description, on local, the address peers
+// reach us at
+func (s *dlnaServer) location(local net.Addr) string {
+	ip := local.(*net.UDPAddr).IP
+	return fmt.Sprintf("http://%s/rootDesc.xml", net.JoinHostPort(ip.String(), strconv.Itoa(s.port)))
+}
+
+func (s *dlnaServer) respond(to *net.UDPAddr, st, usn string) {
+	conn, err := net.DialUDP("udp4", nil, to)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=%d\r\nEXT:\r\nLOCATION: %s\r\nSERVER: codecfs/%s UPnP/1.0 DLNADOC/1.50\r\nST: %s\r\nUSN: %s\r\n\r\n",
+		ssdpMaxAge, s.location(conn.LocalAddr()), version, st, usn)
+}
+
+func (s *dlnaServer) notify(group *net.UDPAddr) {
+	conn, err := net.DialUDP("udp4", nil, group)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	for _, target := range s.targets() {
+		fmt.Fprintf(conn, "NOTIFY * HTTP/1.1\r\nHOST: %s\r\nCACHE-CONTROL: max-age=%d\r\nLOCATION: %s\r\nNT: %s\r\nNTS: ssdp:alive\r\nSERVER: codecfs/%s UPnP/1.0 DLNADOC/1.50\r\nUSN: %s\r\n\r\n",
+			ssdpAddr, ssdpMaxAge, s.location(conn.LocalAddr()), target[0], version, target[1])
+	}
+}
+
+// ssdpHeader gives the value of the header called name in an SSDP message
+func ssdpHeader(msg, name string) string {
+	for _, line := range strings.Split(msg, "\r\n") {
+		k, v, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(k), name) {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+const soapTemplate = `<?xml version="1.0" encoding="utf-8"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>%s</s:Body></s:Envelope>`
+
+const dlnaRootDesc = `<?xml version="1.0" encoding="utf-8"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0" xmlns:dlna="urn:schemas-dlna-org:device-1-0">
+<specVersion><major>1</major><minor>0</minor></specVersion>
+<device>
+<deviceType>urn:schemas-upnp-org:device:MediaServer:1</deviceType>
+<dlna:X_DLNADOC>DMS-1.50</dlna:X_DLNADOC>
+<friendlyName>%s</friendlyName>
+<manufacturer>codecfs</manufacturer>
+<modelName>codecfs</modelName>
+<UDN>%s</UDN>
+<serviceList>
+<service>
+<serviceType>urn:schemas-upnp-org:service:ContentDirectory:1</serviceType>
+<serviceId>urn:upnp-org:serviceId:ContentDirectory</serviceId>
+<SCPDURL>/ContentDirectory.xml</SCPDURL>
+<controlURL>/ctl/ContentDirectory</controlURL>
+<eventSubURL>/evt/ContentDirectory</eventSubURL>
+</service>
+<service>
+<serviceType>urn:schemas-upnp-org:service:ConnectionManager:1</serviceType>
+<serviceId>urn:upnp-org:serviceId:ConnectionManager</serviceId>
+<SCPDURL>/ConnectionManager.xml</SCPDURL>
+<controlURL>/ctl/ConnectionManager</controlURL>
+<eventSubURL>/evt/ConnectionManager</eventSubURL>
+</service>
+</serviceList>
+</device>
+</root>`
+
+const dlnaCDSDesc = `<?xml version="1.0" encoding="utf-8"?>
+<scpd xmlns="urn:schemas-upnp-org:service-1-0">
+<specVersion><major>1</major><minor>0</minor></specVersion>
+<actionList>
+<action><name>Browse</name><argumentList>
+<argument><name>ObjectID</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_ObjectID</relatedStateVariable></argument>
+<argument><name>BrowseFlag</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_BrowseFlag</relatedStateVariable></argument>
+<argument><name>Filter</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Filter</relatedStateVariable></argument>
+<argument><name>StartingIndex</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Index</relatedStateVariable></argument>
+<argument><name>RequestedCount</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+<argument><name>SortCriteria</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_SortCriteria</relatedStateVariable></argument>
+<argument><name>Result</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Result</relatedStateVariable></argument>
+<argument><name>NumberReturned</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+<argument><name>TotalMatches</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+<argument><name>UpdateID</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_UpdateID</relatedStateVariable></argument>
+</argumentList></action>
+<action><name>GetSystemUpdateID</name><argumentList>
+<argument><name>Id</name><direction>out</direction><relatedStateVariable>SystemUpdateID</relatedStateVariable></argument>
+</argumentList></action>
+<action><name>GetSortCapabilities</name><argumentList>
+<argument><name>SortCaps</name><direction>out</direction><relatedStateVariable>SortCapabilities</relatedStateVariable></argument>
+</argumentList></action>
+<action><name>GetSearchCapabilities</name><argumentList>
+<argument><name>SearchCaps</name><direction>out</direction><relatedStateVariable>SearchCapabilities</relatedStateVariable></argument>
+</argumentList></action>
+</actionList>
+<serviceStateTable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_ObjectID</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_BrowseFlag</name><dataType>string</dataType><allowedValueList><allowedValue>BrowseMetadata</allowedValue><allowedValue>BrowseDirectChildren</allowedValue></allowedValueList></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_Filter</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_Index</name><dataType>ui4</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_Count</name><dataType>ui4</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_SortCriteria</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_Result</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_UpdateID</name><dataType>ui4</dataType></stateVariable>
+<stateVariable sendEvents="yes"><name>SystemUpdateID</name><dataType>ui4</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>SortCapabilities</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>SearchCapabilities</name><dataType>string</dataType></stateVariable>
+</serviceStateTable>
+</scpd>`
+
+const dlnaCMDesc = `<?xml version="1.0" encoding="utf-8"?>
+<scpd xmlns="urn:schemas-upnp-org:service-1-0">
+<specVersion><major>1</major><minor>0</minor></specVersion>
+<actionList>
+<action><name>GetProtocolInfo</name><argumentList>
+<argument><name>Source</name><direction>out</direction><relatedStateVariable>SourceProtocolInfo</relatedStateVariable></argument>
+<argument><name>Sink</name><direction>out</direction><relatedStateVariable>SinkProtocolInfo</relatedStateVariable></argument>
+</argumentList></action>
+<action><name>GetCurrentConnectionIDs</name><argumentList>
+<argument><name>ConnectionIDs</name><direction>out</direction><relatedStateVariable>CurrentConnectionIDs</relatedStateVariable></argument>
+</argumentList></action>
+</actionList>
+<serviceStateTable>
+<stateVariable sendEvents="yes"><name>SourceProtocolInfo</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="yes"><name>SinkProtocolInfo</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="yes"><name>CurrentConnectionIDs</name><dataType>string</dataType></stateVariable>
+</serviceStateTable>
+</scpd>`
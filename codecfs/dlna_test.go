@@ -0,0 +1,165 @@
+package codecfs
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// dlnaTree serves a source with a text file and an album directory as a
+// DLNA server
+func dlnaTree(t *testing.T) *httptest.Server {
+	t.Helper()
+	src := t.TempDir()
+	if err := os.Mkdir(filepath.Join(src, "album"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "notes.txt"), []byte("liner notes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := DefaultOptions()
+	opts.SizeCache = ""
+	opts.Watch = false
+	r, err := New(src, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(&dlnaServer{
+		root:  r,
+		name:  "Tunes & more",
+		uuid:  "uuid:test",
+		media: http.StripPrefix("/media", httpHandler{r}),
+	})
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// didlResult is the part of a Browse answer the tests look at
+type didlResult struct {
+	Containers []struct {
+		ID     string `xml:"id,attr"`
+		Parent string `xml:"parentID,attr"`
+		Title  string `xml:"title"`
+	} `xml:"container"`
+	Items []struct {
+		ID    string `xml:"id,attr"`
+		Title string `xml:"title"`
+		Class string `xml:"class"`
+		Res   string `xml:"res"`
+	} `xml:"item"`
+}
+
+// dlnaBrowse calls the Browse action, and gives the DIDL-Lite result and
+// the total number of matches
+func dlnaBrowse(t *testing.T, srv *httptest.Server, id, flag string) (didlResult, string) {
+	t.Helper()
+	body := `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>` +
+		`<u:Browse xmlns:u="` + dlnaCDS + `"><ObjectID>` + xmlEscape(id) + `</ObjectID><BrowseFlag>` + flag + `</BrowseFlag>` +
+		`<StartingIndex>0</StartingIndex><RequestedCount>0</RequestedCount></u:Browse></s:Body></s:Envelope>`
+	resp, err := http.Post(srv.URL+"/ctl/ContentDirectory", `text/xml; charset="utf-8"`, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("browsing %s: %s", id, resp.Status)
+	}
+	var env struct {
+		Body struct {
+			Response struct {
+				Result       string
+				TotalMatches string
+			} `xml:"BrowseResponse"`
+		}
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&env); err != nil {
+		t.Fatal(err)
+	}
+	var result didlResult
+	if err := xml.Unmarshal([]byte(env.Body.Response.Result), &result); err != nil {
+		t.Fatal(err)
+	}
+	return result, env.Body.Response.TotalMatches
+}
+
+func TestDLNABrowse(t *testing.T) {
+	srv := dlnaTree(t)
+
+	root, _ := dlnaBrowse(t, srv, "0", "BrowseMetadata")
+	if len(root.Containers) != 1 || root.Containers[0].ID != "0" || root.Containers[0].Parent != "-1" || root.Containers[0].Title != "Tunes & more" {
+		t.Errorf("root %+v", root)
+	}
+	trees, _ := dlnaBrowse(t, srv, "0", "BrowseDirectChildren")
+	found := false
+	for _, c := range trees.Containers {
+		if strings.HasPrefix(c.Title, ".") {
+			t.Errorf("hidden %s listed", c.Title)
+		}
+		found = found || c.ID == "/ogg" && c.Parent == "0"
+	}
+	if !found {
+		t.Errorf("no ogg tree in %+v", trees)
+	}
+
+	ogg, total := dlnaBrowse(t, srv, "/ogg", "BrowseDirectChildren")
+	if total != "2" || len(ogg.Containers) != 1 || ogg.Containers[0].ID != "/ogg/album" || len(ogg.Items) != 1 {
+		t.Fatalf("ogg tree %+v, %s matches", ogg, total)
+	}
+	item := ogg.Items[0]
+	if item.ID != "/ogg/notes.txt" || item.Class != "object.item" || item.Res != srv.URL+"/media/ogg/notes.txt" {
+		t.Errorf("item %+v", item)
+	}
+	resp, err := http.Get(item.Res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if data, err := io.ReadAll(resp.Body); err != nil || string(data) != "liner notes" {
+		t.Errorf("streamed %q: %v", data, err)
+	}
+}
+
+func TestDLNAFaults(t *testing.T) {
+	srv := dlnaTree(t)
+	for _, action := range []string{
+		`<u:Browse xmlns:u="` + dlnaCDS + `"><ObjectID>/ogg/missing</ObjectID><BrowseFlag>BrowseMetadata</BrowseFlag></u:Browse>`,
+		`<u:Frobnicate xmlns:u="` + dlnaCDS + `"></u:Frobnicate>`,
+	} {
+		body := `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>` + action + `</s:Body></s:Envelope>`
+		resp, err := http.Post(srv.URL+"/ctl/ContentDirectory", "text/xml", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusInternalServerError || !strings.Contains(string(data), "UPnPError") {
+			t.Errorf("answered %s to %s: %s", resp.Status, action, data)
+		}
+	}
+}
+
+func TestDLNADescription(t *testing.T) {
+	srv := dlnaTree(t)
+	resp, err := http.Get(srv.URL + "/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var desc struct {
+		Device struct {
+			FriendlyName string `xml:"friendlyName"`
+			UDN          string
+		} `xml:"device"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&desc); err != nil {
+		t.Fatal(err)
+	}
+	if desc.Device.FriendlyName != "Tunes & more" || desc.Device.UDN != "uuid:test" {
+		t.Errorf("device %+v", desc.Device)
+	}
+}
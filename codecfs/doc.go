@@ -0,0 +1,15 @@
+// Package codecfs is the transcoding filesystem of the codecfs command, for
+// programs that embed it. New builds the tree of a source, which can be
+// mounted with Serve on a bazil.org/fuse connection, or served over the
+// network with the Listen methods:
+//
+//	opts := codecfs.DefaultOptions()
+//	opts.Profile = "opus-96"
+//	root, err := codecfs.New("/srv/music", opts)
+//	if err != nil {
+//		return err
+//	}
+//	return root.ListenHTTP(":8000")
+//
+// Encoders, caches and limits are shared by every tree of a process.
+package codecfs
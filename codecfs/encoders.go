@@ -0,0 +1,66 @@
+package codecfs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Encoders of the installed ffmpeg, by name, filled on first use
+var (
+	encodersOnce sync.Once
+	encoders     map[string]bool
+)
+
+// hasEncoder tells whether the installed ffmpeg has the encoder called name
+func hasEncoder(name string) bool {
+	encodersOnce.Do(func() {
+		encoders = map[string]bool{}
+		out, err := exec.Command("ffmpeg", "-hide_banner", "-encoders").Output()
+		if err != nil {
+			slog.Warn("Can't list ffmpeg encoders", "err", err)
+			return
+		}
+		// Lines look like " A....D libopus   libopus Opus", after a
+		// legend ending with a line of dashes
+		s := bufio.NewScanner(bytes.NewReader(out))
+		for s.Scan() {
+			fields := strings.Fields(s.Text())
+			if len(fields) >= 2 && len(fields[0]) == 6 && fields[0] != "------" {
+				encoders[fields[1]] = true
+			}
+		}
+	})
+	return encoders[name]
+}
+
+// AAC encoders a profile can ask for. libfdk_aac sounds better, but ffmpeg
+// builds seldom have it since it can't be redistributed.
+var aacEncoders = []string{"aac", "libfdk_aac"}
+
+func validAACEncoder(name string) error {
+	switch name {
+	case "", "aac":
+		return nil
+	case "libfdk_aac":
+		if !hasEncoder(name) {
+			slog.Warn("ffmpeg has no libfdk_aac, using its own AAC encoder instead")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown AAC encoder %q, must be one of %s", name, strings.Join(aacEncoders, ", "))
+}
+
+// aacEncoder gives the AAC encoder to use for the one the profile asks for:
+// libfdk_aac when it asks for nothing in particular, as long as ffmpeg has
+// it
+func (p *profile) aacEncoder() string {
+	if p.aac != "aac" && hasEncoder("libfdk_aac") {
+		return "libfdk_aac"
+	}
+	return "aac"
+}
@@ -0,0 +1,149 @@
+package codecfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Kinds of events
+const (
+	eventTranscodeStarted  = "transcode-started"
+	eventTranscodeFinished = "transcode-finished"
+	eventTranscodeFailed   = "transcode-failed"
+	eventCacheEvicted      = "cache-evicted"
+)
+
+// An Event is what the webhook is posted and the event command is given on
+// its stdin, as JSON
+type Event struct {
+	Type    string    `json:"type"`
+	Time    time.Time `json:"time"`
+	Source  string    `json:"source,omitempty"`
+	Profile string    `json:"profile,omitempty"`
+	// Path of the file in the tree of the profile, when known
+	Name string `json:"name,omitempty"`
+	// Bytes produced by a transcode
+	Size  int64  `json:"size,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+var (
+	// URL events are posted to, or empty
+	webhookURL string
+	// Command run for each event, or empty
+	eventCommand string
+)
+
+// Events waiting to be delivered. They are delivered one at a time, in
+// order, and dropped when too many are waiting so that a slow hook never
+// holds back the filesystem.
+var (
+	pendingEvents   = make(chan Event, 256)
+	eventWorkerOnce sync.Once
+)
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// How long the event command is given for each event before it is killed,
+// so that one hanging doesn't hold back the next events
+const eventCommandTimeout = 10 * time.Second
+
+// validWebhook checks that events can be posted to u
+func validWebhook(u string) error {
+	if u == "" {
+		return nil
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" || parsed.Host == "" {
+		return errors.New("the webhook must be an http or https URL")
+	}
+	return nil
+}
+
+// emit sends e to the webhook and the event command, if any
+func emit(e Event) {
+	if webhookURL == "" && eventCommand == "" {
+		return
+	}
+	e.Time = time.Now()
+	eventWorkerOnce.Do(func() { go deliverEvents() })
+	select {
+	case pendingEvents <- e:
+	default:
+		slog.Warn("Too many pending events, dropping one", "type", e.Type, "source", e.Source)
+	}
+}
+
+func deliverEvents() {
+	for e := range pendingEvents {
+		body, err := json.Marshal(e)
+		if err != nil {
+			continue
+		}
+		if webhookURL != "" {
+			if err := postEvent(webhookURL, body); err != nil {
+				slog.Warn("Can't post event", "type", e.Type, "source", e.Source, "err", err)
+			}
+		}
+		if eventCommand != "" {
+			if err := runEventCommand(eventCommand, e, body); err != nil {
+				slog.Warn("Event command failed", "type", e.Type, "source", e.Source, "err", err)
+			}
+		}
+	}
+}
+
+func postEvent(u string, body []byte) error {
+	resp, err := webhookClient.Post(u, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// runEventCommand runs command with the event on its stdin, and its type,
+// source and profile in CODECFS_EVENT, CODECFS_SOURCE and CODECFS_PROFILE
+func runEventCommand(command string, e Event, body []byte) error {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), eventCommandTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	// Don't wait for what it left running with our pipes
+	cmd.WaitDelay = time.Second
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"CODECFS_EVENT="+e.Type,
+		"CODECFS_SOURCE="+e.Source,
+		"CODECFS_PROFILE="+e.Profile,
+	)
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return fmt.Errorf("killed after running for %v", eventCommandTimeout)
+	}
+	if err != nil && len(out) > 0 {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(out))
+	}
+	return err
+}
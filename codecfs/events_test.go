@@ -0,0 +1,91 @@
+package codecfs
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidWebhook(t *testing.T) {
+	for u, valid := range map[string]bool{
+		"":                        true,
+		"http://localhost:8080/x": true,
+		"https://example.com":     true,
+		"ftp://example.com":       false,
+		"http://":                 false,
+		"example.com/hook":        false,
+	} {
+		if err := validWebhook(u); (err == nil) != valid {
+			t.Errorf("validWebhook(%q) = %v", u, err)
+		}
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	events := make(chan Event, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Error(err)
+		}
+		events <- e
+	}))
+	defer srv.Close()
+	webhookURL = srv.URL
+	defer func() { webhookURL = "" }()
+
+	emit(Event{Type: eventTranscodeStarted, Source: "a.flac", Profile: "ogg"})
+	select {
+	case e := <-events:
+		if e.Type != eventTranscodeStarted || e.Source != "a.flac" || e.Profile != "ogg" || e.Time.IsZero() {
+			t.Errorf("posted %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event posted")
+	}
+}
+
+func TestWebhookError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	if err := postEvent(srv.URL, []byte("{}")); err == nil {
+		t.Error("error status accepted")
+	}
+}
+
+func TestEventCommand(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+	dir := t.TempDir()
+	script := filepath.Join(dir, "hook")
+	out := filepath.Join(dir, "out")
+	body := "#!/bin/sh\necho \"$CODECFS_EVENT $CODECFS_SOURCE $CODECFS_PROFILE\" > " + out + "\ncat >> " + out + "\n"
+	if err := os.WriteFile(script, []byte(body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	e := Event{Type: eventTranscodeFinished, Source: "a.flac", Profile: "mp3"}
+	if err := runEventCommand(script, e, []byte(`{"type":"transcode-finished"}`)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || lines[0] != "transcode-finished a.flac mp3" || lines[1] != `{"type":"transcode-finished"}` {
+		t.Errorf("command got %q", data)
+	}
+
+	if err := runEventCommand("false", e, nil); err == nil {
+		t.Error("failing command succeeded")
+	}
+}
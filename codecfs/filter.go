@@ -0,0 +1,58 @@
+package codecfs
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// globList is a flag.Value collecting glob patterns from repeated flags
+type globList []string
+
+func (g *globList) String() string {
+	return strings.Join(*g, ",")
+}
+
+func (g *globList) Set(pattern string) error {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return err
+	}
+	*g = append(*g, pattern)
+	return nil
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hidden tells whether a source entry must be left out of the virtual tree.
+// Exclusions apply to everything, inclusions only restrict files so that
+// directories can still be walked into.
+func (p *profile) hidden(name string, isDir bool) bool {
+	if p.passthrough {
+		return false
+	}
+	if name == dirConfigName || finderFile(name) {
+		return true
+	}
+	if matchAny(p.exclude, name) {
+		return true
+	}
+	if !isDir && len(p.include) > 0 && !matchAny(p.include, name) {
+		return true
+	}
+	return false
+}
+
+// finderFile tells whether name is one of the files macOS leaves around to
+// store Finder metadata, on macOS where the mount is served with
+// noappledouble: they are never worth sniffing nor transcoding there.
+// Elsewhere, they are files like any other.
+func finderFile(name string) bool {
+	return runtime.GOOS == "darwin" && (name == ".DS_Store" || strings.HasPrefix(name, "._"))
+}
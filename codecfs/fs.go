@@ -0,0 +1,988 @@
+package codecfs
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+)
+
+// Exact sizes of transcoded files, as knownSizes by sizeKey
+var allSizes sync.Map
+
+var _ fs.HandleReadDirAller = &Root{}
+var _ fs.NodeStringLookuper = &Root{}
+var _ fs.FSStatfser = &Root{}
+
+// Root is the root of a mount, listing a directory per tree
+type Root struct {
+	readOnly
+	// Source of the trees, and the path of their top in it
+	src sourceFS
+	dir string
+	// Options the trees were built with
+	opts Options
+	// Profile of the first tree, which the others derive from
+	ogg *profile
+
+	// Stats of the source entries listed lately, as statEntries by path,
+	// and when the expired ones were last swept
+	stats     sync.Map
+	sweepMu   sync.Mutex
+	lastSweep time.Time
+	// Source paths of converted names, by virtual path, and the virtual
+	// paths of each source path
+	names   sync.Map
+	namesMu sync.Mutex
+	renamed map[string]map[string]bool
+
+	// Profiles of the trees and the converter rules they include, rebuilt
+	// on reloads
+	mu       sync.Mutex
+	profiles []*profile
+	rules    []*converterRule
+
+	// nil unless opts.Ingest is set
+	ingest *ingestDir
+}
+
+// newRoot builds the root of the tree exposing dir in src through the ogg
+// profile, along with the other trees of opts
+func newRoot(src sourceFS, dir string, ogg *profile, opts Options, rules []*converterRule) *Root {
+	r := &Root{
+		src:   src,
+		dir:   dir,
+		opts:  opts,
+		rules: rules,
+	}
+	ogg.root = r
+	r.ogg = ogg
+	r.profiles = r.buildProfiles()
+	if opts.Ingest != "" {
+		r.ingest = newIngestDir(r)
+	}
+	return r
+}
+
+// rename records that the source path is exposed as virtual
+func (r *Root) rename(virtual, source string) {
+	r.names.Store(virtual, source)
+	r.namesMu.Lock()
+	defer r.namesMu.Unlock()
+	if r.renamed == nil {
+		r.renamed = map[string]map[string]bool{}
+	}
+	if r.renamed[source] == nil {
+		r.renamed[source] = map[string]bool{}
+	}
+	r.renamed[source][virtual] = true
+}
+
+// renamedAs gives the virtual paths source was exposed as
+func (r *Root) renamedAs(source string) []string {
+	r.namesMu.Lock()
+	defer r.namesMu.Unlock()
+	var names []string
+	for virtual := range r.renamed[source] {
+		names = append(names, virtual)
+	}
+	return names
+}
+
+// buildProfiles gives the profiles of the trees of the root. r.mu must be
+// held, or r not shared yet.
+func (r *Root) buildProfiles() []*profile {
+	profiles := []*profile{r.ogg}
+	if r.opts.FLAC {
+		profiles = append(profiles, flacProfile(r.ogg, r.opts.FLACLevel))
+	}
+	if r.opts.WAV {
+		profiles = append(profiles, wavProfile(r.ogg, r.opts.WAVBits))
+	}
+	if r.opts.JPEG {
+		profiles = append(profiles, jpegProfile(r.ogg, r.opts.JPEGQuality))
+	}
+	if r.opts.Thumbs {
+		profiles = append(profiles, thumbsProfile(r.ogg, r.opts.ThumbSize, r.opts.JPEGQuality))
+	}
+	if r.opts.Video {
+		profiles = append(profiles, videoProfile(r.ogg, r.opts.VideoBitrate, r.opts.VideoTwoPass))
+	}
+	for _, rule := range r.rules {
+		profiles = append(profiles, ruleProfile(r.ogg, rule))
+	}
+	if r.opts.Original {
+		profiles = append(profiles, &profile{
+			name:        "original",
+			root:        r,
+			passthrough: true,
+		})
+	}
+	return profiles
+}
+
+// trees gives the profiles of the trees of the root
+func (r *Root) trees() []*profile {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.profiles
+}
+
+// Serve answers the requests of the kernel on c, a connection to the FUSE
+// mount at mountpoint, until it is unmounted
+func (r *Root) Serve(c *fuse.Conn, mountpoint string) error {
+	var config *fs.Config
+	if traceFuse {
+		config = &fs.Config{Debug: traceDebug}
+	}
+	srv := fs.New(c, config)
+	addServer(srv, mountpoint)
+	return srv.Serve(r)
+}
+
+func (r *Root) Root() (fs.Node, error) {
+	return r, nil
+}
+
+func (r *Root) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Inode = 1
+	a.Mode = os.ModeDir | 0555
+	stat, err := r.src.Stat(r.dir)
+	if err != nil {
+		return err
+	}
+	r.setAttr(a, stat)
+	return nil
+}
+
+func (r *Root) Statfs(ctx context.Context, req *fuse.StatfsRequest, resp *fuse.StatfsResponse) error {
+	if !localSource(r.src) {
+		return nil
+	}
+	return statfs(r.dir, resp)
+}
+
+func (r *Root) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	profiles := r.trees()
+	out := make([]fuse.Dirent, 0, len(profiles)+2)
+	for _, p := range profiles {
+		out = append(out, fuse.Dirent{
+			Inode: inode(p.name, r.dir),
+			Type:  fuse.DT_Dir,
+			Name:  p.name,
+		})
+	}
+	out = append(out, fuse.Dirent{
+		Inode: inode("", statusDirName),
+		Type:  fuse.DT_Dir,
+		Name:  statusDirName,
+	})
+	if r.ingest != nil {
+		out = append(out, fuse.Dirent{
+			Inode: inode("", ingestDirName),
+			Type:  fuse.DT_Dir,
+			Name:  ingestDirName,
+		})
+	}
+	if r.opts.VolumeIcon != "" {
+		out = append(out, fuse.Dirent{
+			Inode: inode("", volumeIconName),
+			Type:  fuse.DT_File,
+			Name:  volumeIconName,
+		})
+	}
+	return out, nil
+}
+
+// profileDir gives the top directory of the tree of p
+func (r *Root) profileDir(p *profile) fs.Node {
+	return node(p.key(r.dir), func() fs.Node {
+		return &dir{
+			dir:     r.dir,
+			profile: p.forDir(r.dir),
+		}
+	})
+}
+
+func (r *Root) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	if name == statusDirName {
+		return &statusDir{root: r}, nil
+	}
+	if name == ingestDirName && r.ingest != nil {
+		return r.ingest, nil
+	}
+	if name == volumeIconName && r.opts.VolumeIcon != "" {
+		return &volumeIcon{path: r.opts.VolumeIcon}, nil
+	}
+	for _, p := range r.trees() {
+		if name == p.name {
+			return r.profileDir(p), nil
+		}
+	}
+	// Other qualities are only there for those who ask
+	if p, ok := namedProfile(r.ogg, name); ok {
+		return r.profileDir(p), nil
+	}
+
+	return nil, fuse.ENOENT
+}
+
+var _ fs.NodeOpener = &dir{}
+var _ fs.NodeStringLookuper = &dir{}
+
+type dir struct {
+	readOnly
+	dir     string
+	profile *profile
+}
+
+func (d *dir) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Inode = inode(d.profile.name, d.dir)
+	a.Mode = os.ModeDir | 0555
+	stat, err := d.profile.root.statSource(d.dir)
+	if err != nil {
+		return err
+	}
+	d.profile.root.setAttr(a, stat)
+	return nil
+}
+
+func (d *dir) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	r := d.profile.root
+	stat, err := r.src.Stat(d.dir)
+	if err != nil {
+		return nil, err
+	}
+	watch(d.dir)
+	if ents, ok := cachedListing(d, stat); ok {
+		dh := &dirHandle{
+			dir:  d,
+			ents: ents,
+		}
+		for _, ent := range ents {
+			dh.data = fuse.AppendDirent(dh.data, ent)
+		}
+		return dh, nil
+	}
+
+	// Names are cheap to get, unlike stats and sniffing which are only
+	// done as the listing is consumed
+	names, err := r.src.ReadDirNames(d.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Source names always take precedence over converted names, and
+	// converted names are attributed in name order, so that collisions are
+	// resolved the same way on every listing.
+	r.sortNames(names)
+	taken := make(map[string]bool, len(names))
+	for _, name := range names {
+		taken[name] = true
+	}
+	return &dirHandle{
+		dir:   d,
+		mtime: stat.ModTime(),
+		names: names,
+		taken: taken,
+	}, nil
+}
+
+// entry builds the virtual entry for the source entry called name, if it is
+// to be shown. taken holds the names already in use in the directory.
+func (d *dir) entry(name string, taken map[string]bool) (fuse.Dirent, bool) {
+	r := d.profile.root
+	source := filepath.Join(d.dir, name)
+	ent, err := r.src.Lstat(source)
+	if err != nil {
+		return fuse.Dirent{}, false
+	}
+	if !ent.Mode().IsDir() && !ent.Mode().IsRegular() {
+		return fuse.Dirent{}, false
+	}
+	r.cacheStat(source, ent)
+	var typ fuse.DirentType
+	switch {
+	case ent.Mode().IsDir():
+		typ = fuse.DT_Dir
+	case ent.Mode().IsRegular():
+		typ = fuse.DT_File
+	}
+
+	if d.profile.hidden(name, typ == fuse.DT_Dir) {
+		return fuse.Dirent{}, false
+	}
+	virtual := name
+	converted := false
+	if typ == fuse.DT_File && !d.profile.passthrough {
+		if d.profile.audio() && r.sourceChapters(source, ent.ModTime()) != nil && !taken[bookDirName(name)] {
+			typ = fuse.DT_Dir
+			virtual = bookDirName(name)
+		} else if d.profile.transcodes(source) {
+			virtual = d.profile.convertedName(name)
+			converted = true
+		} else if d.profile.mediaOnly && (d.profile.images || !r.isAudio(source)) {
+			return fuse.Dirent{}, false
+		}
+	}
+	if r.opts.SanitizeNames && !d.profile.passthrough {
+		virtual = r.sanitizeName(virtual)
+	}
+	if !d.profile.passthrough {
+		virtual = r.normalizeName(virtual)
+	}
+	if virtual != name && taken[virtual] {
+		conflict := virtual
+		virtual = d.alternateName(name, conflict, converted, taken)
+		if virtual == "" {
+			slog.Warn("Hiding file with conflicting names", "source", source, "profile", d.profile.name, "conflict", conflict)
+			return fuse.Dirent{}, false
+		}
+		slog.Info("Renaming file with conflicting name", "source", source, "profile", d.profile.name, "name", virtual, "conflict", conflict)
+	}
+	if virtual != name {
+		taken[virtual] = true
+		r.rename(filepath.Join(d.dir, virtual), source)
+	}
+	// Directories are known by their source path whatever their name
+	inodePath := filepath.Join(d.dir, virtual)
+	if typ == fuse.DT_Dir {
+		inodePath = source
+	}
+	return fuse.Dirent{
+		Inode: inode(d.profile.name, inodePath),
+		Type:  typ,
+		Name:  virtual,
+	}, true
+}
+
+// alternateName gives another name for the source entry name when the name
+// it should have, conflict, is taken: the whole source name followed by the
+// extension for converted files, e.g. track.flac.ogg next to an existing
+// track.ogg, or conflict numbered for sanitized names. It is empty when
+// there's none.
+func (d *dir) alternateName(name, conflict string, converted bool, taken map[string]bool) string {
+	r := d.profile.root
+	if converted {
+		alt := name + d.profile.ext
+		if r.opts.SanitizeNames {
+			alt = r.sanitizeName(alt)
+		}
+		if !taken[alt] {
+			return alt
+		}
+	}
+	if r.opts.SanitizeNames {
+		ext := filepath.Ext(conflict)
+		stem := strings.TrimSuffix(conflict, ext)
+		for i := 2; i < 100; i++ {
+			suffix := fmt.Sprintf("~%d%s", i, ext)
+			if len(stem)+len(suffix) > r.opts.MaxNameLength {
+				stem = stem[:max(0, r.opts.MaxNameLength-len(suffix))]
+			}
+			alt := stem + suffix
+			if !taken[alt] {
+				return alt
+			}
+		}
+	}
+	return ""
+}
+
+var _ fs.HandleReader = &dirHandle{}
+
+// dirHandle streams the listing of a directory: entries are only built when
+// the kernel asks for them, so that the first ones come back without having
+// to sniff the whole directory.
+type dirHandle struct {
+	readOnly
+	dir *dir
+	// mtime of the source directory when the listing started
+	mtime time.Time
+
+	mu sync.Mutex
+	// Source names not looked at yet
+	names []string
+	taken map[string]bool
+	// Dirents built so far, as is and serialized. The kernel gives
+	// offsets in data, and goes back to 0 on rewinddir.
+	ents []fuse.Dirent
+	data []byte
+}
+
+func (dh *dirHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	dh.mu.Lock()
+	defer dh.mu.Unlock()
+
+	end := req.Offset + int64(req.Size)
+	for int64(len(dh.data)) < end && len(dh.names) > 0 {
+		name := dh.names[0]
+		dh.names = dh.names[1:]
+		if ent, ok := dh.dir.entry(name, dh.taken); ok {
+			dh.ents = append(dh.ents, ent)
+			dh.data = fuse.AppendDirent(dh.data, ent)
+		}
+		if len(dh.names) == 0 {
+			dh.finish()
+		}
+	}
+
+	// A dirent cut at the end is dropped by the kernel, which asks for it
+	// again in the next read
+	if req.Offset >= int64(len(dh.data)) {
+		resp.Data = nil
+		return nil
+	}
+	if end > int64(len(dh.data)) {
+		end = int64(len(dh.data))
+	}
+	resp.Data = append(resp.Data[:0], dh.data[req.Offset:end]...)
+	return nil
+}
+
+// all builds the remaining entries and returns the whole listing
+func (dh *dirHandle) all() []fuse.Dirent {
+	dh.mu.Lock()
+	defer dh.mu.Unlock()
+	for _, name := range dh.names {
+		if ent, ok := dh.dir.entry(name, dh.taken); ok {
+			dh.ents = append(dh.ents, ent)
+			dh.data = fuse.AppendDirent(dh.data, ent)
+		}
+	}
+	if len(dh.names) > 0 {
+		dh.names = nil
+		dh.finish()
+	}
+	return dh.ents
+}
+
+// finish completes the listing once every source entry is in, with the
+// playlist of the directory if it has tracks, and caches it
+func (dh *dirHandle) finish() {
+	if ent, ok := dh.dir.playlistEntry(dh.ents, dh.taken); ok {
+		dh.ents = append(dh.ents, ent)
+		dh.data = fuse.AppendDirent(dh.data, ent)
+	}
+	cacheListing(dh.dir, dh.mtime, dh.ents)
+}
+
+// isAudio tells whether the source at path in r is audio or video
+func (r *Root) isAudio(path string) bool {
+	file, err := r.src.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	var buf [512]byte
+	_, err = io.ReadFull(file, buf[:])
+	if err != nil && err != io.EOF {
+		return false
+	}
+
+	// From spec (https://mimesniff.spec.whatwg.org/):
+	//
+	// ```
+	// An audio or video type
+	// is any parsable MIME type where type is equal to "audio" or "video"
+	// or where the MIME type portion is equal to one of the following:
+	//
+	//     application/ogg
+	// ```
+	//
+	// As an addendum, files ending with a .flac will be considered valid
+	// audio
+	contentType := http.DetectContentType(buf[:])
+	if strings.HasPrefix(contentType, "audio/") ||
+		strings.HasPrefix(contentType, "video/") ||
+		contentType == "application/ogg" ||
+		strings.HasSuffix(path, ".flac") {
+		return true
+	}
+	return false
+}
+
+func (d *dir) Lookup(ctx context.Context, name string) (_ fs.Node, err error) {
+	defer trace("Lookup", time.Now(), &err, "path", filepath.Join(d.dir, name), "profile", d.profile.name)
+	if !validName(name) {
+		// The kernel never asks for them, other transports could to
+		// escape the source
+		return nil, fuse.ENOENT
+	}
+	if !d.profile.passthrough && finderFile(name) {
+		// Finder probes for them everywhere, don't bother the source
+		return nil, fuse.ENOENT
+	}
+	r := d.profile.root
+	if !d.profile.passthrough {
+		// Listings give names in that form
+		name = r.normalizeName(name)
+	}
+	virtualName := filepath.Join(d.dir, name)
+	baseNameString := virtualName
+	if _, err := r.statSource(baseNameString); os.IsNotExist(err) && !d.profile.passthrough {
+		// Converted names are learnt when listing. Lookups can come
+		// without a listing first, e.g. from NFS clients reusing file
+		// handles or from paths typed by hand.
+		baseName, ok := r.names.Load(baseNameString)
+		if !ok {
+			if n, ok := d.chained(virtualName); ok {
+				return n, nil
+			}
+			baseName, ok = d.resolve(ctx, virtualName)
+		}
+		if ok {
+			baseNameString = baseName.(string)
+		}
+	}
+	stat, err := r.statSource(baseNameString)
+	if err != nil {
+		if os.IsNotExist(err) {
+			if name == playlistName && r.opts.Playlists && !d.profile.passthrough {
+				if n, ok := d.playlist(ctx); ok {
+					return n, nil
+				}
+			}
+			if match, ok := d.caseMatch(ctx, name); ok {
+				return d.Lookup(ctx, match)
+			}
+			return nil, fuse.ENOENT
+		}
+		return nil, err
+	}
+	if d.profile.hidden(filepath.Base(baseNameString), stat.Mode().IsDir()) {
+		return nil, fuse.ENOENT
+	}
+	switch {
+	case stat.Mode().IsDir():
+		return node(d.profile.key(baseNameString), func() fs.Node {
+			return &dir{
+				dir:     baseNameString,
+				profile: d.profile.forDir(baseNameString),
+			}
+		}), nil
+	case stat.Mode().IsRegular():
+		if virtualName != baseNameString && filepath.Ext(virtualName) != d.profile.ext && d.profile.audio() && r.sourceChapters(baseNameString, stat.ModTime()) != nil {
+			return node(d.profile.key(baseNameString), func() fs.Node {
+				return &chapterDir{
+					source:  baseNameString,
+					profile: d.profile,
+				}
+			}), nil
+		}
+		// Converted files are media, and so are all files image trees
+		// show
+		if d.profile.mediaOnly && virtualName == baseNameString && (d.profile.images || !r.isAudio(baseNameString)) {
+			return nil, fuse.ENOENT
+		}
+		return node(d.profile.key(virtualName), func() fs.Node {
+			return &file{
+				name:    virtualName,
+				source:  baseNameString,
+				profile: d.profile,
+			}
+		}), nil
+	}
+	return nil, fuse.ENOENT
+}
+
+// chained gives the node for virtualName when it is the name of a source
+// file followed by the extension of a codec, e.g. track.flac.opus, which
+// transcodes the file to that codec whatever the profile of the tree
+func (d *dir) chained(virtualName string) (fs.Node, bool) {
+	ext := filepath.Ext(virtualName)
+	c, ok := namedCodecs[strings.TrimPrefix(ext, ".")]
+	source := strings.TrimSuffix(virtualName, ext)
+	if !ok || filepath.Ext(source) == "" {
+		return nil, false
+	}
+	r := d.profile.root
+	stat, err := r.statSource(source)
+	if err != nil || !stat.Mode().IsRegular() || d.profile.hidden(filepath.Base(source), false) || !r.isAudio(source) {
+		return nil, false
+	}
+
+	p := d.profile
+	if p.ext != c.ext {
+		// With the encoder defaults, the settings of the tree being
+		// meant for its own codec
+		chained := *p
+		chained.name = p.name + "+" + strings.TrimPrefix(ext, ".")
+		chained.format = c.format
+		chained.ext = c.ext
+		chained.quality = ""
+		chained.bitrate = ""
+		p = &chained
+	}
+	return node(p.key(virtualName), func() fs.Node {
+		return &file{
+			name:    virtualName,
+			source:  source,
+			profile: p,
+		}
+	}), true
+}
+
+// caseMatch gives the name of the entry of the directory that only differs
+// from name by case, under IgnoreCase
+func (d *dir) caseMatch(ctx context.Context, name string) (string, bool) {
+	if !d.profile.root.opts.IgnoreCase {
+		return "", false
+	}
+	h, err := d.Open(ctx, &fuse.OpenRequest{Dir: true}, &fuse.OpenResponse{})
+	if err != nil {
+		return "", false
+	}
+	for _, ent := range h.(*dirHandle).all() {
+		if ent.Name != name && strings.EqualFold(ent.Name, name) {
+			return ent.Name, true
+		}
+	}
+	return "", false
+}
+
+// resolve lists the directory to find the source of the converted name
+// virtualName
+func (d *dir) resolve(ctx context.Context, virtualName string) (interface{}, bool) {
+	h, err := d.Open(ctx, &fuse.OpenRequest{Dir: true}, &fuse.OpenResponse{})
+	if err != nil {
+		return nil, false
+	}
+	h.(*dirHandle).all()
+	return d.profile.root.names.Load(virtualName)
+}
+
+var _ fs.NodeOpener = &file{}
+
+type file struct {
+	readOnly
+	// name is the path the file would have in the source tree, source is
+	// the path it is actually read from. They differ when the file is
+	// transcoded.
+	name    string
+	source  string
+	profile *profile
+}
+
+func (f *file) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Inode = inode(f.profile.name, f.name)
+	a.Mode = 0555
+
+	stat, err := f.profile.root.statSource(f.source)
+	if err != nil {
+		return err
+	}
+	f.profile.root.setAttr(a, stat)
+	a.Size, _ = f.size(stat)
+	// Copy tools take files using less blocks than their size for sparse
+	// ones, and pad their copies to the size with zeros
+	a.Blocks = (a.Size + 511) / 512
+	return nil
+}
+
+// size returns the size of the file as seen through the mount, and whether
+// it is exact or only an estimation. stat is the stat of the source.
+func (f *file) size(stat os.FileInfo) (uint64, bool) {
+	// Get from original file, if it exists as-is
+	if f.name == f.source {
+		return uint64(stat.Size()), true
+	}
+
+	// Get from cache
+	key := sizeKey{f.profile.name, f.name}
+	version := versionOf(stat)
+	if v, ok := allSizes.Load(key); ok {
+		if known := v.(knownSize); known.version.equal(version) {
+			sizeCacheRequests.WithLabelValues("hit").Inc()
+			return known.size, true
+		}
+		// The source was replaced since
+		if allSizes.CompareAndDelete(key, v) {
+			emit(Event{Type: eventCacheEvicted, Source: f.source, Profile: f.profile.name, Name: f.name})
+		}
+	}
+	if size, ok := persistedSize(f.source, version, f.profile); ok {
+		allSizes.Store(key, knownSize{version, size})
+		sizeCacheRequests.WithLabelValues("hit").Inc()
+		return size, true
+	}
+	sizeCacheRequests.WithLabelValues("miss").Inc()
+	return f.guessSize(stat)
+}
+
+func (f *file) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (_ fs.Handle, err error) {
+	defer trace("Open", time.Now(), &err, "path", f.name, "profile", f.profile.name)
+	if !req.Flags.IsReadOnly() {
+		return nil, errReadOnly
+	}
+	r := f.profile.root
+	if f.name == f.source {
+		file, err := r.src.Open(f.source)
+		if err != nil {
+			return nil, err
+		}
+		// The page cache can serve the file again without going through
+		// us, until the source changes. Applications mapping the file
+		// read it through the page cache too, and would see pages of
+		// both versions otherwise.
+		if r.sameNativeVersion(f.source) {
+			resp.Flags |= fuse.OpenKeepCache
+		}
+		return nativeFile{randomFile: randomAccess(r.src, f.source, file), path: f.source}, nil
+	}
+
+	if brokenSource(f.source) {
+		return nil, fuse.EIO
+	}
+	if r.opts.DirectIO {
+		resp.Flags |= fuse.OpenDirectIO
+	}
+	t := takePrefetched(f.source, f.profile)
+	if t == nil {
+		t, err = startTranscode(f.source, f.profile, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fh := &fileHandle{
+		name:    f.name,
+		profile: f.profile,
+		opened:  time.Now(),
+		limit:   newThrottle(f.profile),
+		out:     &segment{transcode: t},
+	}
+	openHandles.Store(fh, struct{}{})
+	return fh, nil
+}
+
+var _ fs.HandleReader = &fileHandle{}
+var _ fs.HandleReleaser = &fileHandle{}
+
+type fileHandle struct {
+	readOnly
+	name    string
+	profile *profile
+	opened  time.Time
+	stats   readStats
+	limit   *throttle
+
+	mu sync.Mutex
+	// Output of the encoder started at open
+	out *segment
+	// Output of the encoder started for the last far seek, if any
+	seek *segment
+	// Whether the next file was prefetched already
+	prefetched bool
+	// Whether the exact size was stored already
+	sized bool
+}
+
+func (fh *fileHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) (err error) {
+	defer trace("Release", time.Now(), &err, "path", fh.name, "profile", fh.profile.name)
+	openHandles.Delete(fh)
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	fh.out.close()
+	if fh.seek != nil {
+		fh.seek.close()
+	}
+	return nil
+}
+
+// segmentFor gives the segment a read at offset should be served from,
+// starting an encoder at the matching timestamp if the offset is too far
+// from what is available, or encoding again what was already dropped
+func (fh *fileHandle) segmentFor(offset int64) (*segment, error) {
+	s, err := fh.seekSegment(offset)
+	if err != nil {
+		return nil, err
+	}
+	if offset < s.offset {
+		if err := s.restart(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// seekSegment gives the segment whose encoder is the best placed to produce
+// the bytes at offset
+func (fh *fileHandle) seekSegment(offset int64) (*segment, error) {
+	rate := fh.profile.byteRate()
+	distance := int64(seekDistance.Seconds() * float64(rate))
+	if !fh.profile.seekable() || rate == 0 || seekDistance == 0 || offset <= fh.out.end()+distance {
+		return fh.out, nil
+	}
+	if fh.seek != nil && offset >= fh.seek.base && offset <= fh.seek.end()+distance {
+		return fh.seek, nil
+	}
+
+	if fh.seek != nil {
+		fh.seek.close()
+	}
+	start := time.Duration(float64(offset) / float64(rate) * float64(time.Second))
+	t, err := startTranscode(fh.out.transcode.source, fh.profile, start)
+	if err != nil {
+		return nil, err
+	}
+	fh.seek = &segment{base: offset, offset: offset, start: start, transcode: t}
+	return fh.seek, nil
+}
+
+func (fh *fileHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) (err error) {
+	// Held back reads don't count as slow ones in the stats
+	if err := fh.limit.wait(ctx, req.Size); err != nil {
+		return err
+	}
+	started := time.Now()
+	defer trace("Read", started, &err, "path", fh.name, "profile", fh.profile.name, "offset", req.Offset, "size", req.Size)
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	s, err := fh.segmentFor(req.Offset)
+	if err != nil {
+		return err
+	}
+	hit := req.Offset >= s.offset && (req.Offset+int64(req.Size) <= s.end() || s.done)
+	if err := s.fill(ctx, req.Offset+int64(req.Size)); err != nil {
+		return err
+	}
+
+	var min int64
+	if req.Offset > s.end() {
+		min = s.end()
+	} else {
+		min = req.Offset
+	}
+
+	var max int64
+	if req.Offset+int64(req.Size) > s.end() {
+		max = s.end()
+	} else {
+		max = req.Offset + int64(req.Size)
+	}
+
+	resp.Data = readBuffer(resp, int(max-min))
+	n, err := s.buffer.ReadAt(resp.Data, min-s.offset)
+	if err != nil {
+		return err
+	}
+	resp.Data = resp.Data[:n]
+	bytesServed.WithLabelValues("transcoded").Add(float64(n))
+	fh.recordRead(n, hit, time.Since(started))
+	if prefetchNext && !fh.prefetched && s == fh.out && s.done && max == s.end() {
+		fh.prefetched = true
+		go prefetch(s.transcode.source, fh.profile)
+	}
+
+	if s == fh.out && s.done && !fh.sized {
+		fh.sized = true
+		storeSize(fh.name, s.transcode, uint64(fh.out.end()))
+		go sizeLearnt(fh.profile.key(fh.name))
+	}
+	// A short read tells the kernel where the file ends. Errors would make
+	// copies fail, and bazil turns io.EOF into EIO.
+	return nil
+}
+
+// recordRead counts a read of n bytes that took d in the stats of fh and of
+// all transcoded files
+func (fh *fileHandle) recordRead(n int, hit bool, d time.Duration) {
+	fh.stats.record(n, hit, d)
+	transcodedReads.record(n, hit, d)
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	readDuration.WithLabelValues(result).Observe(d.Seconds())
+}
+
+// sizeLearnt has the kernel get the attributes of the node at key again, now
+// that its exact size is known
+func sizeLearnt(key nodeKey) {
+	if n, ok := nodes.Load(key); ok {
+		eachServer(func(srv *fs.Server) {
+			srv.InvalidateNodeAttr(n.(fs.Node))
+		})
+	}
+}
+
+// readBuffer gives a slice of size bytes to read into, reusing the one
+// allocated along with the request instead of adding garbage
+func readBuffer(resp *fuse.ReadResponse, size int) []byte {
+	if cap(resp.Data) < size {
+		return make([]byte, size)
+	}
+	return resp.Data[:size]
+}
+
+type nativeFile struct {
+	readOnly
+	randomFile
+	path string
+}
+
+// A sourceVersion tells versions of a source file apart, for what was
+// derived from one not to be taken for what the next would give
+type sourceVersion struct {
+	size  int64
+	mtime time.Time
+}
+
+func versionOf(stat os.FileInfo) sourceVersion {
+	return sourceVersion{stat.Size(), stat.ModTime()}
+}
+
+func (v sourceVersion) equal(o sourceVersion) bool {
+	return v.size == o.size && v.mtime.Equal(o.mtime)
+}
+
+// Versions of native files when they were last opened, by path
+var nativeVersions sync.Map
+
+// sameNativeVersion tells whether the source at path in r is the same as
+// when it was last opened, so that what the kernel cached of it is still
+// valid
+func (r *Root) sameNativeVersion(path string) bool {
+	stat, err := r.src.Stat(path)
+	if err != nil {
+		return false
+	}
+	v := versionOf(stat)
+	old, ok := nativeVersions.Swap(path, v)
+	return ok && old.(sourceVersion).equal(v)
+}
+
+var _ fs.HandleReader = nativeFile{}
+var _ fs.HandleReleaser = nativeFile{}
+
+func (f nativeFile) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) (err error) {
+	started := time.Now()
+	defer trace("Read", started, &err, "path", f.path, "offset", req.Offset, "size", req.Size)
+	resp.Data = readBuffer(resp, req.Size)
+	n, err := f.ReadAt(resp.Data, req.Offset)
+	resp.Data = resp.Data[:n]
+	bytesServed.WithLabelValues("native").Add(float64(n))
+	d := time.Since(started)
+	nativeReads.record(n, false, d)
+	readDuration.WithLabelValues("native").Observe(d.Seconds())
+	if err == io.EOF {
+		err = nil
+	}
+	return err
+}
+
+func (f nativeFile) Release(ctx context.Context, req *fuse.ReleaseRequest) (err error) {
+	defer trace("Release", time.Now(), &err, "path", f.path)
+	return f.Close()
+}
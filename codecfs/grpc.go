@@ -0,0 +1,119 @@
+package codecfs
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"bazil.org/fuse"
+	"github.com/rakoo/codecfs/codecfs/controlpb"
+)
+
+// ListenGRPC serves the Control service of controlpb on addr, a TCP address
+// or the path of a unix socket prefixed with unix:. The service changes the
+// minimum level of logs through level, and can't when it is nil. It only
+// returns when addr can't be listened on.
+func ListenGRPC(addr string, level *slog.LevelVar) error {
+	var l net.Listener
+	var err error
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		l, err = listenUnix(path)
+	} else {
+		l, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	s := grpc.NewServer()
+	controlpb.RegisterControlServer(s, &grpcControl{level: level})
+	slog.Info("Serving gRPC", "addr", l.Addr().String())
+	return s.Serve(l)
+}
+
+// grpcControl implements the Control service like the control socket does
+type grpcControl struct {
+	controlpb.UnimplementedControlServer
+	level *slog.LevelVar
+}
+
+func (c *grpcControl) ListTranscodes(ctx context.Context, req *controlpb.ListTranscodesRequest) (*controlpb.ListTranscodesResponse, error) {
+	resp := &controlpb.ListTranscodesResponse{}
+	for _, t := range activeTranscodeStatus() {
+		resp.Transcodes = append(resp.Transcodes, &controlpb.Transcode{
+			Id:            t.ID,
+			Source:        t.Source,
+			Profile:       t.Profile,
+			Pid:           int32(t.Pid),
+			Started:       timestamppb.New(t.Started),
+			BytesProduced: t.Produced,
+		})
+	}
+	sort.Slice(resp.Transcodes, func(i, j int) bool {
+		return resp.Transcodes[i].Id < resp.Transcodes[j].Id
+	})
+	return resp, nil
+}
+
+func (c *grpcControl) CancelTranscode(ctx context.Context, req *controlpb.CancelTranscodeRequest) (*controlpb.CancelTranscodeResponse, error) {
+	err := cancelTranscode(req.Id)
+	if errors.Is(err, errNoTranscode) {
+		return nil, grpcstatus.Error(codes.NotFound, err.Error())
+	} else if err != nil {
+		return nil, grpcstatus.Error(codes.Internal, err.Error())
+	}
+	return &controlpb.CancelTranscodeResponse{}, nil
+}
+
+func (c *grpcControl) ListSizes(ctx context.Context, req *controlpb.ListSizesRequest) (*controlpb.ListSizesResponse, error) {
+	resp := &controlpb.ListSizesResponse{}
+	allSizes.Range(func(k, v interface{}) bool {
+		key := k.(sizeKey)
+		resp.Sizes = append(resp.Sizes, &controlpb.Size{
+			Tree: key.profile,
+			Name: key.name,
+			Size: v.(knownSize).size,
+		})
+		return true
+	})
+	return resp, nil
+}
+
+func (c *grpcControl) PruneCache(ctx context.Context, req *controlpb.PruneCacheRequest) (*controlpb.PruneCacheResponse, error) {
+	pruneCaches()
+	return &controlpb.PruneCacheResponse{}, nil
+}
+
+func (c *grpcControl) Warm(ctx context.Context, req *controlpb.WarmRequest) (*controlpb.WarmResponse, error) {
+	err := warm(ctx, req.Path)
+	switch {
+	case errors.Is(err, fuse.ENOENT):
+		return nil, grpcstatus.Errorf(codes.NotFound, "no %s in the mounts", req.Path)
+	case errors.Is(err, errNothingToWarm), errors.Is(err, errNoSizeCache):
+		return nil, grpcstatus.Error(codes.FailedPrecondition, err.Error())
+	case err != nil:
+		return nil, grpcstatus.Error(codes.Internal, err.Error())
+	}
+	return &controlpb.WarmResponse{}, nil
+}
+
+func (c *grpcControl) SetLogLevel(ctx context.Context, req *controlpb.SetLogLevelRequest) (*controlpb.SetLogLevelResponse, error) {
+	if c.level == nil {
+		return nil, grpcstatus.Error(codes.Unimplemented, "the level of logs can't be changed")
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(req.Level)); err != nil {
+		return nil, grpcstatus.Error(codes.InvalidArgument, err.Error())
+	}
+	previous := c.level.Level()
+	c.level.Set(level)
+	slog.Info("Log level changed", "from", previous, "to", level)
+	return &controlpb.SetLogLevelResponse{Previous: strings.ToLower(previous.String())}, nil
+}
@@ -0,0 +1,54 @@
+package codecfs
+
+import (
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcstatus "google.golang.org/grpc/status"
+
+	"github.com/rakoo/codecfs/codecfs/controlpb"
+)
+
+func TestGRPCControl(t *testing.T) {
+	addr := "unix:" + filepath.Join(t.TempDir(), "grpc")
+	var level slog.LevelVar
+	go ListenGRPC(addr, &level)
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := controlpb.NewControlClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := client.ListTranscodes(ctx, &controlpb.ListTranscodesRequest{}, grpc.WaitForReady(true)); err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.CancelTranscode(ctx, &controlpb.CancelTranscodeRequest{Id: 1 << 40})
+	if code := grpcstatus.Code(err); code != codes.NotFound {
+		t.Errorf("cancelling a missing transcode: %v", err)
+	}
+	_, err = client.Warm(ctx, &controlpb.WarmRequest{Path: "ogg"})
+	if code := grpcstatus.Code(err); code != codes.FailedPrecondition {
+		t.Errorf("warming without a size cache: %v", err)
+	}
+
+	resp, err := client.SetLogLevel(ctx, &controlpb.SetLogLevelRequest{Level: "debug"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Previous != "info" || level.Level() != slog.LevelDebug {
+		t.Errorf("log level changed from %s to %s", resp.Previous, level.Level())
+	}
+	_, err = client.SetLogLevel(ctx, &controlpb.SetLogLevelRequest{Level: "loud"})
+	if code := grpcstatus.Code(err); code != codes.InvalidArgument {
+		t.Errorf("setting an invalid level: %v", err)
+	}
+}
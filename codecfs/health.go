@@ -0,0 +1,157 @@
+package codecfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// How long each health check can take before it is considered failed
+const healthTimeout = 10 * time.Second
+
+// HealthCheck is the outcome of a health check, without Error when it
+// passed
+type HealthCheck struct {
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+}
+
+// CheckHealth checks that the mount responds, that the encoder works and
+// that caches are writable. Checks that don't apply are left out.
+func CheckHealth() ([]HealthCheck, bool) {
+	var checks []HealthCheck
+	healthy := true
+	add := func(name string, err error) {
+		c := HealthCheck{Name: name}
+		if err != nil {
+			c.Error = err.Error()
+			healthy = false
+		}
+		checks = append(checks, c)
+	}
+	for _, mountpoint := range mountpoints() {
+		add("mount "+mountpoint, checkMount(mountpoint))
+	}
+	checked := map[string]bool{}
+	for _, root := range roots() {
+		if p := root.ogg; !checked[p.name] {
+			checked[p.name] = true
+			add("encoder "+p.name, checkEncoder(p))
+		}
+	}
+	if sizeCachePath != "" {
+		add("cache", checkSizeCache())
+	}
+	add("tmp", checkTempDir())
+	return checks, healthy
+}
+
+// withTimeout runs check, giving up after healthTimeout. A hung FUSE mount
+// can block it forever.
+func withTimeout(check func() error) error {
+	done := make(chan error, 1)
+	go func() { done <- check() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(healthTimeout):
+		return errors.New("timed out")
+	}
+}
+
+// checkMount lists the root of the mount at mountpoint through the kernel
+func checkMount(mountpoint string) error {
+	return withTimeout(func() error {
+		_, err := os.ReadDir(mountpoint)
+		return err
+	})
+}
+
+// checkEncoder encodes a sample with p, as the mount would
+func checkEncoder(p *profile) error {
+	cmd := encoderCommand("ffmpeg", p.ffmpegArgs(pipeInput, 0)...)
+	cmd.Stdin = bytes.NewReader(sampleWAV())
+	var out, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	err := withTimeout(cmd.Wait)
+	if err != nil {
+		cmd.Process.Kill()
+		if stderr.Len() > 0 {
+			return fmt.Errorf("%v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+		}
+		return err
+	}
+	if out.Len() == 0 {
+		return errors.New("no output")
+	}
+	return nil
+}
+
+// sampleWAV gives a tenth of a second of silence, in 16-bit 8kHz mono WAV
+func sampleWAV() []byte {
+	const rate, samples = 8000, 800
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(36+2*samples))
+	b.WriteString("WAVEfmt ")
+	for _, v := range []any{uint32(16), uint16(1), uint16(1), uint32(rate), uint32(2 * rate), uint16(2), uint16(16)} {
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, uint32(2*samples))
+	b.Write(make([]byte, 2*samples))
+	return b.Bytes()
+}
+
+// Key written and deleted again to check the size cache
+var healthKey = []byte("\x00healthcheck")
+
+// checkSizeCache writes to the size cache
+func checkSizeCache() error {
+	if sizeDB == nil {
+		return fmt.Errorf("%s couldn't be opened", sizeCachePath)
+	}
+	return withTimeout(func() error {
+		return sizeDB.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket(sizeBucket)
+			if err := b.Put(healthKey, nil); err != nil {
+				return err
+			}
+			return b.Delete(healthKey)
+		})
+	})
+}
+
+// checkTempDir writes to the directory buffers are spilled and uploads are
+// kept in
+func checkTempDir() error {
+	f, err := os.CreateTemp("", "codecfs-health-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	_, err = f.Write([]byte("ok"))
+	return err
+}
+
+// serveHealth answers with the outcome of the health checks, as a 200 when
+// they all passed and a 503 otherwise
+func serveHealth(w http.ResponseWriter, r *http.Request) {
+	checks, healthy := CheckHealth()
+	w.Header().Set("Content-Type", "application/json")
+	if !healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(checks)
+}
@@ -0,0 +1,75 @@
+package codecfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestSampleWAV(t *testing.T) {
+	wav := sampleWAV()
+	if len(wav) != 44+2*800 || !bytes.HasPrefix(wav, []byte("RIFF")) || string(wav[8:16]) != "WAVEfmt " {
+		t.Errorf("sample of %d bytes starts with %q", len(wav), wav[:16])
+	}
+}
+
+func TestHealthChecks(t *testing.T) {
+	if err := checkSizeCache(); err == nil {
+		t.Error("size cache healthy without a database")
+	}
+	withSizeCache(t)
+	if err := checkSizeCache(); err != nil {
+		t.Error(err)
+	}
+	if err := checkTempDir(); err != nil {
+		t.Error(err)
+	}
+	dir := t.TempDir()
+	if err := checkMount(dir); err != nil {
+		t.Error(err)
+	}
+	if err := checkMount(filepath.Join(dir, "missing")); err == nil {
+		t.Error("missing mount healthy")
+	}
+}
+
+// getHealth gives the errors of the health checks by name, and checks that
+// the status tells whether they all passed
+func getHealth(t *testing.T) map[string]string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	serveHealth(w, httptest.NewRequest("GET", "/health", nil))
+	var checks []HealthCheck
+	if err := json.NewDecoder(w.Body).Decode(&checks); err != nil {
+		t.Fatal(err)
+	}
+	healthy := true
+	errs := map[string]string{}
+	for _, c := range checks {
+		errs[c.Name] = c.Error
+		healthy = healthy && c.Error == ""
+	}
+	if healthy != (w.Code == http.StatusOK) || !healthy && w.Code != http.StatusServiceUnavailable {
+		t.Errorf("answered %d to %+v", w.Code, checks)
+	}
+	return errs
+}
+
+func TestServeHealth(t *testing.T) {
+	errs := getHealth(t)
+	if err, ok := errs["tmp"]; !ok || err != "" {
+		t.Errorf("temporary directory check: %q", err)
+	}
+	if _, ok := errs["cache"]; ok {
+		t.Error("size cache checked without one")
+	}
+
+	sizeCachePath = filepath.Join(t.TempDir(), "sizes.db")
+	defer func() { sizeCachePath = "" }()
+	if errs := getHealth(t); errs["cache"] == "" {
+		t.Error("unopened size cache healthy")
+	}
+}
@@ -0,0 +1,89 @@
+package codecfs
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+)
+
+// httpHandler streams the files of the tree under root over plain HTTP
+type httpHandler struct {
+	root *Root
+}
+
+func (h httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	f, err := openPath(r.Context(), h.root, path.Clean("/"+r.URL.Path))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if os.IsNotExist(davError(err)) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	defer f.Close()
+
+	if f.attr.Mode.IsDir() {
+		h.serveDir(w, r, f)
+		return
+	}
+	fi := treeFileInfo{f.name, f.node, f.attr}
+	if t := fi.contentType(); t != "" {
+		w.Header().Set("Content-Type", t)
+	}
+	if _, ok := f.knownSize(); ok {
+		// Seeking to the end is cheap, ServeContent takes care of
+		// lengths and ranges
+		http.ServeContent(w, r, f.name, f.attr.Mtime, f)
+		return
+	}
+
+	// Without the size, ranges can't be answered: stream the whole file
+	// while it is being transcoded
+	w.Header().Set("Accept-Ranges", "none")
+	w.Header().Set("Last-Modified", f.attr.Mtime.UTC().Format(http.TimeFormat))
+	if r.Method == http.MethodHead {
+		return
+	}
+	io.Copy(w, f)
+}
+
+// serveDir lists the directory f as links
+func (h httpHandler) serveDir(w http.ResponseWriter, r *http.Request, f *treeFile) {
+	if r.URL.Path[len(r.URL.Path)-1] != '/' {
+		http.Redirect(w, r, path.Base(r.URL.Path)+"/", http.StatusMovedPermanently)
+		return
+	}
+	infos, err := f.Readdir(-1)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	sort.Slice(infos, func(i, j int) bool { return h.root.lessNames(infos[i].Name(), infos[j].Name()) })
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintln(w, "<pre>")
+	for _, fi := range infos {
+		name := fi.Name()
+		if fi.IsDir() {
+			name += "/"
+		}
+		u := url.URL{Path: name}
+		fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n", u.String(), html.EscapeString(name))
+	}
+	fmt.Fprintln(w, "</pre>")
+}
+
+// ListenHTTP streams the files of the tree over HTTP on addr
+func (r *Root) ListenHTTP(addr string) error {
+	return http.ListenAndServe(addr, httpHandler{r})
+}
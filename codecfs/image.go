@@ -0,0 +1,105 @@
+package codecfs
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Extensions of the images converted by image profiles: formats many
+// viewers can't read, and the raw files of cameras
+var imageExts = map[string]bool{
+	".heic": true, ".heif": true, ".avif": true, ".webp": true,
+	".arw": true, ".cr2": true, ".cr3": true, ".dng": true, ".nef": true,
+	".orf": true, ".pef": true, ".raf": true, ".rw2": true, ".srw": true,
+}
+
+// isImage tells whether the source at path is an image to convert
+func isImage(path string) bool {
+	return imageExts[strings.ToLower(filepath.Ext(path))]
+}
+
+// jpegProfile derives the profile of the jpeg tree, with photos converted to
+// JPEG of the given quality from 1 to 100, from base, keeping its filters
+func jpegProfile(base *profile, quality int) *profile {
+	p := *base
+	p.name = "jpeg"
+	p.format = "jpeg"
+	p.ext = ".jpg"
+	p.images = true
+	p.quality = strconv.Itoa(quality)
+	p.bitrate = ""
+	p.fixedQuality = true
+	p.rateControl = ""
+	p.sampleRate = 0
+	p.channels = 0
+	// Everything but images would be hidden otherwise
+	p.mediaOnly = false
+	return &p
+}
+
+var (
+	magickOnce sync.Once
+	magick     string
+)
+
+// magickCommand gives the command of ImageMagick: magick since version 7,
+// convert before
+func magickCommand() string {
+	magickOnce.Do(func() {
+		magick = "magick"
+		if _, err := exec.LookPath(magick); err != nil {
+			magick = "convert"
+		}
+	})
+	return magick
+}
+
+// magickConverts tells whether source is converted by ImageMagick in the
+// tree of p. ffmpeg takes care of the rest.
+func (p *profile) magickConverts(source string) bool {
+	return p.images && isImage(source)
+}
+
+// imageArgs gives the arguments of ImageMagick to convert source, read from
+// stdin, according to p
+func (p *profile) imageArgs(source string) []string {
+	// The format can't be sniffed from a pipe for every raw format
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(source)), ".")
+	args := []string{format + ":-", "-auto-orient"}
+	if p.thumbnail > 0 {
+		size := strconv.Itoa(p.thumbnail)
+		args = append(args, "-thumbnail", size+"x"+size)
+	}
+	if p.quality != "" {
+		args = append(args, "-quality", p.quality)
+	}
+	return append(args, "-strip", p.format+":-")
+}
+
+// readsStdin tells whether the command for source is fed the source rather
+// than reading it by itself
+func (p *profile) readsStdin(source string) bool {
+	return p.magickConverts(source) || p.rule != nil && p.rule.readsStdin()
+}
+
+// command gives the command encoding source, reachable at input, from
+// timestamp start, along with its arguments
+func (p *profile) command(source, input string, start time.Duration) (string, []string) {
+	if p.rule != nil {
+		return p.rule.command(input)
+	}
+	if p.magickConverts(source) {
+		return magickCommand(), p.imageArgs(source)
+	}
+	if p.thumbnail > 0 {
+		return "ffmpeg", p.thumbnailArgs(input)
+	}
+	if p.video {
+		return "ffmpeg", p.videoArgs(source, input)
+	}
+	return "ffmpeg", p.ffmpegArgs(input, start)
+}
@@ -0,0 +1,263 @@
+package codecfs
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+)
+
+const ingestDirName = "ingest"
+
+var _ fs.NodeCreater = &ingestDir{}
+var _ fs.HandleReadDirAller = &ingestDir{}
+var _ fs.NodeStringLookuper = &ingestDir{}
+
+// ingestDir is a drop folder: files written to it are converted to the
+// Ingest format of the root once closed, and the result stored at the top of the source.
+// It only lists the files still being written.
+type ingestDir struct {
+	readOnly
+	root *Root
+
+	mu      sync.Mutex
+	pending map[string]*ingestFile
+}
+
+func newIngestDir(root *Root) *ingestDir {
+	return &ingestDir{root: root, pending: map[string]*ingestFile{}}
+}
+
+// setOwner gives a node to the user running the mount, so that they can write
+// to it with default permissions
+func setOwner(a *fuse.Attr) {
+	a.Uid = uint32(os.Getuid())
+	a.Gid = uint32(os.Getgid())
+}
+
+func (d *ingestDir) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Inode = inode("", ingestDirName)
+	a.Mode = os.ModeDir | 0755
+	a.Mtime = started
+	setOwner(a)
+	return nil
+}
+
+func (d *ingestDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var out []fuse.Dirent
+	for name := range d.pending {
+		out = append(out, fuse.Dirent{
+			Inode: inode("", ingestDirName+"/"+name),
+			Type:  fuse.DT_File,
+			Name:  name,
+		})
+	}
+	return out, nil
+}
+
+func (d *ingestDir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if f, ok := d.pending[name]; ok {
+		return f, nil
+	}
+	return nil, fuse.ENOENT
+}
+
+func (d *ingestDir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.CreateResponse) (fs.Node, fs.Handle, error) {
+	if strings.HasPrefix(req.Name, ".") {
+		// Editors and copy tools' temporary files have nothing to
+		// convert
+		return nil, nil, fuse.Errno(syscall.EACCES)
+	}
+	tmp, err := os.CreateTemp("", "codecfs-ingest-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	os.Remove(tmp.Name())
+
+	f := &ingestFile{dir: d, name: req.Name, tmp: tmp, opens: 1}
+	d.mu.Lock()
+	if old, ok := d.pending[req.Name]; ok {
+		old.discard()
+	}
+	d.pending[req.Name] = f
+	d.mu.Unlock()
+	slog.Info("Ingesting file", "name", req.Name)
+	return f, f, nil
+}
+
+func (d *ingestDir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
+	d.mu.Lock()
+	f, ok := d.pending[req.Name]
+	delete(d.pending, req.Name)
+	d.mu.Unlock()
+	if !ok {
+		return fuse.ENOENT
+	}
+	f.discard()
+	return nil
+}
+
+var _ fs.NodeOpener = &ingestFile{}
+var _ fs.NodeSetattrer = &ingestFile{}
+var _ fs.HandleWriter = &ingestFile{}
+var _ fs.HandleReader = &ingestFile{}
+var _ fs.HandleReleaser = &ingestFile{}
+
+// ingestFile is a file being written to the ingest directory. The data goes
+// to an unlinked temporary file until the last handle is released.
+type ingestFile struct {
+	readOnly
+	dir  *ingestDir
+	name string
+
+	mu    sync.Mutex
+	tmp   *os.File
+	size  int64
+	opens int
+	// Set once the last handle is released, or when the file is dropped
+	closed    bool
+	discarded bool
+}
+
+func (f *ingestFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	a.Inode = inode("", ingestDirName+"/"+f.name)
+	a.Mode = 0644
+	a.Size = uint64(f.size)
+	a.Mtime = time.Now()
+	setOwner(a)
+	return nil
+}
+
+func (f *ingestFile) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil, fuse.ENOENT
+	}
+	f.opens++
+	return f, nil
+}
+
+// Setattr lets the file be truncated; other changes like the times and
+// modes copy tools set are accepted and ignored
+func (f *ingestFile) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if req.Valid.Size() {
+		if err := f.tmp.Truncate(int64(req.Size)); err != nil {
+			return err
+		}
+		f.size = int64(req.Size)
+	}
+	return nil
+}
+
+func (f *ingestFile) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err := f.tmp.WriteAt(req.Data, req.Offset)
+	resp.Size = n
+	f.size = max(f.size, req.Offset+int64(n))
+	return err
+}
+
+func (f *ingestFile) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resp.Data = readBuffer(resp, req.Size)
+	n, err := f.tmp.ReadAt(resp.Data, req.Offset)
+	resp.Data = resp.Data[:n]
+	if err == io.EOF {
+		err = nil
+	}
+	return err
+}
+
+func (f *ingestFile) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	f.mu.Lock()
+	f.opens--
+	done := f.opens == 0 && !f.closed
+	if done {
+		f.closed = true
+	}
+	f.mu.Unlock()
+	if !done {
+		return nil
+	}
+
+	f.dir.mu.Lock()
+	if f.dir.pending[f.name] == f {
+		delete(f.dir.pending, f.name)
+	}
+	f.dir.mu.Unlock()
+	go f.convert()
+	return nil
+}
+
+// discard drops the file without converting it
+func (f *ingestFile) discard() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	if !f.discarded {
+		f.discarded = true
+		f.tmp.Close()
+	}
+}
+
+// convert stores the file in the source, converted to the Ingest format of
+// the root. Existing files are never overwritten.
+func (f *ingestFile) convert() {
+	defer f.discard()
+	base := strings.TrimSuffix(f.name, filepath.Ext(f.name)) + "." + f.dir.root.opts.Ingest
+	dest := filepath.Join(f.dir.root.dir, base)
+	// Written under a hidden name first, so that the file only shows up
+	// complete
+	partial := filepath.Join(f.dir.root.dir, ".codecfs-ingest-"+base)
+
+	// Checked again when moving it in place, this saves the encode
+	if _, err := os.Lstat(dest); err == nil {
+		slog.Warn("Not ingesting file over an existing one", "name", f.name, "dest", dest)
+		return
+	}
+	// ffmpeg reads the unlinked file through our descriptor
+	cmd := encoderCommand("ffmpeg", "-nostdin", "-y", "-i", "/dev/fd/3", partial)
+	cmd.ExtraFiles = []*os.File{f.tmp}
+	stderr := &tailBuffer{}
+	cmd.Stderr = stderr
+	err := cmd.Start()
+	if err == nil {
+		applyPriority(cmd.Process.Pid)
+		err = cmd.Wait()
+	}
+	if err != nil {
+		os.Remove(partial)
+		slog.Warn("Ingesting file failed", "name", f.name, "err", err, "stderr", string(stderr.buf))
+		return
+	}
+	// Unlike a rename, a link fails if dest showed up during the encode
+	err = os.Link(partial, dest)
+	os.Remove(partial)
+	if os.IsExist(err) {
+		slog.Warn("Not ingesting file over an existing one", "name", f.name, "dest", dest)
+		return
+	} else if err != nil {
+		slog.Warn("Ingesting file failed", "name", f.name, "err", err)
+		return
+	}
+	slog.Info("Ingested file", "name", f.name, "dest", dest)
+}
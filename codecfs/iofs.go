@@ -0,0 +1,78 @@
+package codecfs
+
+import (
+	"io"
+	iofs "io/fs"
+	"sort"
+
+	"golang.org/x/net/context"
+)
+
+var _ iofs.ReadDirFS = treeFS{}
+var _ iofs.StatFS = treeFS{}
+var _ iofs.ReadDirFile = &treeFile{}
+
+// FS gives the tree as an io/fs file system, for programs that use it
+// without mounting it, as in http.FileServer(http.FS(root.FS())). Files are
+// transcoded as they are read. Seeking relative to their end, as
+// http.ServeContent does to learn their size, takes transcoding them to the
+// end unless their exact size is known.
+func (r *Root) FS() iofs.FS {
+	return treeFS{r}
+}
+
+// treeFS is the tree as an io/fs file system
+type treeFS struct {
+	root *Root
+}
+
+func (t treeFS) open(op, name string) (*treeFile, error) {
+	if !iofs.ValidPath(name) {
+		return nil, &iofs.PathError{Op: op, Path: name, Err: iofs.ErrInvalid}
+	}
+	f, err := openPath(context.Background(), t.root, name)
+	if err != nil {
+		return nil, &iofs.PathError{Op: op, Path: name, Err: davError(err)}
+	}
+	return f, nil
+}
+
+func (t treeFS) Open(name string) (iofs.File, error) {
+	return t.open("open", name)
+}
+
+func (t treeFS) Stat(name string) (iofs.FileInfo, error) {
+	f, err := t.open("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return f.Stat()
+}
+
+func (t treeFS) ReadDir(name string) ([]iofs.DirEntry, error) {
+	f, err := t.open("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ents, err := f.ReadDir(-1)
+	sort.Slice(ents, func(i, j int) bool {
+		return ents[i].Name() < ents[j].Name()
+	})
+	return ents, err
+}
+
+// ReadDir is Readdir for io/fs
+func (f *treeFile) ReadDir(n int) ([]iofs.DirEntry, error) {
+	infos, err := f.Readdir(n)
+	if err == io.EOF {
+		return nil, err
+	} else if err != nil {
+		return nil, &iofs.PathError{Op: "readdir", Path: f.name, Err: davError(err)}
+	}
+	ents := make([]iofs.DirEntry, len(infos))
+	for i, info := range infos {
+		ents[i] = iofs.FileInfoToDirEntry(info)
+	}
+	return ents, nil
+}
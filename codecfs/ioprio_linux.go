@@ -0,0 +1,13 @@
+package codecfs
+
+import "syscall"
+
+// setIOPriority sets the I/O priority of the process pid
+func setIOPriority(pid int, p ioPriority) error {
+	const whoProcess = 1
+	_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, whoProcess, uintptr(pid), uintptr(p.class<<13|p.level))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package codecfs
+
+// Only Linux has I/O priorities
+func setIOPriority(pid int, p ioPriority) error {
+	return nil
+}
@@ -0,0 +1,81 @@
+package codecfs
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// limits bound what transcodes and their readers take. They start as the
+// options give them, and the -limits file, read again on reload, overrides
+// them.
+type limits struct {
+	// How many bytes of encoder output all handles can hold in memory, 0
+	// for no limit. Over it, buffers growing further are spilled to
+	// temporary files and new encoders wait for memory to be freed.
+	MemoryBudget int64 `toml:"memory_budget"`
+	// When not 0, encoders running for longer are killed, and their
+	// source is not transcoded again until it changes
+	TranscodeTimeout time.Duration `toml:"transcode_timeout"`
+	// When not 0, handles of transcoded files are read no faster than
+	// that many times the bitrate of their output
+	ReadRateFactor float64 `toml:"read_rate_factor"`
+	// When not 0, handles of transcoded files are read no faster than
+	// that many bytes per second
+	MaxReadRate int64 `toml:"max_read_rate"`
+}
+
+func (l limits) validate() error {
+	switch {
+	case l.MemoryBudget < 0:
+		return errors.New("the memory budget can't be negative")
+	case l.TranscodeTimeout < 0:
+		return errors.New("the transcode timeout can't be negative")
+	case l.ReadRateFactor < 0 || l.MaxReadRate < 0:
+		return errors.New("read rates can't be negative")
+	}
+	return nil
+}
+
+var (
+	// The limits of the options, and the file overriding them
+	baseLimits limits
+	limitsPath string
+
+	processLimits atomic.Pointer[limits]
+)
+
+// currentLimits gives the limits in effect
+func currentLimits() limits {
+	if l := processLimits.Load(); l != nil {
+		return *l
+	}
+	return limits{}
+}
+
+// loadLimits reads the limits file over the limits of the options. Settings
+// missing from it keep their value from the options.
+func loadLimits() (limits, error) {
+	l := baseLimits
+	if limitsPath == "" {
+		return l, nil
+	}
+	if _, err := toml.DecodeFile(limitsPath, &l); err != nil {
+		return l, fmt.Errorf("%s: %v", limitsPath, err)
+	}
+	if err := l.validate(); err != nil {
+		return l, fmt.Errorf("%s: %v", limitsPath, err)
+	}
+	return l, nil
+}
+
+// setLimits puts l in effect. Encoders already running keep the timeout
+// they started with.
+func setLimits(l limits) {
+	processLimits.Store(&l)
+	// The budget may have grown, let the waiting encoders check it again
+	wakeMemoryWaiters()
+}
@@ -0,0 +1,35 @@
+package codecfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadLimits(t *testing.T) {
+	defer func(base limits, path string) {
+		baseLimits, limitsPath = base, path
+	}(baseLimits, limitsPath)
+	baseLimits = limits{MemoryBudget: 1 << 20, MaxReadRate: 1000}
+	limitsPath = filepath.Join(t.TempDir(), "limits.toml")
+
+	if err := os.WriteFile(limitsPath, []byte("memory_budget = 4096\ntranscode_timeout = \"5m\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l, err := loadLimits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := limits{MemoryBudget: 4096, TranscodeTimeout: 5 * time.Minute, MaxReadRate: 1000}
+	if l != want {
+		t.Errorf("got %+v, want %+v", l, want)
+	}
+
+	if err := os.WriteFile(limitsPath, []byte("read_rate_factor = -1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadLimits(); err == nil {
+		t.Error("negative read rate accepted")
+	}
+}
@@ -0,0 +1,142 @@
+package codecfs
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// flacProfile derives the profile of the flac tree from base, keeping its
+// filters, with encodes of the given compression level from 0 to 12
+func flacProfile(base *profile, level int) *profile {
+	p := *base
+	p.name = "flac"
+	p.format = "flac"
+	p.ext = ".flac"
+	p.quality = ""
+	p.bitrate = ""
+	p.fixedQuality = true
+	p.rateControl = ""
+	p.sampleRate = 0
+	p.channels = 0
+	p.lossless = true
+	p.minBitrate = 0
+	p.minSize = 0
+	p.compressionLevel = strconv.Itoa(level)
+	return &p
+}
+
+// transcodes tells whether the source file at path is transcoded in the tree
+// of p rather than shown as it is. Image trees only convert images, and
+// trees of rules what they match. Lossless trees leave lossy sources alone:
+// their encodes would be bigger without sounding any better, and so do
+// trees with a minimum bitrate with sources below it. Files under the
+// minimum size aren't worth starting an encoder for. Video trees only
+// encode sources with a picture besides cover art.
+func (p *profile) transcodes(path string) bool {
+	if p.rule != nil {
+		return p.rule.matches(path)
+	}
+	if p.thumbnail > 0 {
+		return isImage(path) || p.root.isVisual(path)
+	}
+	if p.images {
+		return isImage(path)
+	}
+	if p.minSize > 0 {
+		// Not even worth sniffing
+		if stat, err := p.root.statSource(path); err == nil && stat.Size() < p.minSize {
+			return false
+		}
+	}
+	if !p.root.isAudio(path) {
+		return false
+	}
+	if p.video {
+		return p.root.probeSource(path).video
+	}
+	if p.lossless && !p.root.losslessSource(path) {
+		return false
+	}
+	if p.minBitrate > 0 {
+		if rate := p.root.probeSource(path).bitrate; rate > 0 && rate <= p.minBitrate*1000 {
+			return false
+		}
+	}
+	return true
+}
+
+// Lossless audio codecs, as named by ffprobe. PCM codecs are all named pcm_*.
+var losslessCodecs = map[string]bool{
+	"flac":    true,
+	"alac":    true,
+	"ape":     true,
+	"wavpack": true,
+	"tta":     true,
+	"tak":     true,
+	"shorten": true,
+	"mlp":     true,
+	"truehd":  true,
+	"mp4als":  true,
+}
+
+// What ffprobe tells about a source
+type sourceInfo struct {
+	mtime time.Time
+	// Codec of the first audio stream, empty if unknown
+	codec string
+	// Overall bitrate in bit/s, 0 if unknown
+	bitrate int64
+	// Whether it has a video stream that isn't cover art
+	video bool
+}
+
+// Info about sources, by path
+var sourceInfos sync.Map
+
+// probeSource gives what ffprobe tells about the source at path in r
+func (r *Root) probeSource(path string) sourceInfo {
+	stat, err := r.statSource(path)
+	if err != nil {
+		return sourceInfo{}
+	}
+	if v, ok := sourceInfos.Load(path); ok && v.(sourceInfo).mtime.Equal(stat.ModTime()) {
+		return v.(sourceInfo)
+	}
+	info := sourceInfo{mtime: stat.ModTime()}
+	out, err := r.probe(path, "-v", "error", "-show_entries", "stream=codec_name,codec_type:stream_disposition=attached_pic:format=bit_rate", "-of", "json")
+	var probed struct {
+		Streams []struct {
+			Codec       string `json:"codec_name"`
+			Type        string `json:"codec_type"`
+			Disposition struct {
+				AttachedPic int `json:"attached_pic"`
+			} `json:"disposition"`
+		} `json:"streams"`
+		Format struct {
+			Bitrate string `json:"bit_rate"`
+		} `json:"format"`
+	}
+	if err == nil && json.Unmarshal(out, &probed) == nil {
+		for _, stream := range probed.Streams {
+			switch {
+			case stream.Type == "audio" && info.codec == "":
+				info.codec = stream.Codec
+			case stream.Type == "video" && stream.Disposition.AttachedPic == 0:
+				info.video = true
+			}
+		}
+		info.bitrate, _ = strconv.ParseInt(probed.Format.Bitrate, 10, 64)
+	}
+	sourceInfos.Store(path, info)
+	return info
+}
+
+// losslessSource tells whether the source at path in r is encoded
+// losslessly. Sources ffprobe can't tell about are taken as lossless.
+func (r *Root) losslessSource(path string) bool {
+	codec := r.probeSource(path).codec
+	return codec == "" || losslessCodecs[codec] || strings.HasPrefix(codec, "pcm_")
+}
@@ -0,0 +1,171 @@
+package codecfs
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Buffers smaller than that stay in memory whatever the budget, since
+// spilling them would hardly help
+const spillMinimum = 1 << 20
+
+// How long a new encoder waits for memory before starting anyway
+var memoryWait = 30 * time.Second
+
+// Bytes of encoder output held in memory by all handles
+var bufferedBytes atomic.Int64
+
+// Closed and replaced whenever memory is freed under the budget, to wake up
+// the encoders waiting for it
+var (
+	memoryMu    sync.Mutex
+	memoryFreed = make(chan struct{})
+)
+
+// overBudget tells whether handles hold more memory than the budget
+func overBudget() bool {
+	budget := currentLimits().MemoryBudget
+	return budget > 0 && bufferedBytes.Load() > budget
+}
+
+// addBuffered counts delta more bytes held in memory
+func addBuffered(delta int64) {
+	total := bufferedBytes.Add(delta)
+	bufferedMemory.Set(float64(total))
+	budget := currentLimits().MemoryBudget
+	if delta < 0 && budget > 0 && total <= budget && total-delta > budget {
+		wakeMemoryWaiters()
+	}
+}
+
+// wakeMemoryWaiters has the encoders waiting for memory check the budget
+// again
+func wakeMemoryWaiters() {
+	memoryMu.Lock()
+	close(memoryFreed)
+	memoryFreed = make(chan struct{})
+	memoryMu.Unlock()
+}
+
+// waitForMemory blocks while handles hold more memory than the budget, for
+// at most memoryWait
+func waitForMemory() {
+	if !overBudget() {
+		return
+	}
+	began := time.Now()
+	deadline := time.After(memoryWait)
+	for overBudget() {
+		memoryMu.Lock()
+		freed := memoryFreed
+		memoryMu.Unlock()
+		select {
+		case <-freed:
+		case <-deadline:
+			slog.Warn("Starting encoder over the memory budget", "buffered", bufferedBytes.Load(), "budget", currentLimits().MemoryBudget)
+			return
+		}
+	}
+	slog.Debug("Encoder waited for memory", "duration", time.Since(began))
+}
+
+// spillBuffer holds the output of an encoder, in memory or, once spilled
+// under memory pressure, in an unlinked temporary file
+type spillBuffer struct {
+	mem bytes.Buffer
+	// Bytes of mem counted in bufferedBytes
+	counted int64
+
+	file *os.File
+	// Offset in file of the first byte held, and number of bytes held
+	start, size int64
+}
+
+func (b *spillBuffer) Len() int {
+	if b.file != nil {
+		return int(b.size)
+	}
+	return b.mem.Len()
+}
+
+// account brings bufferedBytes up to date with the size of mem
+func (b *spillBuffer) account() {
+	if n := int64(b.mem.Len()); n != b.counted {
+		addBuffered(n - b.counted)
+		b.counted = n
+	}
+}
+
+func (b *spillBuffer) Write(p []byte) (int, error) {
+	if b.file == nil && overBudget() && b.mem.Len()+len(p) >= spillMinimum {
+		b.spill()
+	}
+	if b.file != nil {
+		n, err := b.file.WriteAt(p, b.start+b.size)
+		b.size += int64(n)
+		return n, err
+	}
+	n, err := b.mem.Write(p)
+	b.account()
+	return n, err
+}
+
+// spill moves the buffered bytes to a temporary file, or leaves them in
+// memory if there is none to be had
+func (b *spillBuffer) spill() {
+	f, err := os.CreateTemp("", "codecfs-spill-*")
+	if err != nil {
+		slog.Warn("Can't spill buffer to disk", "err", err)
+		return
+	}
+	os.Remove(f.Name())
+	if _, err := f.Write(b.mem.Bytes()); err != nil {
+		slog.Warn("Can't spill buffer to disk", "err", err)
+		f.Close()
+		return
+	}
+	slog.Debug("Spilled buffer to disk", "size", b.mem.Len())
+	b.file, b.start, b.size = f, 0, int64(b.mem.Len())
+	// Let the memory go rather than keep it for later writes
+	b.mem = bytes.Buffer{}
+	b.account()
+}
+
+// Next drops the first n bytes
+func (b *spillBuffer) Next(n int) {
+	if b.file == nil {
+		b.mem.Next(n)
+		b.account()
+		return
+	}
+	b.start += int64(n)
+	b.size -= int64(n)
+	if b.size == 0 {
+		// Start over at the beginning of the file rather than let it grow
+		b.file.Truncate(0)
+		b.start = 0
+	}
+}
+
+// ReadAt copies the bytes held from offset off into p, which must be within
+// what is held
+func (b *spillBuffer) ReadAt(p []byte, off int64) (int, error) {
+	if b.file != nil {
+		return b.file.ReadAt(p, b.start+off)
+	}
+	return copy(p, b.mem.Bytes()[off:]), nil
+}
+
+// Reset drops everything, and the temporary file if there is one
+func (b *spillBuffer) Reset() {
+	b.mem = bytes.Buffer{}
+	b.account()
+	if b.file != nil {
+		b.file.Close()
+		b.file, b.start, b.size = nil, 0, 0
+	}
+}
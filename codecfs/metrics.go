@@ -0,0 +1,62 @@
+package codecfs
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	activeTranscodes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "codecfs_active_transcodes",
+		Help: "Number of encoders currently running.",
+	})
+	transcodeDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "codecfs_transcode_duration_seconds",
+		Help:    "Time between the start of an encoder and the release of its handle.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	})
+	sizeCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "codecfs_size_cache_requests_total",
+		Help: "Lookups of the exact size of transcoded files, by result.",
+	}, []string{"result"})
+	bytesServed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "codecfs_bytes_served_total",
+		Help: "Bytes returned to readers, by kind of file.",
+	}, []string{"kind"})
+	readDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "codecfs_read_duration_seconds",
+		Help:    "Time taken by reads, by whether transcoded ones were served from buffered output (hit) or not (miss), or native.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+	}, []string{"result"})
+	ffmpegFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "codecfs_ffmpeg_failures_total",
+		Help: "Number of encoders that exited with an error.",
+	})
+	bufferedMemory = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "codecfs_buffered_bytes",
+		Help: "Bytes of encoder output held in memory by open handles.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		activeTranscodes,
+		transcodeDuration,
+		sizeCacheRequests,
+		bytesServed,
+		readDuration,
+		ffmpegFailures,
+		bufferedMemory,
+	)
+}
+
+// ListenMetrics exposes the metrics for Prometheus on addr, under /metrics,
+// and the health checks under /healthz
+func ListenMetrics(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", serveHealth)
+	return http.ListenAndServe(addr, mux)
+}
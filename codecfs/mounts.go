@@ -0,0 +1,58 @@
+package codecfs
+
+import (
+	"sync"
+
+	"bazil.org/fuse/fs"
+)
+
+// What is mounted by the process: the roots of the trees, and the servers
+// telling the kernel about changes in them
+var (
+	mountsMu    sync.Mutex
+	mountRoots  []*Root
+	servers     []*fs.Server
+	mountPoints []string
+)
+
+// addMount records a tree built by the process
+func addMount(root *Root) {
+	mountsMu.Lock()
+	defer mountsMu.Unlock()
+	mountRoots = append(mountRoots, root)
+}
+
+// addServer records a server of a tree mounted on mountpoint
+func addServer(srv *fs.Server, mountpoint string) {
+	mountsMu.Lock()
+	defer mountsMu.Unlock()
+	servers = append(servers, srv)
+	mountPoints = append(mountPoints, mountpoint)
+}
+
+// roots gives the roots of the mounted trees
+func roots() []*Root {
+	mountsMu.Lock()
+	defer mountsMu.Unlock()
+	return mountRoots
+}
+
+// mountpoints gives where the trees are mounted, none when they are served
+// over the network
+func mountpoints() []string {
+	mountsMu.Lock()
+	defer mountsMu.Unlock()
+	return mountPoints
+}
+
+// eachServer calls f with every server. A node only belongs to one of
+// them: the others answer its invalidations with fuse.ErrNotCached, which
+// callers ignore anyway.
+func eachServer(f func(*fs.Server)) {
+	mountsMu.Lock()
+	all := servers
+	mountsMu.Unlock()
+	for _, srv := range all {
+		f(srv)
+	}
+}
@@ -0,0 +1,125 @@
+package codecfs
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Letters that don't decompose into an ASCII letter and marks, spelled out
+// in ASCII
+var asciiSpellings = map[rune]string{
+	'Æ': "AE", 'æ': "ae", 'Ð': "D", 'ð': "d", 'Ø': "O", 'ø': "o",
+	'Þ': "TH", 'þ': "th", 'ß': "ss", 'Đ': "D", 'đ': "d", 'Ħ': "H",
+	'ħ': "h", 'ı': "i", 'Ł': "L", 'ł': "l", 'Œ': "OE", 'œ': "oe",
+	'‘': "'", '’': "'", '“': "'", '”': "'", '–': "-", '—': "-",
+	'…': "...",
+}
+
+// sanitizeName gives the name a FAT filesystem can hold for name, under
+// SanitizeNames: accents are dropped, other characters that aren't ASCII or
+// that FAT forbids are replaced with underscores, trailing dots and spaces
+// are removed, and names longer than MaxNameLength are cut before their
+// extension.
+func (r *Root) sanitizeName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if d, ok := decompositions[r]; ok {
+			b.WriteByte(d[0])
+		} else if s, ok := asciiSpellings[r]; ok {
+			b.WriteString(s)
+		} else if unicode.Is(unicode.Mn, r) {
+			// Combining marks of decomposed names
+		} else if r < 0x20 || r >= utf8.RuneSelf || strings.ContainsRune(`"*/:<>?\|`, r) {
+			b.WriteByte('_')
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	sanitized := strings.TrimRight(b.String(), ". ")
+	if limit := r.opts.MaxNameLength; len(sanitized) > limit {
+		ext := filepath.Ext(sanitized)
+		if len(ext) >= limit {
+			ext = ""
+		}
+		sanitized = strings.TrimRight(sanitized[:limit-len(ext)], ". ") + ext
+	}
+	if sanitized == "" {
+		sanitized = "_"
+	}
+	return sanitized
+}
+
+// Precomposed letters by their decomposition, the inverse of decompositions
+var compositions = func() map[string]rune {
+	m := make(map[string]rune, len(decompositions))
+	for r, d := range decompositions {
+		m[d] = r
+	}
+	return m
+}()
+
+// normalizeName gives name in the Unicode normalization form of virtual
+// names, nfc or nfd, or as it is without one. Names looked up are
+// normalized the same way, so that they are found whatever form clients
+// send them in, e.g. decomposed by macOS.
+func (r *Root) normalizeName(name string) string {
+	switch r.opts.NormalizeNames {
+	case "nfc":
+		return nfc(name)
+	case "nfd":
+		return nfd(name)
+	}
+	return name
+}
+
+func validNormalizeForm(form string) error {
+	switch form {
+	case "", "nfc", "nfd":
+		return nil
+	}
+	return fmt.Errorf("unknown normalization form %q, must be nfc or nfd", form)
+}
+
+// nfd decomposes the precomposed Latin letters of s
+func nfd(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if d, ok := decompositions[r]; ok {
+			b.WriteString(d)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// nfc composes the Latin letters of s followed by combining marks, as much
+// as there are precomposed letters for them
+func nfc(s string) string {
+	runes := []rune(nfd(s))
+	var b strings.Builder
+	for i := 0; i < len(runes); {
+		// The letter and the marks that follow it
+		end := i + 1
+		for end < len(runes) && unicode.Is(unicode.Mn, runes[end]) {
+			end++
+		}
+		composed := false
+		for k := end; k > i+1; k-- {
+			if r, ok := compositions[string(runes[i:k])]; ok {
+				b.WriteRune(r)
+				b.WriteString(string(runes[k:end]))
+				composed = true
+				break
+			}
+		}
+		if !composed {
+			b.WriteString(string(runes[i:end]))
+		}
+		i = end
+	}
+	return b.String()
+}
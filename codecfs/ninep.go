@@ -0,0 +1,623 @@
+package codecfs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+)
+
+// The 9P server exposes the virtual tree read-only over 9P2000, for clients
+// that can't use FUSE. On Linux it mounts with
+//
+//	mount -t 9p -o trans=tcp,port=5640,version=9p2000 host /mnt/music
+
+// 9P2000 message types
+const (
+	ninepTversion = 100 + iota
+	ninepRversion
+	ninepTauth
+	ninepRauth
+	ninepTattach
+	ninepRattach
+	ninepTerror // Not a valid message
+	ninepRerror
+	ninepTflush
+	ninepRflush
+	ninepTwalk
+	ninepRwalk
+	ninepTopen
+	ninepRopen
+	ninepTcreate
+	ninepRcreate
+	ninepTread
+	ninepRread
+	ninepTwrite
+	ninepRwrite
+	ninepTclunk
+	ninepRclunk
+	ninepTremove
+	ninepRremove
+	ninepTstat
+	ninepRstat
+	ninepTwstat
+	ninepRwstat
+)
+
+const (
+	ninepVersion = "9P2000"
+	// Largest message we accept, headers included
+	ninepMaxMsize = 1 << 20
+	// Size of the header of Rread
+	ninepIOHeader = 4 + 1 + 2 + 4
+	ninepQTDir    = 0x80
+	ninepDMDir    = 0x80000000
+)
+
+// Listen9P serves the tree over 9P on the TCP address addr. It only returns
+// when addr can't be listened on.
+func (r *Root) Listen9P(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	slog.Info("Serving 9P", "addr", l.Addr().String())
+	var delay time.Duration
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			delay = acceptBackoff(delay)
+			slog.Warn("9P listener error", "err", err, "retry", delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		c := &ninepConn{
+			conn:    conn,
+			root:    r,
+			msize:   ninepMaxMsize,
+			fids:    make(map[uint32]*ninepFid),
+			pending: make(map[uint16]*ninepRequest),
+		}
+		go c.serve()
+	}
+}
+
+// A ninepFid is what a client fid points to
+type ninepFid struct {
+	// Slash-separated path from the root
+	path string
+	node fs.Node
+	qid  []byte
+
+	mu sync.Mutex
+	// Set once opened
+	handle fs.Handle
+	// Serialized stats of the entries, for opened directories
+	dir []byte
+}
+
+type ninepConn struct {
+	conn  net.Conn
+	root  fs.Node
+	msize uint32
+
+	writeMu sync.Mutex
+	// Requests being served
+	running sync.WaitGroup
+
+	mu      sync.Mutex
+	fids    map[uint32]*ninepFid
+	pending map[uint16]*ninepRequest
+}
+
+// A request being served
+type ninepRequest struct {
+	cancel context.CancelFunc
+	// Closed once the response is sent
+	done chan struct{}
+}
+
+func (c *ninepConn) serve() {
+	defer c.close()
+	r := bufio.NewReader(c.conn)
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return
+		}
+		n := binary.LittleEndian.Uint32(size[:])
+		if n < 7 || n > ninepMaxMsize {
+			slog.Warn("Invalid 9P message", "size", n, "remote", c.conn.RemoteAddr().String())
+			return
+		}
+		msg := make([]byte, n-4)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			return
+		}
+		typ := msg[0]
+		tag := binary.LittleEndian.Uint16(msg[1:])
+		body := &ninepBuf{b: msg[3:]}
+
+		// Version negotiation resets the session, everything else can
+		// run concurrently, reads blocking on encoders included
+		if typ == ninepTversion {
+			c.reset()
+			c.reply(tag, ninepRversion, c.version(body))
+			continue
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		req := &ninepRequest{cancel: cancel, done: make(chan struct{})}
+		c.mu.Lock()
+		c.pending[tag] = req
+		c.mu.Unlock()
+		c.running.Add(1)
+		go func() {
+			defer c.running.Done()
+			rtyp, out := c.handle(ctx, typ, body)
+			c.mu.Lock()
+			delete(c.pending, tag)
+			c.mu.Unlock()
+			cancel()
+			c.reply(tag, rtyp, out)
+			close(req.done)
+		}()
+	}
+}
+
+func (c *ninepConn) close() {
+	c.conn.Close()
+	c.reset()
+}
+
+// reset aborts the running requests and releases everything the client left
+// open
+func (c *ninepConn) reset() {
+	c.mu.Lock()
+	for _, req := range c.pending {
+		req.cancel()
+	}
+	c.mu.Unlock()
+	c.running.Wait()
+
+	c.mu.Lock()
+	fids := c.fids
+	c.fids = make(map[uint32]*ninepFid)
+	c.mu.Unlock()
+	for _, f := range fids {
+		if f.handle != nil && f.handle != fs.Handle(f.node) {
+			releaseHandle(context.Background(), f.handle)
+		}
+	}
+}
+
+func (c *ninepConn) reply(tag uint16, typ byte, body []byte) {
+	msg := make([]byte, 7, 7+len(body))
+	binary.LittleEndian.PutUint32(msg, uint32(7+len(body)))
+	msg[4] = typ
+	binary.LittleEndian.PutUint16(msg[5:], tag)
+	msg = append(msg, body...)
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.Write(msg)
+}
+
+func (c *ninepConn) version(body *ninepBuf) []byte {
+	msize := body.u32()
+	version := body.str()
+	if msize > ninepMaxMsize {
+		msize = ninepMaxMsize
+	} else if msize < 512 {
+		msize = 512
+	}
+	c.mu.Lock()
+	c.msize = msize
+	c.mu.Unlock()
+
+	out := &ninepBuf{}
+	out.putU32(msize)
+	// Dialects like 9P2000.L and 9P2000.u are answered with plain 9P2000,
+	// which clients then speak
+	if !strings.HasPrefix(version, ninepVersion) {
+		out.putStr("unknown")
+	} else {
+		out.putStr(ninepVersion)
+	}
+	return out.b
+}
+
+// handle serves one request and gives the type and body of the response
+func (c *ninepConn) handle(ctx context.Context, typ byte, body *ninepBuf) (byte, []byte) {
+	var out []byte
+	var err error
+	switch typ {
+	case ninepTauth:
+		err = errors.New("authentication not required")
+	case ninepTattach:
+		out, err = c.attach(ctx, body)
+	case ninepTflush:
+		c.flush(body.u16())
+	case ninepTwalk:
+		out, err = c.walk(ctx, body)
+	case ninepTopen:
+		out, err = c.open(ctx, body)
+	case ninepTread:
+		out, err = c.read(ctx, body)
+	case ninepTclunk:
+		err = c.clunk(ctx, body.u32())
+	case ninepTstat:
+		out, err = c.stat(ctx, body)
+	case ninepTcreate, ninepTwrite, ninepTremove, ninepTwstat:
+		err = fuse.Errno(syscall.EROFS)
+	default:
+		err = fuse.ENOSYS
+	}
+	if err == nil && body.err != nil {
+		err = body.err
+	}
+	if err != nil {
+		e := &ninepBuf{}
+		e.putStr(ninepError(err))
+		return ninepRerror, e.b
+	}
+	return typ + 1, out
+}
+
+// ninepError gives the message for err. System errors are spelled the way
+// the Linux client maps them back to errno.
+func ninepError(err error) string {
+	var errno syscall.Errno
+	var ferrno fuse.Errno
+	switch {
+	case errors.As(err, &ferrno):
+		errno = syscall.Errno(ferrno)
+	case errors.As(err, &errno):
+	case os.IsNotExist(err):
+		errno = syscall.ENOENT
+	case os.IsPermission(err):
+		errno = syscall.EACCES
+	default:
+		return err.Error()
+	}
+	msg := errno.Error()
+	return strings.ToUpper(msg[:1]) + msg[1:]
+}
+
+func (c *ninepConn) fid(id uint32) (*ninepFid, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, ok := c.fids[id]
+	if !ok {
+		return nil, errors.New("unknown fid")
+	}
+	return f, nil
+}
+
+func (c *ninepConn) newFid(id uint32, f *ninepFid) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.fids[id]; ok {
+		return errors.New("fid already in use")
+	}
+	c.fids[id] = f
+	return nil
+}
+
+// flush cancels the request with the given tag if it is still running, and
+// waits for its response to be sent, which must come before Rflush
+func (c *ninepConn) flush(tag uint16) {
+	c.mu.Lock()
+	req, ok := c.pending[tag]
+	c.mu.Unlock()
+	if ok {
+		req.cancel()
+		<-req.done
+	}
+}
+
+// node builds the fid for the node at p
+func (c *ninepConn) node(ctx context.Context, p string) (*ninepFid, error) {
+	n, err := lookupPath(ctx, c.root, p)
+	if err != nil {
+		return nil, err
+	}
+	a, err := nodeAttr(ctx, n)
+	if err != nil {
+		return nil, err
+	}
+	return &ninepFid{path: p, node: n, qid: ninepQid(a)}, nil
+}
+
+func (c *ninepConn) attach(ctx context.Context, body *ninepBuf) ([]byte, error) {
+	id := body.u32()
+	body.u32() // afid
+	body.str() // uname
+	body.str() // aname
+	f, err := c.node(ctx, "/")
+	if err != nil {
+		return nil, err
+	}
+	if err := c.newFid(id, f); err != nil {
+		return nil, err
+	}
+	return f.qid, nil
+}
+
+func (c *ninepConn) walk(ctx context.Context, body *ninepBuf) ([]byte, error) {
+	id := body.u32()
+	f, err := c.fid(id)
+	if err != nil {
+		return nil, err
+	}
+	newID := body.u32()
+	names := make([]string, body.u16())
+	for i := range names {
+		names[i] = body.str()
+	}
+	f.mu.Lock()
+	open := f.handle != nil
+	f.mu.Unlock()
+	if open {
+		// Walking in place would leak the handle, and 9P forbids
+		// walking from open fids anyway
+		return nil, errors.New("can't walk from an open fid")
+	}
+
+	out := &ninepBuf{}
+	out.putU16(0)
+	p := f.path
+	last := f
+	for i, name := range names {
+		if name == "/" || strings.Contains(name, "/") {
+			err = fuse.ENOENT
+		} else {
+			next := path.Join(p, name)
+			if next == "." || next == ".." {
+				// The parent of the root is the root
+				next = ""
+			}
+			last, err = c.node(ctx, next)
+		}
+		if err != nil {
+			if i == 0 {
+				return nil, err
+			}
+			// Partial walks give the qids up to the failure, and
+			// don't create the fid
+			binary.LittleEndian.PutUint16(out.b, uint16(i))
+			return out.b, nil
+		}
+		p = last.path
+		out.b = append(out.b, last.qid...)
+	}
+	binary.LittleEndian.PutUint16(out.b, uint16(len(names)))
+	walked := &ninepFid{path: last.path, node: last.node, qid: last.qid}
+	if newID == id {
+		// Walking in place moves the fid itself
+		c.mu.Lock()
+		c.fids[id] = walked
+		c.mu.Unlock()
+		return out.b, nil
+	}
+	if err := c.newFid(newID, walked); err != nil {
+		return nil, err
+	}
+	return out.b, nil
+}
+
+func (c *ninepConn) open(ctx context.Context, body *ninepBuf) ([]byte, error) {
+	f, err := c.fid(body.u32())
+	if err != nil {
+		return nil, err
+	}
+	// Only reading is allowed, with or without removing on close, which
+	// the tree doesn't do since it is read-only
+	if mode := body.u8(); mode&^0x20 != 0 {
+		return nil, fuse.Errno(syscall.EROFS)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.handle != nil {
+		return nil, errors.New("fid already open")
+	}
+	if f.qid[0]&ninepQTDir != 0 {
+		if err := c.list(ctx, f); err != nil {
+			return nil, err
+		}
+		f.handle = f.node
+	} else {
+		f.handle, err = openNode(ctx, f.node, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	out := &ninepBuf{b: append([]byte(nil), f.qid...)}
+	out.putU32(c.msize - ninepIOHeader)
+	return out.b, nil
+}
+
+// list fills the listing of the directory f
+func (c *ninepConn) list(ctx context.Context, f *ninepFid) error {
+	ents, err := readDirAll(ctx, f.node)
+	if err != nil {
+		return err
+	}
+	for _, ent := range ents {
+		n, err := lookupPath(ctx, f.node, ent.Name)
+		if err != nil {
+			continue
+		}
+		a, err := nodeAttr(ctx, n)
+		if err != nil {
+			continue
+		}
+		f.dir = append(f.dir, ninepStat(ent.Name, a)...)
+	}
+	return nil
+}
+
+func (c *ninepConn) read(ctx context.Context, body *ninepBuf) ([]byte, error) {
+	f, err := c.fid(body.u32())
+	if err != nil {
+		return nil, err
+	}
+	offset := int64(body.u64())
+	count := body.u32()
+	if max := c.msize - ninepIOHeader; count > max {
+		count = max
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.handle == nil {
+		return nil, errors.New("fid not open")
+	}
+
+	out := &ninepBuf{}
+	out.putU32(0)
+	if f.qid[0]&ninepQTDir != 0 {
+		// Only whole entries can be returned
+		end := offset
+		for end+2 <= int64(len(f.dir)) {
+			next := end + 2 + int64(binary.LittleEndian.Uint16(f.dir[end:]))
+			if next-offset > int64(count) {
+				break
+			}
+			end = next
+		}
+		if offset < end {
+			out.b = append(out.b, f.dir[offset:end]...)
+		}
+	} else {
+		data := make([]byte, count)
+		n, err := readHandle(ctx, f.handle, data, offset)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		out.b = append(out.b, data[:n]...)
+	}
+	binary.LittleEndian.PutUint32(out.b, uint32(len(out.b)-4))
+	return out.b, nil
+}
+
+func (c *ninepConn) clunk(ctx context.Context, id uint32) error {
+	c.mu.Lock()
+	f, ok := c.fids[id]
+	delete(c.fids, id)
+	c.mu.Unlock()
+	if !ok {
+		return errors.New("unknown fid")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.handle != nil && f.handle != fs.Handle(f.node) {
+		releaseHandle(ctx, f.handle)
+	}
+	return nil
+}
+
+func (c *ninepConn) stat(ctx context.Context, body *ninepBuf) ([]byte, error) {
+	f, err := c.fid(body.u32())
+	if err != nil {
+		return nil, err
+	}
+	a, err := nodeAttr(ctx, f.node)
+	if err != nil {
+		return nil, err
+	}
+	name := path.Base(f.path)
+	stat := ninepStat(name, a)
+	out := &ninepBuf{}
+	out.putU16(uint16(len(stat)))
+	out.b = append(out.b, stat...)
+	return out.b, nil
+}
+
+func ninepQid(a fuse.Attr) []byte {
+	q := &ninepBuf{}
+	if a.Mode.IsDir() {
+		q.putU8(ninepQTDir)
+	} else {
+		q.putU8(0)
+	}
+	q.putU32(uint32(a.Mtime.Unix()))
+	q.putU64(a.Inode)
+	return q.b
+}
+
+// ninepStat serializes the stat of an entry, size prefix included
+func ninepStat(name string, a fuse.Attr) []byte {
+	mode := uint32(a.Mode.Perm())
+	if a.Mode.IsDir() {
+		mode |= ninepDMDir
+	}
+	s := &ninepBuf{}
+	s.putU16(0)
+	s.putU16(0) // type
+	s.putU32(0) // dev
+	s.b = append(s.b, ninepQid(a)...)
+	s.putU32(mode)
+	s.putU32(uint32(a.Atime.Unix()))
+	s.putU32(uint32(a.Mtime.Unix()))
+	if a.Mode.IsDir() {
+		s.putU64(0)
+	} else {
+		s.putU64(a.Size)
+	}
+	s.putStr(name)
+	s.putStr("codecfs") // uid
+	s.putStr("codecfs") // gid
+	s.putStr("")        // muid
+	binary.LittleEndian.PutUint16(s.b, uint16(len(s.b)-2))
+	return s.b
+}
+
+// ninepBuf encodes and decodes the fields of 9P messages. Decoding past the
+// end sets err and gives zero values.
+type ninepBuf struct {
+	b   []byte
+	err error
+}
+
+func (b *ninepBuf) next(n int) []byte {
+	if len(b.b) < n {
+		b.err = errors.New("short message")
+		b.b = nil
+		return make([]byte, n)
+	}
+	out := b.b[:n]
+	b.b = b.b[n:]
+	return out
+}
+
+func (b *ninepBuf) u8() uint8   { return b.next(1)[0] }
+func (b *ninepBuf) u16() uint16 { return binary.LittleEndian.Uint16(b.next(2)) }
+func (b *ninepBuf) u32() uint32 { return binary.LittleEndian.Uint32(b.next(4)) }
+func (b *ninepBuf) u64() uint64 { return binary.LittleEndian.Uint64(b.next(8)) }
+func (b *ninepBuf) str() string { return string(b.next(int(b.u16()))) }
+
+func (b *ninepBuf) putU8(v uint8)   { b.b = append(b.b, v) }
+func (b *ninepBuf) putU16(v uint16) { b.b = binary.LittleEndian.AppendUint16(b.b, v) }
+func (b *ninepBuf) putU32(v uint32) { b.b = binary.LittleEndian.AppendUint32(b.b, v) }
+func (b *ninepBuf) putU64(v uint64) { b.b = binary.LittleEndian.AppendUint64(b.b, v) }
+
+func (b *ninepBuf) putStr(s string) {
+	b.putU16(uint16(len(s)))
+	b.b = append(b.b, s...)
+}
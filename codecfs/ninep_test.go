@@ -0,0 +1,57 @@
+package codecfs
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestNinepWalkInPlace(t *testing.T) {
+	var names []string
+	c := &ninepConn{root: lookupRecorder{names: &names}, fids: map[uint32]*ninepFid{}}
+	ctx := context.Background()
+	attach := &ninepBuf{}
+	attach.putU32(1)
+	attach.putU32(^uint32(0))
+	attach.putStr("user")
+	attach.putStr("")
+	if _, err := c.attach(ctx, attach); err != nil {
+		t.Fatal(err)
+	}
+
+	walk := &ninepBuf{}
+	walk.putU32(1)
+	walk.putU32(1)
+	walk.putU16(2)
+	walk.putStr("ogg")
+	walk.putStr("album")
+	if _, err := c.walk(ctx, walk); err != nil {
+		t.Fatal(err)
+	}
+	f, err := c.fid(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.path != "/ogg/album" {
+		t.Errorf("fid walked to %q", f.path)
+	}
+}
+
+func TestNinepWalkFromOpenFid(t *testing.T) {
+	var names []string
+	c := &ninepConn{root: lookupRecorder{names: &names}, fids: map[uint32]*ninepFid{}}
+	ctx := context.Background()
+	c.fids[1] = &ninepFid{path: "/ogg", node: c.root, handle: c.root}
+
+	walk := &ninepBuf{}
+	walk.putU32(1)
+	walk.putU32(1)
+	walk.putU16(1)
+	walk.putStr("album")
+	if _, err := c.walk(ctx, walk); err == nil {
+		t.Error("walked from an open fid")
+	}
+	if f, _ := c.fid(1); f.path != "/ogg" {
+		t.Errorf("open fid moved to %q", f.path)
+	}
+}
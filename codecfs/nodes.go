@@ -0,0 +1,107 @@
+package codecfs
+
+import (
+	"path/filepath"
+	"sync"
+
+	"bazil.org/fuse/fs"
+)
+
+// Identifies a node of the virtual tree: the root and the profile of the
+// tree it is in, and the path it would have in the source
+type nodeKey struct {
+	root    *Root
+	profile string
+	path    string
+}
+
+// key gives the key of the node at path in the tree of p
+func (p *profile) key(path string) nodeKey {
+	return nodeKey{p.root, p.name, path}
+}
+
+// Nodes handed out to the kernel. Always returning the same node for the
+// same path is what makes it possible to invalidate them later on.
+var nodes sync.Map
+
+// The keys of nodes by path, so that changes to the source find the nodes
+// of a path in every root and tree without going through all of them. It
+// may hold keys of nodes already forgotten.
+var (
+	nodePathsMu sync.Mutex
+	nodePaths   = map[string]map[nodeKey]bool{}
+)
+
+// node returns the node already known for key, or registers the one built by
+// newNode
+func node(key nodeKey, newNode func() fs.Node) fs.Node {
+	if n, ok := nodes.Load(key); ok {
+		return n.(fs.Node)
+	}
+	n, loaded := nodes.LoadOrStore(key, newNode())
+	if !loaded {
+		nodePathsMu.Lock()
+		if nodePaths[key.path] == nil {
+			nodePaths[key.path] = map[nodeKey]bool{}
+		}
+		nodePaths[key.path][key] = true
+		nodePathsMu.Unlock()
+	}
+	return n.(fs.Node)
+}
+
+// nodesAt gives the keys of the nodes known at path
+func nodesAt(path string) []nodeKey {
+	nodePathsMu.Lock()
+	defer nodePathsMu.Unlock()
+	var keys []nodeKey
+	for key := range nodePaths[path] {
+		if _, ok := nodes.Load(key); ok {
+			keys = append(keys, key)
+		} else {
+			delete(nodePaths[path], key)
+		}
+	}
+	if len(nodePaths[path]) == 0 {
+		delete(nodePaths, path)
+	}
+	return keys
+}
+
+// forgetNode drops the node at key, if it is still n
+func forgetNode(key nodeKey, n fs.Node) {
+	if !nodes.CompareAndDelete(key, n) {
+		return
+	}
+	nodePathsMu.Lock()
+	delete(nodePaths[key.path], key)
+	if len(nodePaths[key.path]) == 0 {
+		delete(nodePaths, key.path)
+	}
+	nodePathsMu.Unlock()
+}
+
+var _ fs.NodeForgetter = &dir{}
+var _ fs.NodeForgetter = &file{}
+
+func (d *dir) Forget() {
+	forgetNode(d.profile.key(d.dir), d)
+	// Its listing goes along, since sourceChanged finds listings through
+	// nodes
+	dirCache.Delete(d.profile.key(d.dir))
+}
+
+func (f *file) Forget() {
+	forgetNode(f.profile.key(f.name), f)
+}
+
+var _ fs.NodeForgetter = &chapterDir{}
+var _ fs.NodeForgetter = &playlistFile{}
+
+func (d *chapterDir) Forget() {
+	forgetNode(d.profile.key(d.source), d)
+}
+
+func (f *playlistFile) Forget() {
+	forgetNode(f.dir.profile.key(filepath.Join(f.dir.dir, playlistName)), f)
+}
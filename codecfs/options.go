@@ -0,0 +1,478 @@
+package codecfs
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Options configures the filesystem. The source and the options of the
+// trees belong to each Root, while encoders, caches and limits are shared by
+// every Root of a process: the first call to New sets them, and the next
+// ones fail if they don't agree.
+type Options struct {
+	// Settings of the main tree, an ogg one unless Profile is set
+	Quality           string
+	Bitrate           string
+	RateControl       string
+	OpusApplication   string
+	OpusFrameDuration string
+	OpusComplexity    string
+	AACEncoder        string
+	SampleRate        int
+	Channels          int
+	Exclude           []string
+	Include           []string
+	MinBitrate        int64
+	MinSize           int64
+	MediaOnly         bool
+	// Named profile of the main tree, as in opus-96
+	Profile string
+
+	// Other trees
+	Original    bool
+	FLAC        bool
+	FLACLevel   int
+	WAV         bool
+	WAVBits     int
+	JPEG        bool
+	JPEGQuality int
+	Thumbs      bool
+	ThumbSize   int
+	// Video tree encoding videos to WebM at VideoBitrate, in two passes
+	// with VideoTwoPass
+	Video        bool
+	VideoBitrate string
+	VideoTwoPass bool
+	Rules        string
+	Limits       string
+
+	// Icon of the volume shown by Finder, an .icns file
+	VolumeIcon string
+
+	Union    []string
+	Archives bool
+	Watch    bool
+	// Most source directories watched at once, 0 for no limit
+	MaxWatches int
+
+	SizeCache    string
+	SizeStrategy string
+	SizeScan     bool
+
+	MemoryBudget   int64
+	Nice           int
+	IONice         string
+	Cgroup         string
+	EncoderCPU     float64
+	EncoderMemory  int64
+	Sandbox        bool
+	SandboxCommand string
+
+	Webhook      string
+	EventCommand string
+
+	SanitizeNames  bool
+	MaxNameLength  int
+	NormalizeNames string
+	IgnoreCase     bool
+	Sort           string
+	Chapters       bool
+	Playlists      bool
+
+	Ingest           string
+	ForceReadOnly    bool
+	DirCacheTTL      time.Duration
+	TraceFUSE        bool
+	TranscodeRetries int
+	MaxFailures      int
+	TranscodeTimeout time.Duration
+	SeekDistance     time.Duration
+	ReadWindow       int64
+	ReadAhead        int64
+	Prefetch         bool
+	DirectIO         bool
+	ReadRateFactor   float64
+	MaxReadRate      int64
+
+	// Shown in .status, usually the command-line flags
+	Settings map[string]string
+}
+
+// DefaultOptions gives the options of a bare codecfs mount
+func DefaultOptions() Options {
+	return Options{
+		FLACLevel:        5,
+		WAVBits:          16,
+		JPEGQuality:      90,
+		ThumbSize:        256,
+		VideoBitrate:     "1M",
+		Watch:            true,
+		MaxWatches:       4096,
+		SizeCache:        defaultSizeCachePath(),
+		SizeStrategy:     "inflate",
+		MaxNameLength:    255,
+		Sort:             "bytes",
+		TranscodeRetries: 2,
+		MaxFailures:      5,
+		SeekDistance:     30 * time.Second,
+		ReadAhead:        1 << 20,
+	}
+}
+
+// AddProfileFlags binds the flags of the main tree to o, with the values of
+// o as defaults
+func (o *Options) AddProfileFlags(flags *flag.FlagSet) {
+	flags.StringVar(&o.Quality, "quality", o.Quality, "Encoder quality, as given to ffmpeg's -q:a")
+	flags.StringVar(&o.Bitrate, "bitrate", o.Bitrate, "Target bitrate, as given to ffmpeg's -b:a (e.g. 128k)")
+	flags.StringVar(&o.OpusApplication, "opus-application", o.OpusApplication, "What opus encodes are tuned for: audio, voip or lowdelay (empty for the encoder default)")
+	flags.StringVar(&o.OpusFrameDuration, "opus-frame-duration", o.OpusFrameDuration, "Duration of opus frames in ms, from 2.5 to 120 (empty for the encoder default)")
+	flags.StringVar(&o.OpusComplexity, "opus-complexity", o.OpusComplexity, "Complexity of opus encodes, from 0 to 10 (empty for the encoder default)")
+	flags.StringVar(&o.AACEncoder, "aac-encoder", o.AACEncoder, "AAC encoder of aac trees: aac or libfdk_aac (empty picks libfdk_aac when ffmpeg has it)")
+	flags.StringVar(&o.RateControl, "rate-control", o.RateControl, "Rate control mode: vbr, cvbr, abr or cbr, all but vbr needing -bitrate (empty for the encoder default)")
+	flags.IntVar(&o.SampleRate, "sample-rate", o.SampleRate, "Output sample rate in Hz (0 keeps the source rate)")
+	flags.IntVar(&o.Channels, "channels", o.Channels, "Downmix output to 1 (mono) or 2 (stereo) channels (0 keeps the source layout)")
+	flags.Var((*globList)(&o.Exclude), "exclude", "Hide source entries matching this glob (repeatable)")
+	flags.Var((*globList)(&o.Include), "include", "Only show source files matching this glob (repeatable)")
+	flags.Int64Var(&o.MinBitrate, "min-bitrate", o.MinBitrate, "Show sources with a bitrate up to that many kbit/s as they are instead of transcoding them (0 transcodes them all)")
+	flags.Int64Var(&o.MinSize, "min-size", o.MinSize, "Show files smaller than that many bytes as they are, e.g. short sound effects (0 transcodes them all)")
+	flags.BoolVar(&o.MediaOnly, "media-only", o.MediaOnly, "Hide files that are not audio or video")
+}
+
+// AddFlags binds the flags shared by mounts and network servers to o, with
+// the values of o as defaults
+func (o *Options) AddFlags(flags *flag.FlagSet) {
+	o.AddProfileFlags(flags)
+	flags.BoolVar(&o.Original, "original", o.Original, "Also expose the untouched source tree under /original")
+	flags.BoolVar(&o.FLAC, "flac", o.FLAC, "Also expose the tree with lossless sources converted to FLAC under /flac")
+	flags.BoolVar(&o.WAV, "wav", o.WAV, "Also expose the tree decoded to PCM WAV under /wav, for audio editors")
+	flags.IntVar(&o.WAVBits, "wav-bits", o.WAVBits, "Bits per sample of WAV files: 16, 24 or 32")
+	flags.BoolVar(&o.JPEG, "jpeg", o.JPEG, "Also expose the tree with HEIC, WebP and camera raw photos converted to JPEG under /jpeg (needs ImageMagick)")
+	flags.StringVar(&o.Rules, "rules", o.Rules, "Also expose a tree for each converter rule defined in this TOML file")
+	flags.StringVar(&o.Limits, "limits", o.Limits, "Override the memory budget, transcode timeout and read rates with those of this TOML file, read again on reload")
+	flags.BoolVar(&o.Thumbs, "thumbs", o.Thumbs, "Also expose JPEG previews of pictures and videos under /thumbs, named after their source as in clip.mp4.jpg")
+	flags.IntVar(&o.ThumbSize, "thumb-size", o.ThumbSize, "Largest side of previews in pixels")
+	flags.IntVar(&o.JPEGQuality, "jpeg-quality", o.JPEGQuality, "Quality of JPEG files, from 1 to 100")
+	flags.BoolVar(&o.Video, "video", o.Video, "Also expose the tree with videos encoded to VP9 and Opus in WebM under /video")
+	flags.StringVar(&o.VideoBitrate, "video-bitrate", o.VideoBitrate, "Bitrate of the picture of videos, as in 800k or 2M")
+	flags.BoolVar(&o.VideoTwoPass, "video-two-pass", o.VideoTwoPass, "Analyze videos in a first pass before encoding them, keeping the statistics next to the size cache; the first read of a video waits for the whole pass")
+	flags.IntVar(&o.FLACLevel, "flac-level", o.FLACLevel, "Compression level of FLAC encodes, from 0 (fastest) to 12 (smallest)")
+	flags.Var((*sourceList)(&o.Union), "union", "Merge this directory or URL into the input, which wins on conflicts (repeatable)")
+	flags.StringVar(&o.SizeCache, "size-cache", o.SizeCache, "Keep the exact sizes of transcoded files in this database across restarts (empty keeps them in memory only)")
+	flags.StringVar(&o.SizeStrategy, "size-strategy", o.SizeStrategy, "How to report the size of files until it is known: inflate, source, estimate, huge or exact")
+	flags.BoolVar(&o.SizeScan, "size-scan", o.SizeScan, "Transcode files in the background with a low priority to learn their exact sizes (needs -size-cache)")
+	flags.Int64Var(&o.MemoryBudget, "memory-budget", o.MemoryBudget, "How many bytes of transcoded output open files can hold in memory altogether before spilling to temporary files and holding back new encoders (0 for no limit)")
+	flags.IntVar(&o.Nice, "nice", o.Nice, "Niceness of encoders, from -20 to 19 (0 leaves it as ours)")
+	flags.StringVar(&o.IONice, "ionice", o.IONice, "I/O priority of encoders on Linux: idle, or best-effort with a level from 0 to 7 as in best-effort:7 (empty leaves it as ours)")
+	flags.StringVar(&o.Cgroup, "cgroup", o.Cgroup, "Run each encoder in a cgroup of its own under this delegated cgroup v2 directory, to cap it with -encoder-cpu and -encoder-memory (Linux only)")
+	flags.Float64Var(&o.EncoderCPU, "encoder-cpu", o.EncoderCPU, "How many CPUs each encoder can use, under -cgroup (0 for no limit)")
+	flags.Int64Var(&o.EncoderMemory, "encoder-memory", o.EncoderMemory, "How many bytes of memory each encoder can use, under -cgroup (0 for no limit)")
+	flags.BoolVar(&o.Sandbox, "sandbox", o.Sandbox, "Run encoders without network nor environment, in namespaces of their own (Linux only)")
+	flags.StringVar(&o.SandboxCommand, "sandbox-command", o.SandboxCommand, "Run encoders under this command, given the encoder command after its own arguments, as in \"bwrap --ro-bind / / --dev /dev --unshare-net --\"")
+	flags.BoolVar(&o.Archives, "archives", o.Archives, "Browse zip and tar archives of the source as directories")
+	flags.BoolVar(&o.SanitizeNames, "sanitize-names", o.SanitizeNames, "Make names ASCII and safe for FAT filesystems, so that trees can be copied to SD cards and car stereos")
+	flags.StringVar(&o.NormalizeNames, "normalize-names", o.NormalizeNames, "Give names in this Unicode normalization form, nfc or nfd, and find them whatever the form they are looked up in")
+	flags.BoolVar(&o.Chapters, "chapters", o.Chapters, "Show m4b and m4a audiobooks with chapters as directories with a file per chapter")
+	flags.BoolVar(&o.Playlists, "playlists", o.Playlists, "Add an "+playlistName+" listing the tracks of each directory by disc and track number")
+	flags.StringVar(&o.Sort, "sort", o.Sort, "Order of listings: bytes, natural (track 2 before track 10) or folded (natural, ignoring case and accents)")
+	flags.BoolVar(&o.IgnoreCase, "ignore-case", o.IgnoreCase, "Find names whatever their case, for Windows and SMB clients")
+	flags.IntVar(&o.MaxNameLength, "max-name-length", o.MaxNameLength, "Cut longer names to that many bytes, under -sanitize-names")
+	flags.Float64Var(&o.ReadRateFactor, "read-rate-factor", o.ReadRateFactor, "Let each reader of a transcoded file read it no faster than that many times its bitrate, as in 2, once a second ahead (0 for no limit)")
+	flags.Int64Var(&o.MaxReadRate, "max-read-rate", o.MaxReadRate, "Let each reader of a transcoded file read it no faster than that many bytes per second, once a second ahead (0 for no limit)")
+	flags.StringVar(&o.Webhook, "webhook", o.Webhook, "Post events (transcodes started, finished or failed, sizes evicted from the cache) as JSON to this URL")
+	flags.StringVar(&o.EventCommand, "event-command", o.EventCommand, "Run this command for each event, given as JSON on its stdin and in CODECFS_EVENT, CODECFS_SOURCE and CODECFS_PROFILE")
+}
+
+// validOutput checks the output settings shared by options and directory
+// configs
+func validOutput(sampleRate, channels int, minBitrate int64) error {
+	if sampleRate < 0 {
+		return errors.New("invalid sample rate")
+	}
+	if channels < 0 || channels > 2 {
+		return errors.New("channels must be 1 (mono) or 2 (stereo)")
+	}
+	if minBitrate < 0 {
+		return errors.New("invalid minimum bitrate")
+	}
+	return nil
+}
+
+// profile builds the profile of the main tree
+func (o *Options) profile() (*profile, error) {
+	if err := validOutput(o.SampleRate, o.Channels, o.MinBitrate); err != nil {
+		return nil, err
+	}
+	if o.MinSize < 0 {
+		return nil, errors.New("invalid minimum size")
+	}
+	if err := validRateControl(o.RateControl, o.Bitrate); err != nil {
+		return nil, err
+	}
+	opus := opusSettings{
+		application:   o.OpusApplication,
+		frameDuration: o.OpusFrameDuration,
+		complexity:    o.OpusComplexity,
+	}
+	if err := opus.validate(); err != nil {
+		return nil, err
+	}
+	if err := validAACEncoder(o.AACEncoder); err != nil {
+		return nil, err
+	}
+	p := &profile{
+		name:        "ogg",
+		format:      "ogg",
+		ext:         ".ogg",
+		quality:     o.Quality,
+		bitrate:     o.Bitrate,
+		rateControl: o.RateControl,
+		opus:        opus,
+		minBitrate:  o.MinBitrate,
+		minSize:     o.MinSize,
+		aac:         o.AACEncoder,
+		sampleRate:  o.SampleRate,
+		channels:    o.Channels,
+		exclude:     o.Exclude,
+		include:     o.Include,
+		mediaOnly:   o.MediaOnly,
+	}
+	if o.Profile != "" {
+		named, ok := namedProfile(p, o.Profile)
+		if !ok {
+			return nil, fmt.Errorf("unknown profile %q", o.Profile)
+		}
+		p = named
+	}
+	return p, nil
+}
+
+// Validate checks o without applying it, which New does
+func (o *Options) Validate() error {
+	if _, err := o.profile(); err != nil {
+		return err
+	}
+	switch {
+	case o.SizeScan && o.SizeCache == "":
+		return errors.New("size scans need a size cache")
+	case o.Nice < -20 || o.Nice > 19:
+		return errors.New("niceness must be between -20 and 19")
+	case o.EncoderCPU < 0 || o.EncoderMemory < 0:
+		return errors.New("encoder limits can't be negative")
+	case o.Cgroup == "" && (o.EncoderCPU > 0 || o.EncoderMemory > 0):
+		return errors.New("encoder limits need a cgroup")
+	case o.WAVBits != 16 && o.WAVBits != 24 && o.WAVBits != 32:
+		return errors.New("WAV bits must be 16, 24 or 32")
+	case o.ThumbSize < 16:
+		return errors.New("the thumbnail size must be at least 16")
+	case o.JPEGQuality < 1 || o.JPEGQuality > 100:
+		return errors.New("the JPEG quality must be between 1 and 100")
+	case o.FLACLevel < 0 || o.FLACLevel > 12:
+		return errors.New("the FLAC level must be between 0 and 12")
+	case o.Video && parseBitrate(o.VideoBitrate) <= 0:
+		return fmt.Errorf("invalid video bitrate %q", o.VideoBitrate)
+	case o.VideoTwoPass && !o.Video:
+		return errors.New("two-pass encodes need the video tree")
+	case o.VolumeIcon != "" && filepath.Ext(o.VolumeIcon) != ".icns":
+		return errors.New("the volume icon must be an .icns file")
+	case o.MaxNameLength < 12:
+		// Room for an 8.3 name
+		return errors.New("the maximum name length must be at least 12")
+	case o.ReadWindow > 0 && o.ReadWindow < 1<<20:
+		// It must hold at least a whole read request
+		return errors.New("the read window must be at least 1MiB")
+	case o.MaxWatches < 0:
+		return errors.New("the maximum number of watches can't be negative")
+	}
+	if err := o.limits().validate(); err != nil {
+		return err
+	}
+	if err := validSizeStrategy(o.SizeStrategy); err != nil {
+		return err
+	}
+	if err := validSortOrder(o.Sort); err != nil {
+		return err
+	}
+	if err := validWebhook(o.Webhook); err != nil {
+		return fmt.Errorf("webhook %s: %v", o.Webhook, err)
+	}
+	if err := validNormalizeForm(o.NormalizeNames); err != nil {
+		return err
+	}
+	if _, err := parseIOPriority(o.IONice); err != nil {
+		return err
+	}
+	if o.Rules != "" {
+		if _, err := loadRules(o.Rules); err != nil {
+			return fmt.Errorf("%s: %v", o.Rules, err)
+		}
+	}
+	return nil
+}
+
+// limits gives the limits set by the options
+func (o *Options) limits() limits {
+	return limits{
+		MemoryBudget:     o.MemoryBudget,
+		TranscodeTimeout: o.TranscodeTimeout,
+		ReadRateFactor:   o.ReadRateFactor,
+		MaxReadRate:      o.MaxReadRate,
+	}
+}
+
+// processOptions are the options shared by every Root of a process:
+// encoders, caches and limits
+type processOptions struct {
+	SizeCache        string
+	Limits           string
+	MemoryBudget     int64
+	Nice             int
+	IONice           string
+	Cgroup           string
+	EncoderCPU       float64
+	EncoderMemory    int64
+	Sandbox          bool
+	SandboxCommand   string
+	Webhook          string
+	EventCommand     string
+	TraceFUSE        bool
+	TranscodeRetries int
+	MaxFailures      int
+	TranscodeTimeout time.Duration
+	SeekDistance     time.Duration
+	ReadWindow       int64
+	ReadAhead        int64
+	Prefetch         bool
+	ReadRateFactor   float64
+	MaxReadRate      int64
+	MaxWatches       int
+}
+
+func (o *Options) process() processOptions {
+	return processOptions{
+		SizeCache:        o.SizeCache,
+		Limits:           o.Limits,
+		MemoryBudget:     o.MemoryBudget,
+		Nice:             o.Nice,
+		IONice:           o.IONice,
+		Cgroup:           o.Cgroup,
+		EncoderCPU:       o.EncoderCPU,
+		EncoderMemory:    o.EncoderMemory,
+		Sandbox:          o.Sandbox,
+		SandboxCommand:   o.SandboxCommand,
+		Webhook:          o.Webhook,
+		EventCommand:     o.EventCommand,
+		TraceFUSE:        o.TraceFUSE,
+		TranscodeRetries: o.TranscodeRetries,
+		MaxFailures:      o.MaxFailures,
+		TranscodeTimeout: o.TranscodeTimeout,
+		SeekDistance:     o.SeekDistance,
+		ReadWindow:       o.ReadWindow,
+		ReadAhead:        o.ReadAhead,
+		Prefetch:         o.Prefetch,
+		ReadRateFactor:   o.ReadRateFactor,
+		MaxReadRate:      o.MaxReadRate,
+		MaxWatches:       o.MaxWatches,
+	}
+}
+
+var (
+	applyMu sync.Mutex
+	// Options of the process, nil until the first New
+	applied *processOptions
+)
+
+// apply makes the process options of o those of the process, once valid.
+// It checks that encoders can run with them. The first Root sets them, and
+// the next ones must agree.
+func (o *Options) apply() error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	applyMu.Lock()
+	defer applyMu.Unlock()
+	po := o.process()
+	if applied != nil {
+		if *applied != po {
+			return errors.New("the options of encoders, caches and limits must be the same for every tree of a process")
+		}
+		return nil
+	}
+
+	baseLimits, limitsPath = o.limits(), o.Limits
+	l, err := loadLimits()
+	if err != nil {
+		return err
+	}
+	prio, _ := parseIOPriority(o.IONice)
+	sizeCachePath = o.SizeCache
+	encoderNice, encoderIO = o.Nice, prio
+	cgroupParent, encoderCPU, encoderMemory = o.Cgroup, o.EncoderCPU, o.EncoderMemory
+	sandbox, sandboxCommand = o.Sandbox, o.SandboxCommand
+	webhookURL, eventCommand = o.Webhook, o.EventCommand
+	traceFuse = o.TraceFUSE
+	transcodeRetries, maxFailures = o.TranscodeRetries, o.MaxFailures
+	seekDistance, readWindow, readAhead = o.SeekDistance, o.ReadWindow, o.ReadAhead
+	prefetchNext = o.Prefetch
+	maxWatches = o.MaxWatches
+	setLimits(l)
+
+	if cgroupParent != "" {
+		if err := checkCgroupParent(); err != nil {
+			return fmt.Errorf("cgroup %s: %v", cgroupParent, err)
+		}
+	}
+	if sandboxed() {
+		if err := checkSandbox(); err != nil {
+			return fmt.Errorf("can't run encoders in the sandbox: %v", err)
+		}
+	}
+	applied = &po
+	return nil
+}
+
+// The size cache is opened by the first New
+var sizeCacheOnce sync.Once
+
+// New builds the tree exposing source, a directory or a URL, with the given
+// options. The Root can then be mounted with Serve, or served over the
+// network with the Listen methods.
+func New(source string, opts Options) (*Root, error) {
+	if err := opts.apply(); err != nil {
+		return nil, err
+	}
+	ogg, _ := opts.profile()
+	var rules []*converterRule
+	if opts.Rules != "" {
+		var err error
+		if rules, err = loadRules(opts.Rules); err != nil {
+			return nil, fmt.Errorf("%s: %v", opts.Rules, err)
+		}
+	}
+	src, dir, local, err := openSource(source, opts.Union, opts.Archives)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", source, err)
+	}
+	if opts.Ingest != "" && !localSource(src) {
+		return nil, errors.New("ingesting needs a local source")
+	}
+	if opts.Watch && local && watcher == nil {
+		if watcher, err = fsnotify.NewWatcher(); err != nil {
+			return nil, err
+		}
+		go watchSource()
+	}
+	sizeCacheOnce.Do(openSizeCache)
+
+	root := newRoot(src, dir, ogg, opts, rules)
+	if opts.SizeScan && sizeDB != nil {
+		go scanSizes(root)
+	}
+	addMount(root)
+	return root, nil
+}
@@ -0,0 +1,56 @@
+package codecfs
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"bazil.org/fuse"
+	"golang.org/x/net/context"
+)
+
+func TestNewKeepsRootsApart(t *testing.T) {
+	src := t.TempDir()
+	for _, name := range []string{"t10.txt", "t2.txt"} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte("text"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts := DefaultOptions()
+	opts.SizeCache = ""
+	opts.Watch = false
+	list := func(r *Root) []string {
+		d := r.profileDir(r.ogg).(*dir)
+		h, err := d.Open(context.Background(), &fuse.OpenRequest{Dir: true}, &fuse.OpenResponse{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, ent := range h.(*dirHandle).all() {
+			names = append(names, ent.Name)
+		}
+		return names
+	}
+
+	bytes, err := New(src, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.Sort = "natural"
+	natural, err := New(src, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := list(bytes); !slices.Equal(got, []string{"t10.txt", "t2.txt"}) {
+		t.Errorf("bytes order: %v", got)
+	}
+	if got := list(natural); !slices.Equal(got, []string{"t2.txt", "t10.txt"}) {
+		t.Errorf("natural order: %v", got)
+	}
+
+	opts.MemoryBudget = 1 << 30
+	if _, err := New(src, opts); err == nil {
+		t.Error("New accepted other process options")
+	}
+}
@@ -0,0 +1,103 @@
+package codecfs
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"text/tabwriter"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+)
+
+// Plan walks tree, the name of a tree of the root like ogg or opus-96, and
+// writes to w what it exposes, and how, without mounting anything
+func (r *Root) Plan(w io.Writer, tree string) error {
+	ctx := context.Background()
+	top, err := lookupPath(ctx, r, tree)
+	if err != nil {
+		return fmt.Errorf("unknown tree %s", tree)
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ACTION\tSIZE\tPATH")
+	var total planTotal
+	err = planDir(ctx, tw, top, "", &total)
+	tw.Flush()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\n%d transcoded from %d bytes, %d as-is, %d generated: %s%d bytes in all\n",
+		total.transcoded, total.sourceSize, total.asIs, total.generated, total.approx(), total.size)
+	return nil
+}
+
+type planTotal struct {
+	transcoded, asIs, generated int
+	// Size of the sources of transcoded files
+	sourceSize int64
+	size       uint64
+	// Whether some sizes are estimations
+	estimated bool
+}
+
+func (t *planTotal) approx() string {
+	if t.estimated {
+		return "~"
+	}
+	return ""
+}
+
+// planDir prints the plan of every entry under the directory n, at path p of
+// the tree
+func planDir(ctx context.Context, w *tabwriter.Writer, n fs.Node, p string, total *planTotal) error {
+	ents, err := readDirAll(ctx, n)
+	if err != nil {
+		return fmt.Errorf("%s: %v", p, err)
+	}
+	for _, ent := range ents {
+		child, err := n.(fs.NodeStringLookuper).Lookup(ctx, ent.Name)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path.Join(p, ent.Name), err)
+		}
+		name := path.Join(p, ent.Name)
+		if ent.Type == fuse.DT_Dir {
+			if err := planDir(ctx, w, child, name, total); err != nil {
+				return err
+			}
+			continue
+		}
+
+		action := "generate"
+		a, err := nodeAttr(ctx, child)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		size, exact := a.Size, true
+		if f, ok := child.(*file); ok {
+			stat, err := f.profile.root.statSource(f.source)
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			if f.name == f.source {
+				action = "as-is"
+				total.asIs++
+			} else {
+				action = "transcode"
+				size, exact = f.size(stat)
+				total.transcoded++
+				total.sourceSize += stat.Size()
+			}
+		} else {
+			total.generated++
+		}
+		approx := ""
+		if !exact {
+			approx = "~"
+			total.estimated = true
+		}
+		total.size += size
+		fmt.Fprintf(w, "%s\t%s%d\t%s\n", action, approx, size, name)
+	}
+	return nil
+}
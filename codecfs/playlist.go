@@ -0,0 +1,213 @@
+package codecfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+)
+
+// Name of the playlists of tracks the directories get under Playlists, for
+// players that can't order tracks by themselves. A source file of that name
+// takes precedence.
+const playlistName = "album.m3u"
+
+// playlistEntry gives the dirent of the playlist of the directory, given the
+// rest of its listing, if it has one
+func (d *dir) playlistEntry(ents []fuse.Dirent, taken map[string]bool) (fuse.Dirent, bool) {
+	if !d.profile.root.opts.Playlists || d.profile.passthrough || !d.profile.audio() || taken[playlistName] || len(d.tracks(ents)) == 0 {
+		return fuse.Dirent{}, false
+	}
+	return fuse.Dirent{
+		Inode: inode(d.profile.name, filepath.Join(d.dir, playlistName)),
+		Type:  fuse.DT_File,
+		Name:  playlistName,
+	}, true
+}
+
+// tracks gives the names of the transcoded files among ents
+func (d *dir) tracks(ents []fuse.Dirent) []string {
+	var names []string
+	for _, ent := range ents {
+		if ent.Type == fuse.DT_File && ent.Name != playlistName && filepath.Ext(ent.Name) == d.profile.ext {
+			names = append(names, ent.Name)
+		}
+	}
+	return names
+}
+
+// playlist gives the playlist node of the directory, if it has one. The
+// source must have no file of that name.
+func (d *dir) playlist(ctx context.Context) (fs.Node, bool) {
+	h, err := d.Open(ctx, &fuse.OpenRequest{Dir: true}, &fuse.OpenResponse{})
+	if err != nil {
+		return nil, false
+	}
+	for _, ent := range h.(*dirHandle).all() {
+		if ent.Name == playlistName {
+			return node(d.profile.key(filepath.Join(d.dir, playlistName)), func() fs.Node {
+				return &playlistFile{dir: d}
+			}), true
+		}
+	}
+	return nil, false
+}
+
+var _ fs.NodeOpener = &playlistFile{}
+
+// playlistFile is an M3U playlist of the tracks of a directory, in the order
+// of their disc and track number tags
+type playlistFile struct {
+	readOnly
+	dir *dir
+}
+
+func (f *playlistFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	stat, err := f.dir.profile.root.statSource(f.dir.dir)
+	if err != nil {
+		return err
+	}
+	data, err := f.content(ctx)
+	if err != nil {
+		return err
+	}
+	a.Inode = inode(f.dir.profile.name, filepath.Join(f.dir.dir, playlistName))
+	a.Mode = 0444
+	a.Mtime = stat.ModTime()
+	a.Size = uint64(len(data))
+	setOwner(a)
+	return nil
+}
+
+func (f *playlistFile) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	if !req.Flags.IsReadOnly() {
+		return nil, errReadOnly
+	}
+	data, err := f.content(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &bytesHandle{data: data}, nil
+}
+
+type cachedPlaylist struct {
+	mtime time.Time
+	data  []byte
+}
+
+// Playlists built so far, by nodeKey of their directory
+var playlistCache sync.Map
+
+// content builds the playlist, or gives it again while the directory is
+// unchanged
+func (f *playlistFile) content(ctx context.Context) ([]byte, error) {
+	r := f.dir.profile.root
+	key := f.dir.profile.key(f.dir.dir)
+	stat, err := r.src.Stat(f.dir.dir)
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := playlistCache.Load(key); ok && v.(cachedPlaylist).mtime.Equal(stat.ModTime()) {
+		return v.(cachedPlaylist).data, nil
+	}
+
+	h, err := f.dir.Open(ctx, &fuse.OpenRequest{Dir: true}, &fuse.OpenResponse{})
+	if err != nil {
+		return nil, err
+	}
+	type track struct {
+		name string
+		tags trackTags
+	}
+	var tracks []track
+	for _, name := range f.dir.tracks(h.(*dirHandle).all()) {
+		source := filepath.Join(f.dir.dir, name)
+		if v, ok := r.names.Load(source); ok {
+			source = v.(string)
+		}
+		tracks = append(tracks, track{name, r.probeTags(source)})
+	}
+	// Tracks without tags stay in the order of the listing
+	sort.SliceStable(tracks, func(i, j int) bool {
+		a, b := tracks[i].tags, tracks[j].tags
+		if a.disc != b.disc {
+			return a.disc < b.disc
+		}
+		return a.track < b.track
+	})
+
+	var buf bytes.Buffer
+	buf.WriteString("#EXTM3U\n")
+	for _, t := range tracks {
+		if t.tags.title != "" {
+			title := t.tags.title
+			if t.tags.artist != "" {
+				title = t.tags.artist + " - " + title
+			}
+			fmt.Fprintf(&buf, "#EXTINF:%d,%s\n", int(t.tags.duration.Seconds()), title)
+		}
+		buf.WriteString(t.name + "\n")
+	}
+	playlistCache.Store(key, cachedPlaylist{stat.ModTime(), buf.Bytes()})
+	return buf.Bytes(), nil
+}
+
+// Tags of a track used to order it in playlists
+type trackTags struct {
+	disc     int
+	track    int
+	title    string
+	artist   string
+	duration time.Duration
+}
+
+// probeTags reads the tags of source in r with ffprobe. Tags that can't be
+// read are left empty.
+func (r *Root) probeTags(source string) trackTags {
+	var tags trackTags
+	out, err := r.probe(source, "-v", "error", "-show_entries", "format=duration:format_tags", "-of", "json")
+	if err != nil {
+		return tags
+	}
+	var probed struct {
+		Format struct {
+			Duration string            `json:"duration"`
+			Tags     map[string]string `json:"tags"`
+		} `json:"format"`
+	}
+	if json.Unmarshal(out, &probed) != nil {
+		return tags
+	}
+	if secs, err := strconv.ParseFloat(probed.Format.Duration, 64); err == nil {
+		tags.duration = time.Duration(secs * float64(time.Second))
+	}
+	// Tag names are upper case in Vorbis comments, and lower case elsewhere
+	for name, value := range probed.Format.Tags {
+		switch strings.ToLower(name) {
+		case "disc":
+			tags.disc = leadingNumber(value)
+		case "track":
+			tags.track = leadingNumber(value)
+		case "title":
+			tags.title = value
+		case "artist":
+			tags.artist = value
+		}
+	}
+	return tags
+}
+
+// leadingNumber parses numbers like the 3 of "3/12"
+func leadingNumber(s string) int {
+	n, _ := strconv.Atoi(digits(strings.TrimSpace(s)))
+	return n
+}
@@ -0,0 +1,90 @@
+package codecfs
+
+import (
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Start transcoding the next file of a directory when one is read to the
+// end, so that players don't wait at track changes
+var prefetchNext bool
+
+// How long a prefetched transcode waits to be opened before it is dropped
+const prefetchTTL = time.Minute
+
+// Transcodes started in advance and not opened yet, by the nodeKey of their
+// source in the tree they were started for
+var prefetched sync.Map
+
+// prefetch starts transcoding the file following source in its directory
+func prefetch(source string, p *profile) {
+	r := p.root
+	dir := filepath.Dir(source)
+	names, err := r.src.ReadDirNames(dir)
+	if err != nil {
+		return
+	}
+	r.sortNames(names)
+
+	base := filepath.Base(source)
+	i := sort.Search(len(names), func(i int) bool { return !r.lessNames(names[i], base) })
+	for _, name := range names[min(i+1, len(names)):] {
+		path := filepath.Join(dir, name)
+		stat, err := r.statSource(path)
+		if err != nil || stat.IsDir() || p.hidden(name, false) || !p.transcodes(path) {
+			continue
+		}
+
+		key := p.key(path)
+		if _, ok := prefetched.Load(key); ok || brokenSource(path) {
+			return
+		}
+		t, err := startTranscode(path, p, 0)
+		if err != nil {
+			slog.Warn("Can't prefetch", "source", path, "err", err)
+			return
+		}
+		slog.Debug("Prefetching", "source", path, "profile", p.name, "id", t.id)
+		prefetched.Store(key, t)
+		time.AfterFunc(prefetchTTL, func() {
+			if prefetched.CompareAndDelete(key, t) {
+				t.cancel()
+				t.wait()
+			}
+		})
+		return
+	}
+}
+
+// takePrefetched gives the transcode prefetched for source, if any and if
+// the source is still the version it started from
+func takePrefetched(source string, p *profile) *transcode {
+	v, ok := prefetched.LoadAndDelete(p.key(source))
+	if !ok {
+		return nil
+	}
+	t := v.(*transcode)
+	if stat, err := p.root.statSource(source); err != nil || !versionOf(stat).equal(t.version) {
+		slog.Debug("Dropping stale prefetch", "source", source, "profile", p.name, "id", t.id)
+		t.cancel()
+		t.wait()
+		return nil
+	}
+	return t
+}
+
+// dropPrefetched stops the transcodes prefetched for source, whose output
+// is stale
+func dropPrefetched(source string) {
+	prefetched.Range(func(k, v interface{}) bool {
+		if k.(nodeKey).path == source && prefetched.CompareAndDelete(k, v) {
+			t := v.(*transcode)
+			t.cancel()
+			t.wait()
+		}
+		return true
+	})
+}
@@ -0,0 +1,67 @@
+package codecfs
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// Niceness of encoders, 0 to leave it as ours
+var encoderNice int
+
+// I/O scheduling class and level of encoders, as for ionice. The zero value
+// leaves them as ours.
+type ioPriority struct {
+	class int
+	level int
+}
+
+// I/O scheduling classes of Linux
+const (
+	ioClassBestEffort = 2
+	ioClassIdle       = 3
+)
+
+var encoderIO ioPriority
+
+// parseIOPriority parses I/O priorities like idle or best-effort:7, from
+// the highest level 0 to the lowest 7. Empty is the zero value.
+func parseIOPriority(s string) (ioPriority, error) {
+	class, level, hasLevel := strings.Cut(s, ":")
+	switch class {
+	case "":
+		return ioPriority{}, nil
+	case "idle":
+		if hasLevel {
+			return ioPriority{}, fmt.Errorf("the idle class has no level")
+		}
+		return ioPriority{class: ioClassIdle}, nil
+	case "best-effort":
+		p := ioPriority{class: ioClassBestEffort, level: 4}
+		if hasLevel {
+			l, err := strconv.Atoi(level)
+			if err != nil || l < 0 || l > 7 {
+				return ioPriority{}, fmt.Errorf("invalid level %q, must be between 0 and 7", level)
+			}
+			p.level = l
+		}
+		return p, nil
+	}
+	return ioPriority{}, fmt.Errorf("unknown I/O class %q, must be idle or best-effort", class)
+}
+
+// applyPriority gives the encoder pid the priorities it is configured with.
+// Threads inherit them, so it must be done before it starts its own.
+func applyPriority(pid int) {
+	if encoderNice != 0 {
+		if err := setNice(pid, encoderNice); err != nil {
+			slog.Warn("Can't set encoder niceness", "pid", pid, "err", err)
+		}
+	}
+	if encoderIO.class != 0 {
+		if err := setIOPriority(pid, encoderIO); err != nil {
+			slog.Warn("Can't set encoder I/O priority", "pid", pid, "err", err)
+		}
+	}
+}
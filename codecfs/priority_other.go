@@ -0,0 +1,12 @@
+//go:build !unix
+// +build !unix
+
+package codecfs
+
+func setNice(pid, nice int) error {
+	return nil
+}
+
+func lowerPriority(pid int) error {
+	return nil
+}
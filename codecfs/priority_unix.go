@@ -0,0 +1,18 @@
+//go:build unix
+// +build unix
+
+package codecfs
+
+import "syscall"
+
+// setNice sets the niceness of the process pid
+func setNice(pid, nice int) error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, pid, nice)
+}
+
+// lowerPriority makes the process pid yield the CPU and disks to everything
+// else
+func lowerPriority(pid int) error {
+	setIOPriority(pid, ioPriority{class: ioClassIdle})
+	return setNice(pid, 19)
+}
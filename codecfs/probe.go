@@ -0,0 +1,74 @@
+package codecfs
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// How long ffprobe is given to tell about a source before it is killed, so
+// that a source that hangs doesn't hold up the listings looking into it
+const probeTimeout = 30 * time.Second
+
+// probe runs ffprobe with args on the source at path in r, under the sandbox
+// if there is one, and gives its output. Sources ffprobe can't reach by
+// itself are fed to it, as encoders are.
+func (r *Root) probe(path string, args ...string) ([]byte, error) {
+	input := r.src.Input(path)
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	var stdin sourceFile
+	if input == "" || sandboxed() && remoteInput(input) {
+		var err error
+		if stdin, err = r.src.Open(path); err != nil {
+			return nil, err
+		}
+		defer stdin.Close()
+		input = pipeInput
+	}
+	cmd := encoderCommandContext(ctx, "ffprobe", append(args, input)...)
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	return cmd.Output()
+}
+
+// Probe writes to w how the file at path would be exposed by a tree built
+// with opts
+func Probe(w io.Writer, path string, opts Options) error {
+	p, err := opts.profile()
+	if err != nil {
+		return err
+	}
+	r := &Root{src: localFS{}, opts: opts}
+	p.root = r
+	file, err := r.src.Open(path)
+	if err != nil {
+		return err
+	}
+	var buf [512]byte
+	n, _ := file.Read(buf[:])
+	file.Close()
+
+	p = p.forDir(filepath.Dir(path))
+	name := filepath.Base(path)
+	fmt.Fprintln(w, "Content type:", http.DetectContentType(buf[:n]))
+	switch {
+	case p.hidden(name, false):
+		fmt.Fprintln(w, "Hidden by filters")
+	case p.transcodes(path):
+		fmt.Fprintln(w, "Exposed as:", p.convertedName(name))
+		name, args := p.command(path, r.src.Input(path), 0)
+		fmt.Fprintln(w, "Command:", name, strings.Join(args, " "))
+	case p.mediaOnly && !r.isAudio(path):
+		fmt.Fprintln(w, "Hidden: not a media file")
+	default:
+		fmt.Fprintln(w, "Exposed as-is")
+	}
+	return nil
+}
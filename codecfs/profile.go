@@ -0,0 +1,408 @@
+package codecfs
+
+import (
+	"fmt"
+	"mime"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A profile describes one transcoding tree exposed at the root of the mount
+type profile struct {
+	// Name of the directory at the root of the mount
+	name string
+	// Root the tree is in, holding its source and options
+	root *Root
+	// Mirror the source tree as-is: no renaming, no filtering, no
+	// transcoding
+	passthrough bool
+
+	// ffmpeg output format, as given to -f
+	format string
+	// Extension given to transcoded files, with the leading dot
+	ext string
+
+	// Encoder quality (-q:a) and target bitrate (-b:a), empty to use the
+	// encoder defaults
+	quality string
+	bitrate string
+	// Whether quality and bitrate were picked by the name of the profile,
+	// so that directory configs don't override them
+	fixedQuality bool
+	// Rate control mode among rateControls, empty to let the encoder pick
+	rateControl string
+
+	// Settings of the opus encoder, empty for its defaults: the
+	// application (audio, voip or lowdelay), the frame duration in ms and
+	// the complexity from 0 to 10
+	opus opusSettings
+	// AAC encoder among aacEncoders, empty for the best one ffmpeg has
+	aac string
+	// Encoder compression level (-compression_level), for lossless
+	// formats, empty to use the encoder default
+	compressionLevel string
+	// PCM codec of wav output, e.g. pcm_s24le, empty for 16 bits
+	pcmCodec string
+	// Whether the tree converts images instead of audio
+	images bool
+	// Whether the tree encodes videos, keeping their picture
+	video bool
+	// Target bitrate of the picture of videos (-b:v)
+	videoBitrate string
+	// Whether videos are encoded in two passes, the first one analyzing
+	// them so the second one spreads the bitrate where it's needed
+	twoPass bool
+	// Largest side of the images of thumbnail trees in pixels, 0 for
+	// other trees
+	thumbnail int
+	// Rule of the user converting files, nil for other trees
+	rule *converterRule
+	// Sources with a bitrate up to that many kbit/s are shown as they are
+	// instead of being transcoded again, 0 to transcode them all
+	minBitrate int64
+	// Files smaller than that many bytes are shown as they are, 0 to
+	// transcode them all
+	minSize int64
+	// Whether the output is lossless, in which case lossy sources are
+	// shown as they are
+	lossless bool
+
+	// Output sample rate in Hz, 0 to keep the source rate
+	sampleRate int
+	// Number of output channels, 0 to keep the source layout. ffmpeg
+	// takes care of the downmix when there are less channels than in the
+	// source.
+	channels int
+
+	// Glob patterns matched against source names. Excluded entries are
+	// never shown nor sniffed; when include is not empty, only files
+	// matching one of its patterns are shown.
+	exclude []string
+	include []string
+
+	// Leave files that are not audio or video out of the tree
+	mediaOnly bool
+
+	// Part of the source to encode, for the chapters of audiobooks. Both
+	// are 0 to encode it whole.
+	clipStart, clipEnd time.Duration
+}
+
+// ffmpegArgs gives the arguments to encode input from timestamp start
+func (p *profile) ffmpegArgs(input string, start time.Duration) []string {
+	var args, seek []string
+	if start+p.clipStart > 0 {
+		seek = []string{"-ss", strconv.FormatFloat((start + p.clipStart).Seconds(), 'f', 3, 64)}
+	}
+	if input != pipeInput {
+		// Before -i, so that ffmpeg seeks in the input instead of
+		// decoding everything up to start
+		args = append(args, seek...)
+		seek = nil
+	}
+	args = append(args, "-i", input)
+	// Pipes can't seek, ffmpeg decodes and drops everything up to start
+	args = append(args, seek...)
+	if p.clipEnd > 0 {
+		args = append(args, "-t", strconv.FormatFloat((p.clipEnd-p.clipStart-start).Seconds(), 'f', 3, 64))
+	}
+	if p.format == "adts" {
+		args = append(args, "-c:a", p.aacEncoder())
+	}
+	if p.pcmCodec != "" {
+		args = append(args, "-c:a", p.pcmCodec)
+	}
+	if p.quality != "" && p.rateControl != "cbr" && p.rateControl != "abr" {
+		if p.format == "adts" && p.aacEncoder() == "libfdk_aac" {
+			// Its VBR modes go from 1 to 5 instead
+			args = append(args, "-vbr", p.quality)
+		} else {
+			args = append(args, "-q:a", p.quality)
+		}
+	}
+	if p.bitrate != "" {
+		args = append(args, "-b:a", p.bitrate)
+	}
+	args = append(args, p.rateControlArgs()...)
+	if p.format == "opus" {
+		args = append(args, p.opus.args()...)
+	}
+	if p.compressionLevel != "" {
+		args = append(args, "-compression_level", p.compressionLevel)
+	}
+	if p.sampleRate > 0 {
+		args = append(args, "-ar", strconv.Itoa(p.sampleRate))
+	}
+	if p.channels > 0 {
+		args = append(args, "-ac", strconv.Itoa(p.channels))
+	}
+	return append(args,
+		// Encoding a file twice gives the same bytes, so parts of it
+		// can be encoded again when needed
+		"-fflags", "+bitexact",
+		"-flags:a", "+bitexact",
+		"-f",
+		p.format,
+		"-",
+	)
+}
+
+// Rate control modes: variable bitrate, constrained variable bitrate,
+// average bitrate and constant bitrate. All but vbr need a bitrate.
+var rateControls = []string{"vbr", "cvbr", "abr", "cbr"}
+
+func validRateControl(mode, bitrate string) error {
+	switch mode {
+	case "", "vbr":
+		return nil
+	case "cvbr", "abr", "cbr":
+		if bitrate == "" {
+			return fmt.Errorf("rate control %s needs a bitrate", mode)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown rate control %q, must be one of %s", mode, strings.Join(rateControls, ", "))
+}
+
+// rateControlArgs gives the encoder arguments for the rate control mode of
+// the profile. Encoders without a mode use the closest one, and the quality
+// is left out for abr and cbr since it makes encoders switch to vbr.
+func (p *profile) rateControlArgs() []string {
+	switch p.format {
+	case "opus":
+		switch p.rateControl {
+		case "vbr":
+			return []string{"-vbr", "on"}
+		case "cvbr", "abr":
+			return []string{"-vbr", "constrained"}
+		case "cbr":
+			return []string{"-vbr", "off"}
+		}
+	case "mp3":
+		switch p.rateControl {
+		case "cvbr", "abr":
+			return []string{"-abr", "1"}
+		}
+	case "ogg":
+		switch p.rateControl {
+		case "cvbr":
+			return []string{"-maxrate", p.bitrate}
+		case "cbr":
+			return []string{"-minrate", p.bitrate, "-maxrate", p.bitrate}
+		}
+	}
+	return nil
+}
+
+type opusSettings struct {
+	application   string
+	frameDuration string
+	complexity    string
+}
+
+// Frame durations opus supports, in ms
+var opusFrameDurations = []string{"2.5", "5", "10", "20", "40", "60", "80", "100", "120"}
+
+func (s opusSettings) validate() error {
+	switch s.application {
+	case "", "audio", "voip", "lowdelay":
+	default:
+		return fmt.Errorf("unknown opus application %q, must be audio, voip or lowdelay", s.application)
+	}
+	if s.frameDuration != "" && !slices.Contains(opusFrameDurations, s.frameDuration) {
+		return fmt.Errorf("invalid opus frame duration %q, must be one of %s", s.frameDuration, strings.Join(opusFrameDurations, ", "))
+	}
+	if s.complexity != "" {
+		if c, err := strconv.Atoi(s.complexity); err != nil || c < 0 || c > 10 {
+			return fmt.Errorf("invalid opus complexity %q, must be between 0 and 10", s.complexity)
+		}
+	}
+	return nil
+}
+
+// args gives the arguments of libopus for the settings
+func (s opusSettings) args() []string {
+	var args []string
+	if s.application != "" {
+		args = append(args, "-application", s.application)
+	}
+	if s.frameDuration != "" {
+		args = append(args, "-frame_duration", s.frameDuration)
+	}
+	if s.complexity != "" {
+		args = append(args, "-compression_level", s.complexity)
+	}
+	return args
+}
+
+// audio tells whether the tree transcodes audio, rather than converting
+// images or running rules
+func (p *profile) audio() bool {
+	return !p.images && !p.video && p.rule == nil
+}
+
+// seekable tells whether output produced from the middle of the source can
+// be served at an offset inside the file: players resynchronize on the pages
+// or frames of these formats
+func (p *profile) seekable() bool {
+	switch p.format {
+	case "ogg", "opus", "mp3", "adts":
+		return true
+	}
+	return false
+}
+
+// Media types of the output formats
+var formatTypes = map[string]string{
+	"ogg":  "audio/ogg",
+	"opus": "audio/ogg",
+	"mp3":  "audio/mpeg",
+	"adts": "audio/aac",
+	"flac": "audio/flac",
+	"wav":  "audio/wav",
+	"jpeg": "image/jpeg",
+	"webm": "video/webm",
+}
+
+// contentType gives the media type of transcoded files
+func (p *profile) contentType() string {
+	if t, ok := formatTypes[p.format]; ok {
+		return t
+	}
+	if t := mime.TypeByExtension(p.ext); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// Nominal bitrates of libvorbis in kbit/s, for qualities -1 to 10
+var vorbisBitrates = []int64{45, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 500}
+
+// byteRate estimates how many bytes of output a second of audio gives, 0 if
+// there's no way to tell
+func (p *profile) byteRate() int64 {
+	if p.video {
+		// The audio of videos goes to libopus, which defaults to 96kbit/s
+		// for stereo
+		if bits := parseBitrate(p.videoBitrate); bits > 0 {
+			return (bits + 96000) / 8
+		}
+		return 0
+	}
+	if p.bitrate != "" {
+		return parseBitrate(p.bitrate) / 8
+	}
+	if p.format != "ogg" {
+		return 0
+	}
+	// libvorbis defaults to quality 3
+	quality := 3.0
+	if p.quality != "" {
+		q, err := strconv.ParseFloat(p.quality, 64)
+		if err != nil {
+			return 0
+		}
+		quality = q
+	}
+	i := int(quality) + 1
+	if i < 0 {
+		i = 0
+	} else if i >= len(vorbisBitrates) {
+		i = len(vorbisBitrates) - 1
+	}
+	return vorbisBitrates[i] * 1000 / 8
+}
+
+// parseBitrate gives the bits per second of a bitrate as ffmpeg takes it,
+// e.g. 128k, or 0 when it isn't one
+func parseBitrate(bitrate string) int64 {
+	s := strings.ToLower(bitrate)
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(s, "k"):
+		mult, s = 1000, strings.TrimSuffix(s, "k")
+	case strings.HasSuffix(s, "m"):
+		mult, s = 1000000, strings.TrimSuffix(s, "m")
+	}
+	bits, err := strconv.ParseFloat(s, 64)
+	if err != nil || bits < 0 {
+		return 0
+	}
+	return int64(bits * float64(mult))
+}
+
+// convertedName gives the name a source file gets once transcoded
+func (p *profile) convertedName(name string) string {
+	if p.thumbnail > 0 {
+		return name + p.ext
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name)) + p.ext
+}
+
+// Codecs that can be picked by the name of a directory at the root, with
+// their output format and extension
+var namedCodecs = map[string]struct{ format, ext string }{
+	"ogg":  {"ogg", ".ogg"},
+	"opus": {"opus", ".opus"},
+	"mp3":  {"mp3", ".mp3"},
+	"aac":  {"adts", ".aac"},
+}
+
+// LAME presets, as settings of named mp3 profiles
+var lamePresets = map[string]string{
+	"insane":   "320-cbr",
+	"extreme":  "v0",
+	"standard": "v2",
+	"medium":   "v4",
+}
+
+// namedProfile derives a profile from base according to name, a codec and a
+// setting like ogg-q3, opus-64 or mp3-320: a number is a bitrate in kbit/s,
+// and a number prefixed by q (or v, as in LAME's VBR presets) an encoder
+// quality. A rate control mode can follow, as in opus-64-cbr. mp3 profiles
+// can also be named after LAME presets, as in mp3-V0 or mp3-extreme. ok is
+// false when name doesn't describe a profile.
+func namedProfile(base *profile, name string) (*profile, bool) {
+	codec, setting, ok := strings.Cut(name, "-")
+	c, known := namedCodecs[codec]
+	if !ok || !known || setting == "" {
+		return nil, false
+	}
+	if preset, ok := lamePresets[strings.ToLower(setting)]; ok && codec == "mp3" {
+		setting = preset
+	}
+	setting, mode, _ := strings.Cut(setting, "-")
+	if setting == "" {
+		// As in opus--cbr
+		return nil, false
+	}
+
+	named := *base
+	named.name = name
+	named.format = c.format
+	named.ext = c.ext
+	named.quality = ""
+	named.bitrate = ""
+	named.fixedQuality = true
+	switch setting[0] {
+	case 'q', 'v', 'V':
+		if _, err := strconv.ParseFloat(setting[1:], 64); err != nil {
+			return nil, false
+		}
+		named.quality = setting[1:]
+	default:
+		kbps, err := strconv.Atoi(setting)
+		if err != nil || kbps <= 0 {
+			return nil, false
+		}
+		named.bitrate = setting + "k"
+	}
+	named.rateControl = mode
+	if validRateControl(mode, named.bitrate) != nil {
+		return nil, false
+	}
+	return &named, true
+}
@@ -0,0 +1,44 @@
+package codecfs
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNamedProfile(t *testing.T) {
+	base := &profile{name: "ogg", format: "ogg", ext: ".ogg"}
+	for _, name := range []string{"opus-64", "ogg-q3", "mp3-V0", "mp3-extreme", "opus-96-cbr"} {
+		if _, ok := namedProfile(base, name); !ok {
+			t.Errorf("%s: not a profile", name)
+		}
+	}
+	for _, name := range []string{"opus", "opus-", "opus--cbr", "mp3--x", "opus--", "ogg-q", "ogg-qx", "mp3-0", "flac-5", "opus-64-bogus"} {
+		if _, ok := namedProfile(base, name); ok {
+			t.Errorf("%s: taken for a profile", name)
+		}
+	}
+}
+
+func TestVideoProfile(t *testing.T) {
+	base := &profile{name: "ogg", format: "ogg", ext: ".ogg", root: &Root{dir: "/music"}}
+	p := videoProfile(base, "800k", true)
+	if p.audio() || p.seekable() {
+		t.Error("video tree taken for audio")
+	}
+	if rate := p.byteRate(); rate != (800000+96000)/8 {
+		t.Errorf("byte rate %d", rate)
+	}
+	args := p.videoArgs("clip.mkv", pipeInput)
+	var passLog string
+	for i, arg := range args[:len(args)-1] {
+		if arg == "-passlogfile" {
+			passLog = args[i+1]
+		}
+	}
+	if passLog == "" || passLog != p.passLog("clip.mkv") || passLog == p.passLog("other.mkv") {
+		t.Errorf("pass log %q", passLog)
+	}
+	if args := videoProfile(base, "800k", false).videoArgs("clip.mkv", pipeInput); slices.Contains(args, "-pass") {
+		t.Errorf("one pass encode with %v", args)
+	}
+}
@@ -0,0 +1,83 @@
+package codecfs
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ffmpeg reports its progress as key=value lines on that file descriptor,
+// the first of the command's ExtraFiles
+const progressFD = "pipe:3"
+
+// reportProgress makes the command name with args, if it runs ffmpeg,
+// report its progress to a pipe. The encoder is to be given the write end
+// of the pipe as its first ExtraFiles.
+func reportProgress(name string, args []string) ([]string, *os.File, *os.File) {
+	if name != "ffmpeg" {
+		return args, nil, nil
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return args, nil, nil
+	}
+	return append([]string{"-progress", progressFD}, args...), r, w
+}
+
+// readProgress reads the progress reports of ffmpeg from r until it exits
+func (t *transcode) readProgress(r io.ReadCloser) {
+	defer r.Close()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), "=")
+		// out_time_ms is in microseconds too
+		if key != "out_time_us" && key != "out_time_ms" {
+			continue
+		}
+		if us, err := strconv.ParseInt(value, 10, 64); err == nil && us >= 0 {
+			t.encoded.Store(int64(t.start + time.Duration(us)*time.Microsecond))
+		}
+	}
+}
+
+// percent estimates how much of the output t encoded so far, from how long
+// the output plays
+func (t *transcode) percent() (float64, bool) {
+	encoded := time.Duration(t.encoded.Load())
+	if encoded == 0 {
+		return 0, false
+	}
+	total := t.profile.clipEnd - t.profile.clipStart
+	if t.profile.clipEnd == 0 {
+		stat, err := t.profile.root.statSource(t.source)
+		if err != nil {
+			return 0, false
+		}
+		var ok bool
+		if total, ok = t.profile.root.sourceDuration(t.source, stat.ModTime()); !ok {
+			return 0, false
+		}
+		total -= t.profile.clipStart
+	}
+	if total <= 0 {
+		return 0, false
+	}
+	return min(100, 100*encoded.Seconds()/total.Seconds()), true
+}
+
+// runningTranscode gives the transcode of source by p in progress, the
+// latest one if several are
+func runningTranscode(source string, p *profile) (*transcode, bool) {
+	var found *transcode
+	transcodes.Range(func(k, v interface{}) bool {
+		t := v.(*transcode)
+		if t.source == source && t.profile.name == p.name && (found == nil || t.id > found.id) {
+			found = t
+		}
+		return true
+	})
+	return found, found != nil
+}
@@ -0,0 +1,73 @@
+package codecfs
+
+import (
+	"syscall"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+)
+
+// Returned for anything trying to change the tree
+const errReadOnly = fuse.Errno(syscall.EROFS)
+
+// readOnly is embedded in the nodes of the tree so that every write request
+// gets a clear EROFS, instead of the mix of ENOSYS and EPERM bazil answers
+// for unimplemented requests, which some applications keep retrying.
+type readOnly struct{}
+
+var _ fs.NodeSetattrer = readOnly{}
+var _ fs.NodeCreater = readOnly{}
+var _ fs.NodeMkdirer = readOnly{}
+var _ fs.NodeMknoder = readOnly{}
+var _ fs.NodeRemover = readOnly{}
+var _ fs.NodeRenamer = readOnly{}
+var _ fs.NodeSymlinker = readOnly{}
+var _ fs.NodeLinker = readOnly{}
+var _ fs.NodeSetxattrer = readOnly{}
+var _ fs.NodeRemovexattrer = readOnly{}
+var _ fs.HandleWriter = readOnly{}
+
+func (readOnly) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	return errReadOnly
+}
+
+func (readOnly) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.CreateResponse) (fs.Node, fs.Handle, error) {
+	return nil, nil, errReadOnly
+}
+
+func (readOnly) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node, error) {
+	return nil, errReadOnly
+}
+
+func (readOnly) Mknod(ctx context.Context, req *fuse.MknodRequest) (fs.Node, error) {
+	return nil, errReadOnly
+}
+
+func (readOnly) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
+	return errReadOnly
+}
+
+func (readOnly) Rename(ctx context.Context, req *fuse.RenameRequest, newDir fs.Node) error {
+	return errReadOnly
+}
+
+func (readOnly) Symlink(ctx context.Context, req *fuse.SymlinkRequest) (fs.Node, error) {
+	return nil, errReadOnly
+}
+
+func (readOnly) Link(ctx context.Context, req *fuse.LinkRequest, old fs.Node) (fs.Node, error) {
+	return nil, errReadOnly
+}
+
+func (readOnly) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) error {
+	return errReadOnly
+}
+
+func (readOnly) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) error {
+	return errReadOnly
+}
+
+func (readOnly) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	return errReadOnly
+}
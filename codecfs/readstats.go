@@ -0,0 +1,95 @@
+package codecfs
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// readStats counts the reads served by a handle, or by all the handles of a
+// kind
+type readStats struct {
+	reads atomic.Int64
+	bytes atomic.Int64
+	// Reads served from encoder output buffered already, without waiting
+	// for the encoder nor starting one
+	hits atomic.Int64
+	// Total and longest time taken by reads
+	latency    atomic.Int64
+	maxLatency atomic.Int64
+}
+
+// record counts a read of n bytes that took d
+func (s *readStats) record(n int, hit bool, d time.Duration) {
+	s.reads.Add(1)
+	s.bytes.Add(int64(n))
+	if hit {
+		s.hits.Add(1)
+	}
+	s.latency.Add(int64(d))
+	for {
+		longest := s.maxLatency.Load()
+		if int64(d) <= longest || s.maxLatency.CompareAndSwap(longest, int64(d)) {
+			break
+		}
+	}
+}
+
+// readSummary sums up reads in the status
+type readSummary struct {
+	Reads       int64   `json:"reads"`
+	Bytes       int64   `json:"bytes"`
+	CacheHits   int64   `json:"cache_hits"`
+	MeanLatency float64 `json:"mean_latency_seconds"`
+	MaxLatency  float64 `json:"max_latency_seconds"`
+}
+
+func (s *readStats) summary() readSummary {
+	st := readSummary{
+		Reads:      s.reads.Load(),
+		Bytes:      s.bytes.Load(),
+		CacheHits:  s.hits.Load(),
+		MaxLatency: time.Duration(s.maxLatency.Load()).Seconds(),
+	}
+	if st.Reads > 0 {
+		st.MeanLatency = time.Duration(s.latency.Load()).Seconds() / float64(st.Reads)
+	}
+	return st
+}
+
+// Reads since the start, by kind of file as in the bytes served metric.
+// Native files have no cache of ours, their reads are never hits.
+var (
+	transcodedReads readStats
+	nativeReads     readStats
+)
+
+// Open handles of transcoded files, for their stats
+var openHandles sync.Map
+
+// handleStats are the reads of an open handle in the status
+type handleStats struct {
+	Name    string    `json:"name"`
+	Profile string    `json:"profile"`
+	Opened  time.Time `json:"opened"`
+	readSummary
+}
+
+func openHandleStats() []handleStats {
+	out := []handleStats{}
+	openHandles.Range(func(k, v interface{}) bool {
+		fh := k.(*fileHandle)
+		out = append(out, handleStats{
+			Name:        fh.name,
+			Profile:     fh.profile.name,
+			Opened:      fh.opened,
+			readSummary: fh.stats.summary(),
+		})
+		return true
+	})
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Opened.Before(out[j].Opened)
+	})
+	return out
+}
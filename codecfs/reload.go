@@ -0,0 +1,86 @@
+package codecfs
+
+import (
+	"log/slog"
+	"sync"
+
+	"bazil.org/fuse/fs"
+)
+
+// Serializes reloads
+var reloadMu sync.Mutex
+
+// Reload reads the limits, the rules and the directory configs again, and
+// has the trees rebuilt with them. Files already open keep being served
+// with the settings they were opened with, so that playback isn't
+// interrupted.
+func Reload() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	applyMu.Lock()
+	l, err := loadLimits()
+	applyMu.Unlock()
+	if err != nil {
+		return err
+	}
+	roots := roots()
+	rules := make([][]*converterRule, len(roots))
+	for i, root := range roots {
+		if root.opts.Rules == "" {
+			continue
+		}
+		if rules[i], err = loadRules(root.opts.Rules); err != nil {
+			return err
+		}
+	}
+	setLimits(l)
+
+	// Everything derived from the profiles of the trees
+	for _, m := range []*sync.Map{&dirCache, &playlistCache, &allSizes} {
+		m.Range(func(k, v interface{}) bool {
+			m.Delete(k)
+			return true
+		})
+	}
+	prefetched.Range(func(k, v interface{}) bool {
+		if prefetched.CompareAndDelete(k, v) {
+			t := v.(*transcode)
+			t.cancel()
+			t.wait()
+		}
+		return true
+	})
+
+	for i, root := range roots {
+		root.mu.Lock()
+		old := root.profiles
+		root.rules = rules[i]
+		root.profiles = root.buildProfiles()
+		root.mu.Unlock()
+
+		// The kernel looks the trees up again, and finds the nodes
+		// built with the new profiles
+		stale := map[string]bool{}
+		for _, p := range old {
+			stale[p.name] = true
+		}
+		nodes.Range(func(k, v interface{}) bool {
+			key := k.(nodeKey)
+			if key.root != root {
+				return true
+			}
+			if key.path == root.dir {
+				stale[key.profile] = true
+			}
+			forgetNode(key, v.(fs.Node))
+			return true
+		})
+		for name := range stale {
+			eachServer(func(srv *fs.Server) {
+				srv.InvalidateEntry(root, name)
+			})
+		}
+	}
+	slog.Info("Configuration reloaded")
+	return nil
+}
@@ -0,0 +1,126 @@
+package codecfs
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// A converterRule exposes a tree where the files it matches are converted by
+// a command of the user's, e.g. markdown to HTML with pandoc
+type converterRule struct {
+	// Name of the tree at the root of the mount
+	Name string `toml:"name"`
+	// Glob patterns matched against source names
+	Match []string `toml:"match"`
+	// Command and arguments writing the converted file to stdout.
+	// {input} stands for the source; the source is fed on stdin when no
+	// argument has it.
+	Command []string `toml:"command"`
+	// Extension of converted files, with the leading dot
+	Ext string `toml:"ext"`
+}
+
+// Names of the directories codecfs may put at the root
+var reservedTreeNames = map[string]bool{
+	"ogg": true, "original": true, "flac": true, "wav": true, "jpeg": true,
+	"thumbs": true, "video": true, statusDirName: true, ingestDirName: true,
+}
+
+// loadRules reads the rules defined at path, like:
+//
+//	[[rule]]
+//	name = "html"
+//	match = ["*.md"]
+//	command = ["pandoc", "-f", "markdown", "-t", "html", "{input}"]
+//	ext = ".html"
+func loadRules(path string) ([]*converterRule, error) {
+	var conf struct {
+		Rules []*converterRule `toml:"rule"`
+	}
+	if _, err := toml.DecodeFile(path, &conf); err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, r := range conf.Rules {
+		switch {
+		case r.Name == "" || strings.ContainsRune(r.Name, '/'):
+			return nil, fmt.Errorf("invalid rule name %q", r.Name)
+		case names[r.Name]:
+			return nil, fmt.Errorf("more than one rule named %q", r.Name)
+		case reservedTreeNames[r.Name]:
+			return nil, fmt.Errorf("rule name %q is taken by codecfs", r.Name)
+		case len(r.Match) == 0:
+			return nil, fmt.Errorf("rule %s matches nothing", r.Name)
+		case len(r.Command) == 0:
+			return nil, fmt.Errorf("rule %s has no command", r.Name)
+		case !strings.HasPrefix(r.Ext, ".") || len(r.Ext) < 2:
+			return nil, fmt.Errorf("rule %s: extension must start with a dot", r.Name)
+		}
+		for _, pattern := range r.Match {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("rule %s: %v", r.Name, err)
+			}
+		}
+		names[r.Name] = true
+	}
+	if len(conf.Rules) == 0 {
+		return nil, errors.New("no rule defined")
+	}
+	return conf.Rules, nil
+}
+
+// ruleProfile derives the profile of the tree of rule r from base, keeping
+// its filters
+func ruleProfile(base *profile, r *converterRule) *profile {
+	p := *base
+	p.name = r.Name
+	p.format = ""
+	p.ext = r.Ext
+	p.rule = r
+	p.quality = ""
+	p.bitrate = ""
+	p.fixedQuality = true
+	p.rateControl = ""
+	p.sampleRate = 0
+	p.channels = 0
+	p.mediaOnly = false
+	return &p
+}
+
+// matches tells whether the rule converts the source at path
+func (r *converterRule) matches(path string) bool {
+	name := filepath.Base(path)
+	for _, pattern := range r.Match {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// readsStdin tells whether the command is fed the source, because none of
+// its arguments names it
+func (r *converterRule) readsStdin() bool {
+	for _, arg := range r.Command {
+		if strings.Contains(arg, "{input}") {
+			return false
+		}
+	}
+	return true
+}
+
+// command gives the command of the rule converting input
+func (r *converterRule) command(input string) (string, []string) {
+	if input == pipeInput {
+		input = "/dev/stdin"
+	}
+	args := make([]string, len(r.Command)-1)
+	for i, arg := range r.Command[1:] {
+		args[i] = strings.ReplaceAll(arg, "{input}", input)
+	}
+	return r.Command[0], args
+}
@@ -0,0 +1,86 @@
+package codecfs
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// Whether encoders run cut off from the network and with a clean
+// environment, since they parse untrusted media. Remote sources are then
+// fed to them.
+var sandbox bool
+
+// Command encoders are run under, as in "bwrap --ro-bind / / --dev /dev
+// --unshare-net --die-with-parent --", or empty to run them directly
+var sandboxCommand string
+
+// sandboxed tells whether encoders run in a sandbox
+func sandboxed() bool {
+	return sandbox || sandboxCommand != ""
+}
+
+// encoderCommand gives the command running the encoder name with args,
+// under the sandbox if there is one
+func encoderCommand(name string, args ...string) *exec.Cmd {
+	return encoderCommandContext(context.Background(), name, args...)
+}
+
+// encoderCommandContext is encoderCommand for a command killed once ctx is
+// done
+func encoderCommandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	if !sandboxed() {
+		return exec.CommandContext(ctx, name, args...)
+	}
+	wrapper := strings.Fields(sandboxCommand)
+	if len(wrapper) > 0 {
+		name, args = wrapper[0], append(append(wrapper[1:len(wrapper):len(wrapper)], name), args...)
+	}
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = cleanEnv()
+	if sandbox {
+		isolate(cmd)
+	}
+	return cmd
+}
+
+// Variables encoders are given in the sandbox
+var sandboxEnv = []string{"PATH", "LANG", "LC_ALL", "TMPDIR"}
+
+// cleanEnv gives the environment of sandboxed encoders: only what they need
+// to be found and to read names, and no credentials of remote sources
+func cleanEnv() []string {
+	env := []string{"HOME=/nonexistent"}
+	for _, name := range sandboxEnv {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+// remoteInput tells whether ffmpeg would fetch input over the network
+func remoteInput(input string) bool {
+	return strings.Contains(input, "://")
+}
+
+// checkSandbox tells whether encoders can run in the sandbox, by running
+// ffmpeg in it
+func checkSandbox() error {
+	if sandbox {
+		if err := isolationAvailable(); err != nil {
+			return err
+		}
+	}
+	out, err := encoderCommand("ffmpeg", "-hide_banner", "-version").CombinedOutput()
+	if err != nil {
+		if len(out) > 0 {
+			return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+		}
+		return err
+	}
+	return nil
+}
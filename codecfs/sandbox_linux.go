@@ -0,0 +1,22 @@
+package codecfs
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// isolate runs cmd in user and network namespaces of its own, where it keeps
+// our identity but has no network beyond a loopback that is down
+func isolate(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER | syscall.CLONE_NEWNET
+	cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}}
+	cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}}
+}
+
+func isolationAvailable() error {
+	return nil
+}
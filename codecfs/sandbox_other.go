@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package codecfs
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func isolate(cmd *exec.Cmd) {}
+
+func isolationAvailable() error {
+	return errors.New("-sandbox needs Linux, use -sandbox-command")
+}
@@ -0,0 +1,173 @@
+package codecfs
+
+import (
+	"io"
+	"log/slog"
+	"time"
+
+	"bazil.org/fuse"
+	"golang.org/x/net/context"
+)
+
+// Reads further than that much playback time after what the encoder
+// produced make it start again from the matching timestamp, rather than
+// waiting for everything in between to be encoded
+var seekDistance = 30 * time.Second
+
+// When not 0, only that many bytes of encoder output before the end of the
+// last read are kept, so memory stays flat for players reading sequentially.
+// Going back further encodes the file again.
+var readWindow int64
+
+// A segment is the output of an encoder, buffered from some offset of the
+// transcoded file
+type segment struct {
+	// Offset in the file of the first byte produced by the encoder
+	base int64
+	// Offset in the file of the first byte still buffered
+	offset int64
+	// Timestamp in the source the encoder started at
+	start time.Duration
+
+	transcode *transcode
+	buffer    spillBuffer
+	// Whether the encoder finished successfully
+	done    bool
+	retries int
+	// Whether the encoder was killed because a read was interrupted
+	interrupted bool
+}
+
+// end gives the offset in the file right after the last buffered byte
+func (s *segment) end() int64 {
+	return s.offset + int64(s.buffer.Len())
+}
+
+// trim drops buffered bytes before offset upTo
+func (s *segment) trim(upTo int64) {
+	if upTo <= s.offset {
+		return
+	}
+	n := upTo - s.offset
+	if n > int64(s.buffer.Len()) {
+		n = int64(s.buffer.Len())
+	}
+	s.buffer.Next(int(n))
+	s.offset += n
+}
+
+// restart encodes the segment again from its beginning, for reads of bytes
+// that were trimmed. The output is bit-exact, so the bytes are the same as
+// the first time.
+func (s *segment) restart() error {
+	s.close()
+	t, err := startTranscode(s.transcode.source, s.transcode.profile, s.start)
+	if err != nil {
+		return err
+	}
+	slog.Debug("Transcode restarted", "source", t.source, "profile", t.profile.name, "offset", s.base)
+	s.transcode = t
+	s.offset = s.base
+	s.done = false
+	s.retries = 0
+	s.interrupted = false
+	return nil
+}
+
+// close stops the encoder if it is still running, and reaps it
+func (s *segment) close() {
+	if !s.done {
+		// Nobody is going to read the rest
+		s.transcode.cancel()
+	}
+	s.transcode.wait()
+	s.buffer.Reset()
+}
+
+// fill reads from the encoder until the segment reaches offset end or the
+// encoder is done
+func (s *segment) fill(ctx context.Context, end int64) error {
+	if s.interrupted && s.end() < end {
+		// The rest of the output went with the encoder
+		if err := s.restart(); err != nil {
+			return err
+		}
+	}
+	for !s.done && s.end() < end {
+		n := end - s.end()
+		if readWindow > 0 && n > readWindow {
+			n = readWindow
+		}
+		err := s.copy(ctx, n)
+		if readWindow > 0 {
+			s.trim(end - readWindow)
+		}
+		if err == nil {
+			continue
+		} else if err != io.EOF {
+			return err
+		}
+
+		// The encoder is done, make sure it went well rather than
+		// serving a truncated file
+		if err := s.transcode.wait(); err == nil {
+			s.done = true
+		} else if !s.retry(ctx) {
+			if ctx.Err() != nil {
+				return fuse.EINTR
+			}
+			return fuse.EIO
+		}
+	}
+	return nil
+}
+
+// copy reads n bytes from the encoder into the buffer. If ctx is done in
+// the meantime, the encoder is killed and reaped so the read doesn't stay
+// stuck on the pipe.
+func (s *segment) copy(ctx context.Context, n int64) error {
+	t := s.transcode
+	stop := make(chan struct{})
+	killed := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			t.cancel()
+			killed <- true
+		case <-stop:
+			killed <- false
+		}
+	}()
+	_, err := io.CopyN(&s.buffer, t, n)
+	close(stop)
+	if <-killed {
+		t.wait()
+		s.interrupted = true
+		return fuse.EINTR
+	}
+	return err
+}
+
+// retry starts the encoder again after a failure, if that's possible
+func (s *segment) retry(ctx context.Context) bool {
+	// The output of a new run can't be spliced onto what was already
+	// served, so only failures happening before any output, like a source
+	// that can't be read, are worth retrying
+	t := s.transcode
+	if s.end() > s.base || s.retries >= transcodeRetries || t.timedOut.Load() || brokenSource(t.source) {
+		return false
+	}
+	select {
+	case <-time.After(retryBackoff << uint(s.retries)):
+	case <-ctx.Done():
+		return false
+	}
+	s.retries++
+	slog.Info("Retrying transcode", "source", t.source, "profile", t.profile.name, "attempt", s.retries)
+	next, err := startTranscode(t.source, t.profile, s.start)
+	if err != nil {
+		return false
+	}
+	s.transcode = next
+	return true
+}
@@ -0,0 +1,155 @@
+package codecfs
+
+import (
+	"encoding/binary"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Exact sizes are learnt by transcoding files to the end. They are kept in
+// memory in allSizes, and in this bolt database so that they survive
+// restarts. Empty to keep them in memory only.
+var sizeCachePath string
+
+// defaultSizeCachePath gives where sizes are kept unless told otherwise
+func defaultSizeCachePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "codecfs", "sizes.db")
+}
+
+var sizeDB *bolt.DB
+
+var sizeBucket = []byte("sizes")
+
+// sizeKey identifies a file of the tree in allSizes
+type sizeKey struct {
+	profile string
+	name    string
+}
+
+// knownSize is the exact size of a file, transcoded from a version of its
+// source
+type knownSize struct {
+	version sourceVersion
+	size    uint64
+}
+
+// openSizeCache opens the database at sizeCachePath, if any. It is only a
+// cache: when it can't be opened, e.g. because another mount holds it,
+// sizes are kept in memory.
+func openSizeCache() {
+	if sizeCachePath == "" {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(sizeCachePath), 0755); err != nil {
+		slog.Warn("Can't open size cache", "path", sizeCachePath, "err", err)
+		return
+	}
+	db, err := bolt.Open(sizeCachePath, 0644, &bolt.Options{Timeout: time.Second})
+	if err == nil {
+		err = db.Update(func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(sizeBucket)
+			return err
+		})
+	}
+	if err != nil {
+		slog.Warn("Can't open size cache", "path", sizeCachePath, "err", err)
+		if db != nil {
+			db.Close()
+		}
+		return
+	}
+	sizeDB = db
+}
+
+// persistKey is the key of the size of source encoded by p in the database.
+// The encoder command stands for the profile, so that sizes are found again
+// whatever the name of the tree, and not once the settings change.
+func persistKey(source string, p *profile) []byte {
+	name, args := p.command(source, "", 0)
+	return []byte(source + "\x00" + name + " " + strings.Join(args, " "))
+}
+
+// persistedSize gives the size of source encoded by p, as stored for that
+// version of the source
+func persistedSize(source string, version sourceVersion, p *profile) (uint64, bool) {
+	if sizeDB == nil {
+		return 0, false
+	}
+	var size uint64
+	var ok bool
+	sizeDB.View(func(tx *bolt.Tx) error {
+		// Sources can be replaced without their mtime changing, so
+		// entries without the size of the source are stale too
+		v := tx.Bucket(sizeBucket).Get(persistKey(source, p))
+		if len(v) == 24 && int64(binary.BigEndian.Uint64(v)) == version.mtime.UnixNano() && int64(binary.BigEndian.Uint64(v[8:])) == version.size {
+			size, ok = binary.BigEndian.Uint64(v[16:]), true
+		}
+		return nil
+	})
+	return size, ok
+}
+
+// storeSize remembers the exact size of the file name of the tree of the
+// profile of t, as produced by t
+func storeSize(name string, t *transcode, size uint64) {
+	if t.version == (sourceVersion{}) {
+		// The source couldn't be told apart from the next version
+		return
+	}
+	allSizes.Store(sizeKey{t.profile.name, name}, knownSize{t.version, size})
+	persistSize(t.source, t.version, t.profile, size)
+}
+
+// persistSize stores the size of that version of source encoded by p in the
+// database
+func persistSize(source string, version sourceVersion, p *profile, size uint64) {
+	if sizeDB == nil {
+		return
+	}
+	v := binary.BigEndian.AppendUint64(nil, uint64(version.mtime.UnixNano()))
+	v = binary.BigEndian.AppendUint64(v, uint64(version.size))
+	v = binary.BigEndian.AppendUint64(v, size)
+	err := sizeDB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sizeBucket).Put(persistKey(source, p), v)
+	})
+	if err != nil {
+		slog.Warn("Can't store size", "source", source, "err", err)
+	}
+}
+
+// clearPersistedSizes empties the database
+func clearPersistedSizes() {
+	if sizeDB == nil {
+		return
+	}
+	err := sizeDB.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(sizeBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket(sizeBucket)
+		return err
+	})
+	if err != nil {
+		slog.Warn("Can't clear size cache", "err", err)
+	}
+}
+
+// dropSizes forgets the sizes of the files named name in every tree
+func dropSizes(name string) {
+	allSizes.Range(func(k, v interface{}) bool {
+		if key := k.(sizeKey); key.name == name {
+			allSizes.Delete(k)
+			emit(Event{Type: eventCacheEvicted, Profile: key.profile, Name: name})
+		}
+		return true
+	})
+}
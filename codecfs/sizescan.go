@@ -0,0 +1,237 @@
+package codecfs
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"bazil.org/fuse"
+)
+
+// How long the scan waits between passes over the tree, and before looking
+// again whether readers are done
+const (
+	sizeScanInterval = time.Hour
+	sizeScanIdle     = 10 * time.Second
+)
+
+// scanSizes transcodes every file of the trees of root whose size isn't
+// known yet, one at a time and with the lowest priority, so that Attr
+// eventually gives exact sizes everywhere. Each file waits for readers to be
+// done with their transcodes, and its size goes to the size cache.
+func scanSizes(root *Root) {
+	for {
+		start := time.Now()
+		measured := 0
+		for _, p := range root.trees() {
+			if !p.passthrough {
+				measured += scanDir(root.dir, p.forDir(root.dir))
+			}
+		}
+		slog.Info("Size scan done", "measured", measured, "duration", time.Since(start))
+		time.Sleep(sizeScanInterval)
+	}
+}
+
+// scanDir measures the files below dir, and gives how many were
+func scanDir(dir string, p *profile) int {
+	names, err := p.root.src.ReadDirNames(dir)
+	if err != nil {
+		slog.Warn("Size scan can't list directory", "dir", dir, "err", err)
+		return 0
+	}
+	sort.Strings(names)
+
+	measured := 0
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		stat, err := p.root.statSource(path)
+		if err != nil || p.hidden(name, stat.IsDir()) {
+			continue
+		}
+		if stat.IsDir() {
+			measured += scanDir(path, p.forDir(path))
+			continue
+		}
+		if _, ok := persistedSize(path, versionOf(stat), p); ok || brokenSource(path) || !p.transcodes(path) {
+			continue
+		}
+		for transcodesRunning() {
+			time.Sleep(sizeScanIdle)
+		}
+		if _, err := measureSize(path, p, true); err == nil {
+			measured++
+		}
+	}
+	return measured
+}
+
+// transcodesRunning tells whether there are encoders running
+func transcodesRunning() bool {
+	running := false
+	transcodes.Range(func(k, v interface{}) bool {
+		running = true
+		return false
+	})
+	return running
+}
+
+// A measurement is a transcode run to learn the size of its output
+type measurement struct {
+	done chan struct{}
+	size uint64
+	err  error
+}
+
+// Running measurements, by persistKey
+var measurements sync.Map
+
+// measureSize transcodes source to the end and stores the size of the
+// output. Concurrent measurements of the same file share the transcode. In
+// the background, the encoder runs with the lowest priority.
+func measureSize(source string, p *profile, background bool) (uint64, error) {
+	key := string(persistKey(source, p))
+	m := &measurement{done: make(chan struct{})}
+	if v, loaded := measurements.LoadOrStore(key, m); loaded {
+		m = v.(*measurement)
+		<-m.done
+		return m.size, m.err
+	}
+	defer func() {
+		measurements.Delete(key)
+		close(m.done)
+	}()
+
+	t, err := startTranscode(source, p, 0)
+	if err != nil {
+		slog.Warn("Can't measure size", "source", source, "err", err)
+		m.err = err
+		return 0, err
+	}
+	if background {
+		lowerPriority(t.cmd.Process.Pid)
+	}
+	size, err := io.Copy(io.Discard, t)
+	if werr := t.wait(); err == nil {
+		err = werr
+	}
+	if err != nil {
+		m.err = err
+		return 0, err
+	}
+	slog.Debug("Size measured", "source", source, "profile", p.name, "size", size)
+	m.size = uint64(size)
+	if t.version != (sourceVersion{}) {
+		persistSize(source, t.version, p, m.size)
+	}
+	return m.size, nil
+}
+
+// Paths waiting to be warmed, in order, the first one being warmed
+var (
+	warmMu    sync.Mutex
+	warmQueue []warmJob
+)
+
+type warmJob struct {
+	path string
+	// measure measures the files of the job, and gives how many were
+	measure func() int
+}
+
+// warm queues the files under the slash-separated path p of the first
+// mount that has it to be measured in the background, with the lowest
+// priority. The sizes measured are only kept in the size cache, so there
+// must be one.
+func warm(ctx context.Context, p string) error {
+	if sizeDB == nil {
+		return errNoSizeCache
+	}
+	for _, root := range roots() {
+		n, err := lookupPath(ctx, root, p)
+		if err != nil {
+			continue
+		}
+		job := warmJob{path: p}
+		switch n := n.(type) {
+		case *dir:
+			if n.profile.passthrough {
+				return errNothingToWarm
+			}
+			job.measure = func() int {
+				return scanDir(n.dir, n.profile)
+			}
+		case *file:
+			if n.name == n.source {
+				return errNothingToWarm
+			}
+			job.measure = func() int {
+				for transcodesRunning() {
+					time.Sleep(sizeScanIdle)
+				}
+				if _, err := measureSize(n.source, n.profile, true); err != nil {
+					return 0
+				}
+				return 1
+			}
+		default:
+			return errNothingToWarm
+		}
+
+		warmMu.Lock()
+		defer warmMu.Unlock()
+		warmQueue = append(warmQueue, job)
+		if len(warmQueue) == 1 {
+			go warmQueued()
+		}
+		slog.Info("Warming queued", "path", p)
+		return nil
+	}
+	return fuse.ENOENT
+}
+
+// warmQueued runs the jobs of warmQueue until it is empty
+func warmQueued() {
+	for {
+		warmMu.Lock()
+		job := warmQueue[0]
+		warmMu.Unlock()
+
+		start := time.Now()
+		measured := job.measure()
+		slog.Info("Warming done", "path", job.path, "measured", measured, "duration", time.Since(start))
+
+		warmMu.Lock()
+		warmQueue = warmQueue[1:]
+		done := len(warmQueue) == 0
+		warmMu.Unlock()
+		if done {
+			return
+		}
+	}
+}
+
+// queuedWarms gives the paths waiting to be warmed, the first one being
+// warmed
+func queuedWarms() []string {
+	warmMu.Lock()
+	defer warmMu.Unlock()
+	paths := make([]string, len(warmQueue))
+	for i, job := range warmQueue {
+		paths[i] = job.path
+	}
+	return paths
+}
+
+// errNothingToWarm is returned by warm for paths without transcoded files
+var errNothingToWarm = errors.New("nothing is transcoded there")
+
+// errNoSizeCache is returned by warm when there's no size cache to keep the
+// sizes in
+var errNoSizeCache = errors.New("warming needs a size cache")
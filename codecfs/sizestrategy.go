@@ -0,0 +1,102 @@
+package codecfs
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ways of reporting the size of transcoded files until their exact size is
+// known:
+//
+//   - inflate: 10 times the size of the source, so that readers relying on
+//     the size don't stop early with lossy codecs
+//   - source: the size of the source
+//   - estimate: the duration of the source times the bitrate of the
+//     profile, falling back to inflate when either is unknown
+//   - huge: hugeSize, for clients that read until EOF anyway
+//   - exact: transcode the whole file in Attr to learn its size, which
+//     blocks the caller for as long as it takes
+var sizeStrategies = []string{"inflate", "source", "estimate", "huge", "exact"}
+
+// Reported by the huge strategy, larger than any audio file
+const hugeSize = 1 << 40
+
+func validSizeStrategy(s string) error {
+	for _, known := range sizeStrategies {
+		if s == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown size strategy %q, must be one of %s", s, strings.Join(sizeStrategies, ", "))
+}
+
+// guessSize gives the size of file f according to the SizeStrategy of its
+// root, when its exact size isn't known. stat is the stat of the source.
+func (f *file) guessSize(stat os.FileInfo) (uint64, bool) {
+	switch f.profile.root.opts.SizeStrategy {
+	case "source":
+		return uint64(stat.Size()), false
+	case "estimate":
+		if rate := f.profile.byteRate(); rate > 0 {
+			if d, ok := f.duration(); ok {
+				return uint64(d.Seconds() * float64(rate)), false
+			}
+		}
+	case "huge":
+		return hugeSize, false
+	case "exact":
+		if size, err := measureSize(f.source, f.profile, false); err == nil {
+			allSizes.Store(sizeKey{f.profile.name, f.name}, knownSize{versionOf(stat), size})
+			return size, true
+		}
+	}
+
+	// We lie about the size. In a typical usecase we do lossy encodes, so
+	// the output size should be smaller than the input size. By making
+	// the fake size bigger, we should make everyone happy.
+	return 10 * uint64(stat.Size()), false
+}
+
+// duration gives how long the audio of f plays. Encoders pad their output
+// and give the padding in the headers of ogg and opus, so that it plays
+// exactly as long as the source.
+func (f *file) duration() (time.Duration, bool) {
+	if f.profile.clipEnd > 0 {
+		return f.profile.clipEnd - f.profile.clipStart, true
+	}
+	stat, err := f.profile.root.statSource(f.source)
+	if err != nil {
+		return 0, false
+	}
+	return f.profile.root.sourceDuration(f.source, stat.ModTime())
+}
+
+type durationEntry struct {
+	mtime    time.Time
+	duration time.Duration
+	ok       bool
+}
+
+// Durations of sources, by path
+var durations sync.Map
+
+// sourceDuration gives the duration of the source at path in r, which was
+// modified at mtime, as found by ffprobe
+func (r *Root) sourceDuration(path string, mtime time.Time) (time.Duration, bool) {
+	if v, ok := durations.Load(path); ok && v.(durationEntry).mtime.Equal(mtime) {
+		return v.(durationEntry).duration, v.(durationEntry).ok
+	}
+	entry := durationEntry{mtime: mtime}
+	if out, err := r.probe(path, "-v", "error", "-show_entries", "format=duration", "-of", "default=noprint_wrappers=1:nokey=1"); err == nil {
+		if secs, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64); err == nil {
+			entry.duration = time.Duration(secs * float64(time.Second))
+			entry.ok = true
+		}
+	}
+	durations.Store(path, entry)
+	return entry.duration, entry.ok
+}
@@ -0,0 +1,165 @@
+package codecfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// A sourceFS is where the exposed files come from. Paths are absolute and
+// slash-separated. The FUSE nodes only go through the sourceFS of their
+// Root, so a new backend only has to implement this.
+type sourceFS interface {
+	Open(path string) (sourceFile, error)
+	Stat(path string) (os.FileInfo, error)
+	Lstat(path string) (os.FileInfo, error)
+	// ReadDirNames gives the names of the entries of a directory
+	ReadDirNames(path string) ([]string, error)
+	// Input gives what ffmpeg reads path from, or "" when ffmpeg must be
+	// fed the file
+	Input(path string) string
+}
+
+// A sourceFile is a file opened in the source. Files that can't be read at
+// random offsets with io.ReaderAt are read sequentially, see randomAccess.
+type sourceFile interface {
+	io.ReadCloser
+}
+
+// A randomFile reads a source file at any offset
+type randomFile interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// randomAccess gives f, opened at path in src, as a randomFile
+func randomAccess(src sourceFS, path string, f sourceFile) randomFile {
+	if r, ok := f.(randomFile); ok {
+		return r
+	}
+	return &sequentialFile{src: src, path: path, file: f}
+}
+
+// sequentialFile reads a file without ReadAt, by skipping forward and
+// reopening it to go backward. Native files are mostly read in order, so it
+// seldom reopens.
+type sequentialFile struct {
+	src  sourceFS
+	path string
+
+	mu     sync.Mutex
+	file   sourceFile
+	offset int64
+}
+
+func (f *sequentialFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if off < f.offset {
+		file, err := f.src.Open(f.path)
+		if err != nil {
+			return 0, err
+		}
+		f.file.Close()
+		f.file = file
+		f.offset = 0
+	}
+	if off > f.offset {
+		skipped, err := io.CopyN(io.Discard, f.file, off-f.offset)
+		f.offset += skipped
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := io.ReadFull(f.file, p)
+	f.offset += int64(n)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *sequentialFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
+
+// openSource opens input merged with the sources of union, and gives the
+// path of the root in it. Only local sources can be watched.
+func openSource(input string, union []string, archives bool) (src sourceFS, root string, local bool, err error) {
+	src, root, local, err = openBackend(input)
+	if err == nil && len(union) > 0 {
+		branches := []unionBranch{{src, root}}
+		for _, input := range union {
+			b, root, _, err := openBackend(input)
+			if err != nil {
+				return nil, "", false, fmt.Errorf("%s: %v", input, err)
+			}
+			branches = append(branches, unionBranch{b, root})
+		}
+		src, root, local = unionFS(branches), "/", false
+	}
+	if err == nil && archives {
+		src = archiveFS{src}
+	}
+	return src, root, local, err
+}
+
+// localSource reports whether the paths of src are paths of the local
+// filesystem
+func localSource(src sourceFS) bool {
+	if a, ok := src.(archiveFS); ok {
+		src = a.sourceFS
+	}
+	_, ok := src.(localFS)
+	return ok
+}
+
+// openBackend opens input, a local directory, a remote index, an s3://
+// bucket or an sftp:// server, and gives the path of the root in it
+func openBackend(input string) (sourceFS, string, bool, error) {
+	if strings.HasPrefix(input, "http://") || strings.HasPrefix(input, "https://") {
+		src, err := newHTTPFS(input)
+		return src, "/", false, err
+	}
+	if strings.HasPrefix(input, "s3://") {
+		src, err := newS3FS(input)
+		return src, "/", false, err
+	}
+	if strings.HasPrefix(input, "sftp://") {
+		src, root, err := openSFTP(input)
+		return src, root, false, err
+	}
+	return localFS{}, input, true, nil
+}
+
+// localFS is the local filesystem
+type localFS struct{}
+
+func (localFS) Open(path string) (sourceFile, error) {
+	return os.Open(path)
+}
+
+func (localFS) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(path)
+}
+
+func (localFS) Lstat(path string) (os.FileInfo, error) {
+	return os.Lstat(path)
+}
+
+func (localFS) ReadDirNames(path string) ([]string, error) {
+	dir, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	return dir.Readdirnames(-1)
+}
+
+func (localFS) Input(path string) string {
+	return path
+}
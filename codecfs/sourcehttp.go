@@ -0,0 +1,294 @@
+package codecfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client of remote sources. Servers that don't answer make requests fail
+// after a while instead of holding up the readers waiting on them, and
+// stalled downloads are given up on eventually; the bound on whole
+// requests is generous, since servers ignoring ranges send whole files.
+var sourceClient = &http.Client{
+	Timeout: 10 * time.Minute,
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   30 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+	},
+}
+
+// Client of the files encoders read from start to end, in a single request
+// going as fast as the encoder does, which can be slower than sourceClient
+// lets whole requests take
+var streamClient = &http.Client{Transport: sourceClient.Transport}
+
+// httpFS reads the source from a web server exposing directory indexes, like
+// the autoindex pages of nginx or Apache. Files are streamed, or read with
+// range requests at random offsets, and ffmpeg reads them directly from
+// their URL.
+type httpFS struct {
+	base *url.URL
+	// Paths seen as directories in indexes
+	dirs sync.Map
+}
+
+func newHTTPFS(base string) (*httpFS, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	return &httpFS{base: u}, nil
+}
+
+// url gives the URL of the source path p
+func (h *httpFS) url(p string) string {
+	ref := &url.URL{Path: strings.TrimPrefix(p, "/")}
+	return h.base.ResolveReference(ref).String()
+}
+
+func (h *httpFS) Input(p string) string {
+	return h.url(p)
+}
+
+func (h *httpFS) Stat(p string) (os.FileInfo, error) {
+	if p == "/" {
+		return remoteInfo{name: "/", dir: true}, nil
+	}
+	if _, ok := h.dirs.Load(p); ok {
+		return remoteInfo{name: path.Base(p), dir: true}, nil
+	}
+	resp, err := sourceClient.Head(h.url(p))
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: p, Err: err}
+	}
+	resp.Body.Close()
+	if err := httpError(resp); err != nil {
+		return nil, &os.PathError{Op: "stat", Path: p, Err: err}
+	}
+	mtime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if indexPage(resp, p) {
+		h.dirs.Store(p, true)
+		return remoteInfo{name: path.Base(p), dir: true, mtime: mtime}, nil
+	}
+	return remoteInfo{name: path.Base(p), size: resp.ContentLength, mtime: mtime}, nil
+}
+
+// indexPage tells whether resp, the answer to a request for the source path
+// p, is the index of a directory: servers redirect directories to their
+// URL with a trailing slash, and give their index as HTML. HTML files of
+// the source are told apart by their extension.
+func indexPage(resp *http.Response, p string) bool {
+	if strings.HasSuffix(resp.Request.URL.Path, "/") {
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	ext := strings.ToLower(path.Ext(p))
+	return mediaType == "text/html" && ext != ".html" && ext != ".htm"
+}
+
+func (h *httpFS) Lstat(p string) (os.FileInfo, error) {
+	return h.Stat(p)
+}
+
+// Links of index pages
+var hrefPattern = regexp.MustCompile(`(?i)href="([^"]+)"`)
+
+func (h *httpFS) ReadDirNames(p string) ([]string, error) {
+	resp, err := sourceClient.Get(strings.TrimSuffix(h.url(p), "/") + "/")
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: p, Err: err}
+	}
+	defer resp.Body.Close()
+	if err := httpError(resp); err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: p, Err: err}
+	}
+	page, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	seen := make(map[string]bool)
+	for _, m := range hrefPattern.FindAllSubmatch(page, -1) {
+		link, err := url.Parse(string(m[1]))
+		// Only relative links to direct children are entries, the
+		// others are sorting links, parents and the like
+		if err != nil || link.IsAbs() || link.RawQuery != "" || link.Path == "" || strings.HasPrefix(link.Path, "/") || strings.HasPrefix(link.Path, ".") {
+			continue
+		}
+		name := strings.TrimSuffix(link.Path, "/")
+		if strings.Contains(name, "/") || seen[name] {
+			continue
+		}
+		seen[name] = true
+		if strings.HasSuffix(link.Path, "/") {
+			h.dirs.Store(path.Join(p, name), true)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (h *httpFS) Open(p string) (sourceFile, error) {
+	stat, err := h.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if stat.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: p, Err: errors.New("is a directory")}
+	}
+	return &httpFile{url: h.url(p), size: stat.Size()}, nil
+}
+
+// httpFile reads a remote file. After a first Read of its own, Read
+// streams the rest of it with a single request, and ReadAt sends range
+// requests.
+type httpFile struct {
+	url    string
+	size   int64
+	offset int64
+	// Body of the request of Read, nil until it is sent
+	body io.ReadCloser
+}
+
+func (f *httpFile) Read(p []byte) (int, error) {
+	if f.body == nil && f.offset == 0 {
+		// Sniffing only reads the start of files, which must not
+		// download them whole
+		n, err := f.ReadAt(p, 0)
+		f.offset += int64(n)
+		if err == io.EOF && n > 0 {
+			err = nil
+		}
+		return n, err
+	}
+	if f.body == nil {
+		if f.size >= 0 && f.offset >= f.size {
+			return 0, io.EOF
+		}
+		if err := f.stream(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+// stream sends the request of Read, for the file from its offset to its end
+func (f *httpFile) stream() error {
+	req, err := http.NewRequest(http.MethodGet, f.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", f.offset))
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		return err
+	}
+	if err := httpError(resp); err != nil {
+		resp.Body.Close()
+		return err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		// The server ignores ranges, skip to the offset
+		if _, err := io.CopyN(io.Discard, resp.Body, f.offset); err != nil {
+			resp.Body.Close()
+			return err
+		}
+	}
+	f.body = resp.Body
+	return nil
+}
+
+func (f *httpFile) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if f.size >= 0 && off >= f.size {
+		return 0, io.EOF
+	}
+	req, err := http.NewRequest(http.MethodGet, f.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1))
+	resp, err := sourceClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if err := httpError(resp); err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		// The server ignores ranges, skip to the offset
+		if _, err := io.CopyN(io.Discard, resp.Body, off); err != nil {
+			return 0, err
+		}
+	}
+	n, err := io.ReadFull(resp.Body, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *httpFile) Close() error {
+	if f.body == nil {
+		return nil
+	}
+	return f.body.Close()
+}
+
+// httpError gives the error matching an unsuccessful response
+func httpError(resp *http.Response) error {
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return fs.ErrNotExist
+	case resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusUnauthorized:
+		return fs.ErrPermission
+	case resp.StatusCode >= 400:
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+// remoteInfo describes a remote file. Remote files are read-only.
+type remoteInfo struct {
+	name  string
+	size  int64
+	mtime time.Time
+	dir   bool
+}
+
+func (fi remoteInfo) Name() string       { return fi.name }
+func (fi remoteInfo) Size() int64        { return fi.size }
+func (fi remoteInfo) ModTime() time.Time { return fi.mtime }
+func (fi remoteInfo) IsDir() bool        { return fi.dir }
+func (fi remoteInfo) Sys() interface{}   { return nil }
+
+func (fi remoteInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0555
+	}
+	return 0444
+}
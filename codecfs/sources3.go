@@ -0,0 +1,219 @@
+package codecfs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"golang.org/x/net/context"
+)
+
+// s3FS reads the source from a bucket of an S3-compatible object storage,
+// given as s3://bucket/prefix. Keys are split on slashes to make directories.
+//
+// It is configured like the AWS tools, from AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN, AWS_REGION and AWS_ENDPOINT_URL
+// for other providers like MinIO. Without credentials, the bucket is read
+// anonymously. Requests use path-style addressing. Objects are fed to
+// ffmpeg, so that no URL carrying credentials shows up in its arguments.
+type s3FS struct {
+	client *minio.Client
+	bucket string
+	prefix string
+
+	// Stats learnt from listings, as s3Stats by path. They are dropped
+	// once stale, so that changes to the bucket show up.
+	stats     sync.Map
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// How long requests for stats and listings are given, and how long the
+// stats learnt from listings are trusted for
+const (
+	s3Timeout  = 30 * time.Second
+	s3StatsTTL = time.Minute
+)
+
+type s3Stat struct {
+	info    remoteInfo
+	expires time.Time
+}
+
+func newS3FS(input string) (*s3FS, error) {
+	u, err := url.Parse(input)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, errors.New("missing bucket")
+	}
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL")
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	e, err := url.Parse(endpoint)
+	if err != nil || e.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint %q", endpoint)
+	}
+	client, err := minio.New(e.Host, &minio.Options{
+		Creds:        credentials.NewStaticV4(os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_SESSION_TOKEN")),
+		Secure:       e.Scheme != "http",
+		Region:       region,
+		BucketLookup: minio.BucketLookupPath,
+		Transport:    sourceClient.Transport,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s3FS{
+		client: client,
+		bucket: u.Host,
+		prefix: strings.Trim(u.Path, "/"),
+	}, nil
+}
+
+// objectKey gives the key of the source path p
+func (s *s3FS) objectKey(p string) string {
+	return strings.TrimPrefix(path.Join(s.prefix, p), "/")
+}
+
+func (s *s3FS) Input(p string) string {
+	return ""
+}
+
+func (s *s3FS) Stat(p string) (os.FileInfo, error) {
+	if p == "/" {
+		return remoteInfo{name: "/", dir: true}, nil
+	}
+	if v, ok := s.stats.Load(p); ok {
+		if stat := v.(s3Stat); time.Now().Before(stat.expires) {
+			return stat.info, nil
+		}
+		s.stats.CompareAndDelete(p, v)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+	obj, err := s.client.StatObject(ctx, s.bucket, s.objectKey(p), minio.StatObjectOptions{})
+	if err == nil {
+		return remoteInfo{name: path.Base(p), size: obj.Size, mtime: obj.LastModified}, nil
+	}
+	if minio.ToErrorResponse(err).StatusCode != http.StatusNotFound {
+		return nil, &os.PathError{Op: "stat", Path: p, Err: s3Error(err)}
+	}
+	// There are no directory objects, only common key prefixes
+	names, err := s.list(p, 1)
+	if err != nil || len(names) == 0 {
+		return nil, &os.PathError{Op: "stat", Path: p, Err: fs.ErrNotExist}
+	}
+	return remoteInfo{name: path.Base(p), dir: true}, nil
+}
+
+func (s *s3FS) Lstat(p string) (os.FileInfo, error) {
+	return s.Stat(p)
+}
+
+func (s *s3FS) ReadDirNames(p string) ([]string, error) {
+	names, err := s.list(p, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: p, Err: err}
+	}
+	return names, nil
+}
+
+// list gives the names of the entries under p, at most max of them unless
+// max is 0. The stats of the entries are remembered for Stat.
+func (s *s3FS) list(p string, max int) ([]string, error) {
+	s.sweep()
+	prefix := s.objectKey(p)
+	if prefix != "" {
+		prefix += "/"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+	expires := time.Now().Add(s3StatsTTL)
+	var names []string
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix}) {
+		if obj.Err != nil {
+			return nil, s3Error(obj.Err)
+		}
+		name := strings.TrimPrefix(obj.Key, prefix)
+		info := remoteInfo{name: strings.TrimSuffix(name, "/"), size: obj.Size, mtime: obj.LastModified}
+		if strings.HasSuffix(name, "/") {
+			// A common prefix
+			info = remoteInfo{name: info.name, dir: true}
+		}
+		if info.name == "" {
+			continue
+		}
+		s.stats.Store(path.Join(p, info.name), s3Stat{info, expires})
+		names = append(names, info.name)
+		if max > 0 && len(names) >= max {
+			break
+		}
+	}
+	return names, nil
+}
+
+// sweep drops the stale stats, once in a while
+func (s *s3FS) sweep() {
+	s.mu.Lock()
+	now := time.Now()
+	due := now.Sub(s.lastSweep) > s3StatsTTL
+	if due {
+		s.lastSweep = now
+	}
+	s.mu.Unlock()
+	if !due {
+		return
+	}
+	s.stats.Range(func(k, v interface{}) bool {
+		if now.After(v.(s3Stat).expires) {
+			s.stats.CompareAndDelete(k, v)
+		}
+		return true
+	})
+}
+
+func (s *s3FS) Open(p string) (sourceFile, error) {
+	stat, err := s.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if stat.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: p, Err: errors.New("is a directory")}
+	}
+	obj, err := s.client.GetObject(context.Background(), s.bucket, s.objectKey(p), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: p, Err: s3Error(err)}
+	}
+	return obj, nil
+}
+
+// s3Error gives the error matching an error of the storage
+func s3Error(err error) error {
+	switch minio.ToErrorResponse(err).StatusCode {
+	case http.StatusNotFound:
+		return fs.ErrNotExist
+	case http.StatusForbidden, http.StatusUnauthorized:
+		return fs.ErrPermission
+	}
+	return err
+}
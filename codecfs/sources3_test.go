@@ -0,0 +1,169 @@
+package codecfs
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeS3 serves objects of a single bucket, as far as s3FS uses the API
+type fakeS3 struct {
+	bucket  string
+	objects map[string]string
+	mtime   time.Time
+}
+
+type fakeS3Listing struct {
+	XMLName        xml.Name `xml:"ListBucketResult"`
+	Name           string
+	Prefix         string
+	KeyCount       int
+	IsTruncated    bool
+	Contents       []fakeS3Object
+	CommonPrefixes []struct{ Prefix string }
+}
+
+type fakeS3Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	ETag         string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket {
+		f.fail(w, r, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	if key == "" {
+		f.list(w, r.URL.Query().Get("prefix"), r.URL.Query().Get("delimiter"))
+		return
+	}
+	data, ok := f.objects[key]
+	if !ok {
+		f.fail(w, r, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	w.Header().Set("ETag", `"etag"`)
+	http.ServeContent(w, r, key, f.mtime, strings.NewReader(data))
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, prefix, delimiter string) {
+	listing := fakeS3Listing{Name: f.bucket, Prefix: prefix}
+	seen := map[string]bool{}
+	var keys []string
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		rest, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+		if i := strings.Index(rest, delimiter); delimiter != "" && i >= 0 {
+			if common := prefix + rest[:i+1]; !seen[common] {
+				seen[common] = true
+				listing.CommonPrefixes = append(listing.CommonPrefixes, struct{ Prefix string }{common})
+			}
+			continue
+		}
+		listing.Contents = append(listing.Contents, fakeS3Object{Key: key, Size: int64(len(f.objects[key])), LastModified: f.mtime, ETag: `"etag"`})
+	}
+	listing.KeyCount = len(listing.Contents) + len(listing.CommonPrefixes)
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(listing)
+}
+
+func (f *fakeS3) fail(w http.ResponseWriter, r *http.Request, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if r.Method != "HEAD" {
+		io.WriteString(w, "<Error><Code>"+code+"</Code></Error>")
+	}
+}
+
+func TestS3Source(t *testing.T) {
+	fake := &fakeS3{
+		bucket: "music",
+		objects: map[string]string{
+			"lib/a.flac":       "flac data",
+			"lib/album/b.flac": "more flac data",
+			"other/c.flac":     "elsewhere",
+		},
+		mtime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	t.Setenv("AWS_ENDPOINT_URL", srv.URL)
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+
+	s, err := newS3FS("s3://music/lib/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key := s.objectKey("/album/b.flac"); key != "lib/album/b.flac" {
+		t.Errorf("key %q", key)
+	}
+
+	names, err := s.ReadDirNames("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"a.flac", "album"}) {
+		t.Errorf("listed %q", names)
+	}
+
+	stat, err := s.Stat("/a.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.IsDir() || stat.Size() != int64(len("flac data")) || !stat.ModTime().Equal(fake.mtime) {
+		t.Errorf("stat of a.flac: dir %v, size %d, mtime %v", stat.IsDir(), stat.Size(), stat.ModTime())
+	}
+	if stat, err := s.Stat("/album"); err != nil || !stat.IsDir() {
+		t.Errorf("album isn't a directory: %v", err)
+	}
+	// Not learnt from a listing
+	if stat, err := s.Stat("/album/b.flac"); err != nil || stat.Size() != int64(len("more flac data")) {
+		t.Errorf("stat of album/b.flac: %v", err)
+	}
+	if _, err := s.Stat("/c.flac"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("stat of a missing object: %v", err)
+	}
+
+	f, err := s.Open("/album/b.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, ok := f.(randomFile)
+	if !ok {
+		t.Fatal("objects can't be read at any offset")
+	}
+	buf := make([]byte, 4)
+	if _, err := r.ReadAt(buf, 5); err != nil || !bytes.Equal(buf, []byte("flac")) {
+		t.Errorf("read %q at 5: %v", buf, err)
+	}
+	if _, err := s.Open("/album"); err == nil {
+		t.Error("opened a directory")
+	}
+}
+
+func TestNewS3FSNeedsBucket(t *testing.T) {
+	if _, err := newS3FS("s3:///prefix"); err == nil {
+		t.Error("no bucket accepted")
+	}
+}
@@ -0,0 +1,161 @@
+package codecfs
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/exec"
+	"sync"
+
+	"github.com/pkg/sftp"
+)
+
+// sftpFS reads the source from a server over SFTP, given as
+// sftp://user@host:port/path. It runs the ssh client with the sftp subsystem,
+// so authentication goes through the usual ssh keys, agent and config; it
+// must not need to ask for a password. ffmpeg reads the files from their
+// sftp:// URL, which takes an ffmpeg built with libssh.
+type sftpFS struct {
+	// The URL without its path
+	base *url.URL
+
+	mu     sync.Mutex
+	client *sftp.Client
+}
+
+// openSFTP connects to the server of input, and gives the path of the root
+// on it
+func openSFTP(input string) (*sftpFS, string, error) {
+	u, err := url.Parse(input)
+	if err != nil {
+		return nil, "", err
+	}
+	if u.Host == "" {
+		return nil, "", errors.New("missing host")
+	}
+	s := &sftpFS{base: &url.URL{Scheme: u.Scheme, User: u.User, Host: u.Host}}
+	c, err := s.connect()
+	if err != nil {
+		return nil, "", err
+	}
+	// Relative to the home directory when the URL has no path
+	root := u.Path
+	if root == "" {
+		root = "."
+	}
+	root, err = c.RealPath(root)
+	if err != nil {
+		return nil, "", err
+	}
+	return s, root, nil
+}
+
+// connect gives the client of the server, reconnecting when the previous
+// connection was lost
+func (s *sftpFS) connect() (*sftp.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil {
+		return s.client, nil
+	}
+	c, cmd, err := dialSFTP(s.base)
+	if err != nil {
+		return nil, err
+	}
+	s.client = c
+	go func() {
+		err := c.Wait()
+		slog.Warn("SFTP connection lost", "host", s.base.Host, "err", err)
+		cmd.Wait()
+		s.mu.Lock()
+		if s.client == c {
+			s.client = nil
+		}
+		s.mu.Unlock()
+	}()
+	return c, nil
+}
+
+// dialSFTP runs ssh with the sftp subsystem for the server of u
+func dialSFTP(u *url.URL) (*sftp.Client, *exec.Cmd, error) {
+	args := []string{"-o", "BatchMode=yes", "-s"}
+	if port := u.Port(); port != "" {
+		args = append(args, "-p", port)
+	}
+	host := u.Hostname()
+	if u.User != nil {
+		host = u.User.Username() + "@" + host
+	}
+	cmd := exec.Command("ssh", append(args, host, "sftp")...)
+	cmd.Stderr = os.Stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, err
+	}
+	c, err := sftp.NewClientPipe(out, in)
+	if err != nil {
+		in.Close()
+		cmd.Wait()
+		return nil, nil, fmt.Errorf("sftp handshake with %s: %v", u.Host, err)
+	}
+	return c, cmd, nil
+}
+
+func (s *sftpFS) Input(p string) string {
+	u := *s.base
+	u.Path = p
+	return u.String()
+}
+
+func (s *sftpFS) Stat(p string) (os.FileInfo, error) {
+	c, err := s.connect()
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: p, Err: err}
+	}
+	return c.Stat(p)
+}
+
+func (s *sftpFS) Lstat(p string) (os.FileInfo, error) {
+	c, err := s.connect()
+	if err != nil {
+		return nil, &os.PathError{Op: "lstat", Path: p, Err: err}
+	}
+	return c.Lstat(p)
+}
+
+func (s *sftpFS) ReadDirNames(p string) ([]string, error) {
+	c, err := s.connect()
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: p, Err: err}
+	}
+	infos, err := c.ReadDir(p)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	return names, nil
+}
+
+func (s *sftpFS) Open(p string) (sourceFile, error) {
+	c, err := s.connect()
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: p, Err: err}
+	}
+	f, err := c.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
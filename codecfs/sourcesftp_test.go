@@ -0,0 +1,86 @@
+package codecfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/pkg/sftp"
+)
+
+// pipeConn joins the ends of two pipes, as the server side of a session
+type pipeConn struct {
+	io.Reader
+	io.WriteCloser
+}
+
+// sftpSource gives an sftpFS connected to a server running in process,
+// rather than through ssh
+func sftpSource(t *testing.T) *sftpFS {
+	t.Helper()
+	clientIn, serverOut := io.Pipe()
+	serverIn, clientOut := io.Pipe()
+	server, err := sftp.NewServer(pipeConn{serverIn, serverOut}, sftp.ReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	c, err := sftp.NewClientPipe(clientIn, clientOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		// Closing the server ends the session the client waits on
+		server.Close()
+		c.Close()
+	})
+	return &sftpFS{base: &url.URL{Scheme: "sftp", User: url.User("me"), Host: "example.com:2222"}, client: c}
+}
+
+func TestSFTPSource(t *testing.T) {
+	src := t.TempDir()
+	if err := os.Mkdir(filepath.Join(src, "album"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "album", "a.flac"), []byte("flac data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := sftpSource(t)
+
+	if input := s.Input("/music/a b.flac"); input != "sftp://me@example.com:2222/music/a%20b.flac" {
+		t.Errorf("input %q", input)
+	}
+	names, err := s.ReadDirNames(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(names, []string{"album"}) {
+		t.Errorf("listed %q", names)
+	}
+	p := filepath.Join(src, "album", "a.flac")
+	stat, err := s.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.IsDir() || stat.Size() != int64(len("flac data")) {
+		t.Errorf("stat: dir %v, size %d", stat.IsDir(), stat.Size())
+	}
+	if _, err := s.Lstat(filepath.Join(src, "missing")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("lstat of a missing file: %v", err)
+	}
+
+	f, err := s.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil || string(data) != "flac data" {
+		t.Errorf("read %q: %v", data, err)
+	}
+}
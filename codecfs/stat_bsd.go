@@ -0,0 +1,44 @@
+//go:build darwin || freebsd
+// +build darwin freebsd
+
+package codecfs
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+)
+
+func statTimes(stat os.FileInfo) (atime, ctime time.Time) {
+	st, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return stat.ModTime(), stat.ModTime()
+	}
+	return time.Unix(st.Atimespec.Unix()), time.Unix(st.Ctimespec.Unix())
+}
+
+func statOwner(stat os.FileInfo) (uid, gid uint32, ok bool) {
+	st, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return st.Uid, st.Gid, true
+}
+
+// Field types differ between systems, hence the conversions
+func statfs(path string, resp *fuse.StatfsResponse) error {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return err
+	}
+	resp.Blocks = uint64(st.Blocks)
+	resp.Bfree = uint64(st.Bfree)
+	resp.Bavail = uint64(st.Bavail)
+	resp.Files = uint64(st.Files)
+	resp.Ffree = uint64(st.Ffree)
+	resp.Bsize = uint32(st.Bsize)
+	resp.Frsize = uint32(st.Bsize)
+	return nil
+}
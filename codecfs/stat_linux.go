@@ -0,0 +1,41 @@
+package codecfs
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+)
+
+func statTimes(stat os.FileInfo) (atime, ctime time.Time) {
+	st, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return stat.ModTime(), stat.ModTime()
+	}
+	return time.Unix(st.Atim.Unix()), time.Unix(st.Ctim.Unix())
+}
+
+func statOwner(stat os.FileInfo) (uid, gid uint32, ok bool) {
+	st, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return st.Uid, st.Gid, true
+}
+
+func statfs(path string, resp *fuse.StatfsResponse) error {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return err
+	}
+	resp.Blocks = st.Blocks
+	resp.Bfree = st.Bfree
+	resp.Bavail = st.Bavail
+	resp.Files = st.Files
+	resp.Ffree = st.Ffree
+	resp.Bsize = uint32(st.Bsize)
+	resp.Namelen = uint32(st.Namelen)
+	resp.Frsize = uint32(st.Frsize)
+	return nil
+}
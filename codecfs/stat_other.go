@@ -0,0 +1,27 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package codecfs
+
+import (
+	"os"
+	"time"
+
+	"bazil.org/fuse"
+)
+
+// Without a portable way to get them, access and change times are
+// approximated with the modification time
+func statTimes(stat os.FileInfo) (atime, ctime time.Time) {
+	return stat.ModTime(), stat.ModTime()
+}
+
+func statOwner(stat os.FileInfo) (uid, gid uint32, ok bool) {
+	return 0, 0, false
+}
+
+// Statfs is left empty, which is what the kernel gets when it's not
+// implemented at all
+func statfs(path string, resp *fuse.StatfsResponse) error {
+	return nil
+}
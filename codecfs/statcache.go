@@ -0,0 +1,60 @@
+package codecfs
+
+import (
+	"os"
+	"time"
+)
+
+// Listing a directory gives us the stat of all its entries for free. They
+// are kept around for a little while so that the storm of Lookup and Attr
+// file managers send right after a listing doesn't hit the disk again; bazil
+// doesn't speak READDIRPLUS, so this is the closest we can get to batching
+// attributes with the listing.
+const statCacheTTL = 5 * time.Second
+
+type statEntry struct {
+	stat    os.FileInfo
+	expires time.Time
+}
+
+// cacheStat keeps the stat of path in the source of r for a little while
+func (r *Root) cacheStat(path string, stat os.FileInfo) {
+	r.sweepStats()
+	r.stats.Store(path, statEntry{
+		stat:    stat,
+		expires: time.Now().Add(statCacheTTL),
+	})
+}
+
+// sweepStats drops the expired stats, once in a while, so that those never
+// looked up again don't pile up
+func (r *Root) sweepStats() {
+	r.sweepMu.Lock()
+	now := time.Now()
+	due := now.Sub(r.lastSweep) > statCacheTTL
+	if due {
+		r.lastSweep = now
+	}
+	r.sweepMu.Unlock()
+	if !due {
+		return
+	}
+	r.stats.Range(func(k, v interface{}) bool {
+		if now.After(v.(statEntry).expires) {
+			r.stats.Delete(k)
+		}
+		return true
+	})
+}
+
+// statSource stats path in the source of r, from the cache when possible
+func (r *Root) statSource(path string) (os.FileInfo, error) {
+	if v, ok := r.stats.Load(path); ok {
+		entry := v.(statEntry)
+		if time.Now().Before(entry.expires) {
+			return entry.stat, nil
+		}
+		r.stats.Delete(path)
+	}
+	return r.src.Stat(path)
+}
@@ -0,0 +1,147 @@
+package codecfs
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+)
+
+// Set at build time with -ldflags "-X github.com/rakoo/codecfs/codecfs.version=..."
+var version = "devel"
+
+// Name of the directory holding the virtual files about the mount itself, at
+// the root of the mount
+const statusDirName = ".codecfs"
+
+var started = time.Now()
+
+type status struct {
+	Version    string             `json:"version"`
+	Started    time.Time          `json:"started"`
+	Source     string             `json:"source"`
+	Options    map[string]string  `json:"options"`
+	Transcodes []TranscodeStatus  `json:"transcodes"`
+	Failures   []transcodeFailure `json:"failures"`
+	Reads      struct {
+		Transcoded readSummary `json:"transcoded"`
+		Native     readSummary `json:"native"`
+	} `json:"reads"`
+	Handles []handleStats `json:"handles"`
+	Cache   struct {
+		Sizes    int `json:"sizes"`
+		Listings int `json:"listings"`
+	} `json:"cache"`
+}
+
+func currentStatus(r *Root) []byte {
+	st := status{
+		Version:    version,
+		Started:    started,
+		Source:     r.dir,
+		Options:    r.opts.Settings,
+		Transcodes: activeTranscodeStatus(),
+		Failures:   []transcodeFailure{},
+		Handles:    openHandleStats(),
+	}
+	if st.Options == nil {
+		st.Options = map[string]string{}
+	}
+	st.Reads.Transcoded = transcodedReads.summary()
+	st.Reads.Native = nativeReads.summary()
+	failures.Range(func(k, v interface{}) bool {
+		st.Failures = append(st.Failures, v.(transcodeFailure))
+		return true
+	})
+	allSizes.Range(func(k, v interface{}) bool {
+		st.Cache.Sizes++
+		return true
+	})
+	dirCache.Range(func(k, v interface{}) bool {
+		st.Cache.Listings++
+		return true
+	})
+	out, _ := json.MarshalIndent(st, "", "  ")
+	return append(out, '\n')
+}
+
+var _ fs.HandleReadDirAller = &statusDir{}
+var _ fs.NodeStringLookuper = &statusDir{}
+
+type statusDir struct {
+	readOnly
+	root *Root
+}
+
+func (d *statusDir) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Inode = inode("", statusDirName)
+	a.Mode = os.ModeDir | 0555
+	a.Mtime = started
+	return nil
+}
+
+func (d *statusDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	return []fuse.Dirent{
+		{
+			Inode: inode("", statusDirName+"/status"),
+			Type:  fuse.DT_File,
+			Name:  "status",
+		},
+	}, nil
+}
+
+func (d *statusDir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	if name == "status" {
+		return &statusFile{root: d.root}, nil
+	}
+	return nil, fuse.ENOENT
+}
+
+var _ fs.NodeOpener = &statusFile{}
+
+// statusFile is a JSON snapshot of the state of the mount, taken when the
+// file is opened
+type statusFile struct {
+	readOnly
+	root *Root
+}
+
+func (f *statusFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Inode = inode("", statusDirName+"/status")
+	a.Mode = 0444
+	a.Mtime = time.Now()
+	a.Size = uint64(len(currentStatus(f.root)))
+	return nil
+}
+
+func (f *statusFile) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	if !req.Flags.IsReadOnly() {
+		return nil, errReadOnly
+	}
+	// The content changes all the time, don't let the kernel cache it
+	resp.Flags |= fuse.OpenDirectIO
+	return &bytesHandle{data: currentStatus(f.root)}, nil
+}
+
+var _ fs.HandleReader = &bytesHandle{}
+
+// bytesHandle serves content computed upfront
+type bytesHandle struct {
+	readOnly
+	data []byte
+}
+
+func (h *bytesHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	if req.Offset >= int64(len(h.data)) {
+		return nil
+	}
+	end := req.Offset + int64(req.Size)
+	if end > int64(len(h.data)) {
+		end = int64(len(h.data))
+	}
+	resp.Data = h.data[req.Offset:end]
+	return nil
+}
@@ -0,0 +1,62 @@
+package codecfs
+
+import (
+	"sync"
+	"time"
+
+	"bazil.org/fuse"
+	"golang.org/x/net/context"
+)
+
+// Reads aren't held back until they got that much ahead of the rate, so
+// that players can fill their buffers when they start or seek
+const throttleBurst = time.Second
+
+// A throttle holds back the reads of a handle to a rate
+type throttle struct {
+	// Bytes per second, 0 for no limit
+	rate float64
+
+	mu sync.Mutex
+	// When what was read so far is due at the rate
+	due time.Time
+}
+
+// newThrottle gives the throttle of a handle of a file transcoded by p
+func newThrottle(p *profile) *throttle {
+	l := currentLimits()
+	rate := float64(l.MaxReadRate)
+	if byteRate := p.byteRate(); l.ReadRateFactor > 0 && byteRate > 0 {
+		relative := l.ReadRateFactor * float64(byteRate)
+		if rate == 0 || relative < rate {
+			rate = relative
+		}
+	}
+	return &throttle{rate: rate}
+}
+
+// wait waits until n more bytes can be read
+func (t *throttle) wait(ctx context.Context, n int) error {
+	if t.rate == 0 {
+		return nil
+	}
+	t.mu.Lock()
+	now := time.Now()
+	if t.due.Before(now) {
+		t.due = now
+	}
+	t.due = t.due.Add(time.Duration(float64(n) / t.rate * float64(time.Second)))
+	delay := t.due.Sub(now) - throttleBurst
+	t.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return fuse.EINTR
+	}
+}
@@ -0,0 +1,60 @@
+package codecfs
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// thumbsProfile derives the profile of the thumbs tree from base, keeping
+// its filters: small JPEG previews of pictures and videos, of the given
+// quality and with size pixels on their largest side. Previews are named after the whole name of their source, as
+// in clip.mp4.jpg, so that those of photo.jpg and photo.png don't collide
+// and none has the name of its source.
+func thumbsProfile(base *profile, size, quality int) *profile {
+	p := *base
+	p.name = "thumbs"
+	p.format = "jpeg"
+	p.ext = ".jpg"
+	p.images = true
+	p.thumbnail = size
+	p.quality = strconv.Itoa(quality)
+	p.bitrate = ""
+	p.fixedQuality = true
+	p.rateControl = ""
+	p.sampleRate = 0
+	p.channels = 0
+	// Only previews are shown
+	p.mediaOnly = true
+	return &p
+}
+
+// isVisual tells whether the source at path in r is a picture or a video,
+// from its content
+func (r *Root) isVisual(path string) bool {
+	file, err := r.src.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	var buf [512]byte
+	n, _ := file.Read(buf[:])
+	contentType := http.DetectContentType(buf[:n])
+	return strings.HasPrefix(contentType, "image/") || strings.HasPrefix(contentType, "video/")
+}
+
+// thumbnailArgs gives the arguments of ffmpeg to make the preview of input:
+// its first frame, scaled down to fit the size of previews
+func (p *profile) thumbnailArgs(input string) []string {
+	size := strconv.Itoa(p.thumbnail)
+	// ffmpeg's JPEG quality goes from 2 (best) to 31
+	q, _ := strconv.Atoi(p.quality)
+	return []string{
+		"-i", input,
+		"-frames:v", "1",
+		"-vf", "scale=" + size + ":" + size + ":force_original_aspect_ratio=decrease",
+		"-q:v", strconv.Itoa(2 + (100-q)*29/99),
+		"-f", "mjpeg",
+		"-",
+	}
+}
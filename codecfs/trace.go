@@ -0,0 +1,29 @@
+package codecfs
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Log FUSE requests and how long we took to answer them
+var traceFuse bool
+
+// traceDebug is the bazil debug hook, logging every message exchanged with
+// the kernel
+func traceDebug(msg interface{}) {
+	slog.Info("FUSE message", "msg", msg)
+}
+
+// trace logs one handled request when tracing is enabled. It is meant to be
+// deferred at the start of a handler, with err pointing to the named error it
+// returns.
+func trace(op string, start time.Time, err *error, args ...any) {
+	if !traceFuse {
+		return
+	}
+	args = append(args, "op", op, "latency", time.Since(start))
+	if *err != nil {
+		args = append(args, "err", *err)
+	}
+	slog.Info("FUSE request", args...)
+}
@@ -0,0 +1,371 @@
+package codecfs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A transcode is a running encoder
+type transcode struct {
+	id      uint64
+	source  string
+	profile *profile
+	// Version of the source when the encoder started, zero if unknown
+	version sourceVersion
+	// Timestamp of the source the encoder started from
+	start   time.Duration
+	started time.Time
+	cmd     *exec.Cmd
+	// Read end of the pipe the encoder writes its output to. It is ours,
+	// so that reaping the encoder doesn't close it before it's read.
+	stdout *os.File
+	stderr *tailBuffer
+	// nil unless cgroupParent is set
+	cgroup *encoderCgroup
+
+	// Bytes read from the encoder so far
+	produced atomic.Int64
+	// Timestamp of the source encoded so far, as reported by ffmpeg
+	encoded atomic.Int64
+	// Whether the encoder was killed on purpose
+	cancelled atomic.Bool
+	// Whether the encoder was killed for running longer than timeout, and
+	// the timer killing it
+	timedOut atomic.Bool
+	timeout  time.Duration
+	timer    *time.Timer
+
+	// Closed once the encoder exited and was reaped, with the error it
+	// exited with
+	reaped  chan struct{}
+	exitErr error
+
+	// Output drained from the encoder ahead of the reader, and the error
+	// that stopped the draining, io.EOF at the end of the output
+	mu       sync.Mutex
+	cond     *sync.Cond
+	ahead    bytes.Buffer
+	aheadErr error
+	waited   bool
+
+	waitOnce sync.Once
+	err      error
+}
+
+// Keep that much of the end of the encoder's stderr, where the reason of a
+// failure usually is
+const stderrTail = 4096
+
+// tailBuffer is an io.Writer keeping only the last bytes written to it
+type tailBuffer struct {
+	buf []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > stderrTail {
+		t.buf = t.buf[len(t.buf)-stderrTail:]
+	}
+	return len(p), nil
+}
+
+// A failed transcode, kept for inspection
+type transcodeFailure struct {
+	Source  string    `json:"source"`
+	Profile string    `json:"profile"`
+	Time    time.Time `json:"time"`
+	Error   string    `json:"error"`
+	Stderr  string    `json:"stderr"`
+	// Number of consecutive failures
+	Count int `json:"count"`
+}
+
+// Last failure of each source file, for those whose last transcode failed
+var failures sync.Map
+
+var (
+	// How many times a transcode that failed before producing anything
+	// is started again, and how long to wait before the first retry. The
+	// delay doubles with each retry.
+	transcodeRetries = 2
+	retryBackoff     = 500 * time.Millisecond
+	// After that many consecutive failures, a file isn't transcoded again
+	// until it changes
+	maxFailures = 5
+)
+
+// brokenSource tells whether source failed too many times to be tried again
+func brokenSource(source string) bool {
+	v, ok := failures.Load(source)
+	return ok && maxFailures > 0 && v.(transcodeFailure).Count >= maxFailures
+}
+
+// How many bytes of output are drained from the encoder ahead of the reader,
+// so that bursty readers don't wait for the encoder to catch up. 0 disables
+// read-ahead.
+var readAhead int64 = 1 << 20
+
+var lastTranscodeID atomic.Uint64
+
+// Running transcodes, by id
+var transcodes sync.Map
+
+// What ffmpeg reads from when it is fed the source
+const pipeInput = "pipe:0"
+
+// startTranscode starts encoding source from timestamp start
+// sourceInput gives the input ffmpeg reads source from, and the file to feed
+// it on stdin when it can't reach the source by itself
+func (p *profile) sourceInput(source string) (string, sourceFile, error) {
+	input := p.root.src.Input(source)
+	if input == "" || p.readsStdin(source) || sandboxed() && remoteInput(input) {
+		stdin, err := p.root.src.Open(source)
+		if err != nil {
+			return "", nil, err
+		}
+		return pipeInput, stdin, nil
+	}
+	return input, nil, nil
+}
+
+func startTranscode(source string, p *profile, start time.Duration) (*transcode, error) {
+	waitForMemory()
+	var version sourceVersion
+	if stat, err := p.root.statSource(source); err == nil {
+		version = versionOf(stat)
+	}
+	if p.twoPass {
+		if err := p.firstPass(source); err != nil {
+			return nil, err
+		}
+	}
+	input, stdin, err := p.sourceInput(source)
+	if err != nil {
+		return nil, err
+	}
+	name, args := p.command(source, input, start)
+	args, progress, progressW := reportProgress(name, args)
+	cmd := encoderCommand(name, args...)
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	if progressW != nil {
+		cmd.ExtraFiles = []*os.File{progressW}
+	}
+	stderr := &tailBuffer{}
+	cmd.Stderr = stderr
+	id := lastTranscodeID.Add(1)
+	var cgroup *encoderCgroup
+	if cgroupParent != "" {
+		var err error
+		if cgroup, err = newEncoderCgroup(id); err != nil {
+			slog.Warn("Can't create encoder cgroup, running without limits", "err", err)
+		} else {
+			cgroup.attach(cmd)
+		}
+	}
+	stdout, stdoutW, err := os.Pipe()
+	if err == nil {
+		cmd.Stdout = stdoutW
+		err = cmd.Start()
+		// The encoder has its own copies
+		stdoutW.Close()
+	}
+	if progressW != nil {
+		progressW.Close()
+	}
+	if err != nil {
+		if stdout != nil {
+			stdout.Close()
+		}
+		if stdin != nil {
+			stdin.Close()
+		}
+		if progress != nil {
+			progress.Close()
+		}
+		if cgroup != nil {
+			cgroup.remove()
+		}
+		return nil, err
+	}
+	applyPriority(cmd.Process.Pid)
+
+	t := &transcode{
+		id:      id,
+		source:  source,
+		profile: p,
+		version: version,
+		start:   start,
+		started: time.Now(),
+		cmd:     cmd,
+		stdout:  stdout,
+		stderr:  stderr,
+		cgroup:  cgroup,
+		reaped:  make(chan struct{}),
+		timeout: currentLimits().TranscodeTimeout,
+	}
+	t.cond = sync.NewCond(&t.mu)
+	if t.timeout > 0 {
+		t.timer = time.AfterFunc(t.timeout, t.expire)
+	}
+	go t.reap()
+	if progress != nil {
+		go t.readProgress(progress)
+	}
+	if readAhead > 0 {
+		go t.drain()
+	}
+	transcodes.Store(t.id, t)
+	activeTranscodes.Inc()
+	slog.Debug("Transcode started", "id", t.id, "source", source, "profile", p.name, "args", args)
+	emit(Event{Type: eventTranscodeStarted, Source: source, Profile: p.name})
+	return t, nil
+}
+
+// drain reads the output of the encoder ahead of the reader, until it is
+// readAhead bytes ahead
+func (t *transcode) drain() {
+	buf := make([]byte, 32*1024)
+	for {
+		t.mu.Lock()
+		for int64(t.ahead.Len()) >= readAhead && !t.waited {
+			t.cond.Wait()
+		}
+		waited := t.waited
+		t.mu.Unlock()
+		if waited {
+			return
+		}
+
+		n, err := t.stdout.Read(buf)
+		t.produced.Add(int64(n))
+		t.mu.Lock()
+		t.ahead.Write(buf[:n])
+		if err != nil {
+			t.aheadErr = err
+		}
+		t.cond.Broadcast()
+		t.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Read reads the output of the encoder
+func (t *transcode) Read(p []byte) (int, error) {
+	if readAhead == 0 {
+		n, err := t.stdout.Read(p)
+		t.produced.Add(int64(n))
+		return n, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for t.ahead.Len() == 0 && t.aheadErr == nil {
+		t.cond.Wait()
+	}
+	if t.ahead.Len() == 0 {
+		return 0, t.aheadErr
+	}
+	n, _ := t.ahead.Read(p)
+	t.cond.Broadcast()
+	return n, nil
+}
+
+// reap waits for the encoder to exit, so that it doesn't linger as a zombie
+// whether its output is read to the end or not, and releases what it used.
+// It is the only caller of cmd.Wait.
+func (t *transcode) reap() {
+	t.exitErr = t.cmd.Wait()
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	if stdin, ok := t.cmd.Stdin.(io.Closer); ok {
+		stdin.Close()
+	}
+	if t.cgroup != nil {
+		t.cgroup.remove()
+	}
+	close(t.reaped)
+}
+
+// wait waits for the encoder to exit and forgets about it. It can be called
+// several times, and always returns the outcome of the transcode. Once it
+// returns, the rest of the output can't be read anymore.
+func (t *transcode) wait() error {
+	t.waitOnce.Do(func() {
+		<-t.reaped
+		t.err = t.exitErr
+		if t.err != nil && t.timedOut.Load() {
+			t.err = fmt.Errorf("killed after running for %v", t.timeout)
+		}
+		t.stdout.Close()
+		t.mu.Lock()
+		t.waited = true
+		if t.aheadErr == nil {
+			t.aheadErr = io.EOF
+		}
+		t.cond.Broadcast()
+		t.mu.Unlock()
+		transcodes.Delete(t.id)
+		activeTranscodes.Dec()
+		duration := time.Since(t.started)
+		transcodeDuration.Observe(duration.Seconds())
+		if t.err != nil && t.cancelled.Load() {
+			slog.Debug("Transcode cancelled", "id", t.id, "source", t.source, "profile", t.profile.name, "duration", duration)
+		} else if t.err != nil {
+			ffmpegFailures.Inc()
+			stderr := string(t.stderr.buf)
+			count := 1
+			if v, ok := failures.Load(t.source); ok {
+				count += v.(transcodeFailure).Count
+			}
+			if t.timedOut.Load() && count < maxFailures {
+				// It would most likely spin again
+				count = maxFailures
+			}
+			slog.Warn("Transcode failed", "id", t.id, "source", t.source, "profile", t.profile.name, "duration", duration, "failures", count, "err", t.err, "stderr", stderr)
+			failures.Store(t.source, transcodeFailure{
+				Source:  t.source,
+				Profile: t.profile.name,
+				Time:    time.Now(),
+				Error:   t.err.Error(),
+				Stderr:  stderr,
+				Count:   count,
+			})
+			emit(Event{Type: eventTranscodeFailed, Source: t.source, Profile: t.profile.name, Size: t.produced.Load(), Error: t.err.Error()})
+		} else {
+			slog.Debug("Transcode finished", "id", t.id, "source", t.source, "profile", t.profile.name, "duration", duration)
+			failures.Delete(t.source)
+			emit(Event{Type: eventTranscodeFinished, Source: t.source, Profile: t.profile.name, Size: t.produced.Load()})
+		}
+	})
+	return t.err
+}
+
+// expire kills the encoder once it ran for too long
+func (t *transcode) expire() {
+	t.timedOut.Store(true)
+	slog.Warn("Transcode took too long, killing it", "id", t.id, "source", t.source, "profile", t.profile.name, "timeout", t.timeout)
+	t.cmd.Process.Kill()
+}
+
+// cancel kills the encoder. The reader then sees the end of its output, and
+// the transcode isn't recorded as a failure.
+func (t *transcode) cancel() error {
+	t.cancelled.Store(true)
+	if err := t.cmd.Process.Kill(); err != nil {
+		t.cancelled.Store(false)
+		return err
+	}
+	return nil
+}
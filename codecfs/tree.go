@@ -0,0 +1,317 @@
+package codecfs
+
+import (
+	"io"
+	"mime"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+)
+
+// The functions below drive the nodes of the virtual tree the way the FUSE
+// server does, for the transports serving the tree without the kernel.
+
+// lookupPath gives the node at the slash-separated path p under root. Paths
+// going through . or .. aren't found, so that they can't escape root. io/fs
+// names root itself ".".
+func lookupPath(ctx context.Context, root fs.Node, p string) (fs.Node, error) {
+	n := root
+	if p == "." {
+		return n, nil
+	}
+	for _, name := range strings.Split(p, "/") {
+		if name == "" {
+			continue
+		}
+		if !validName(name) {
+			return nil, fuse.ENOENT
+		}
+		l, ok := n.(fs.NodeStringLookuper)
+		if !ok {
+			return nil, fuse.ENOENT
+		}
+		next, err := l.Lookup(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		n = next
+	}
+	return n, nil
+}
+
+// validName tells whether name can be the name of an entry of a directory,
+// one that lookups can't leave the directory with
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\x00")
+}
+
+// nodeAttr gives the attributes of n
+func nodeAttr(ctx context.Context, n fs.Node) (fuse.Attr, error) {
+	var a fuse.Attr
+	err := n.Attr(ctx, &a)
+	return a, err
+}
+
+// openNode opens n for reading. Nodes that can't be opened are their own
+// handle.
+func openNode(ctx context.Context, n fs.Node, dir bool) (fs.Handle, error) {
+	o, ok := n.(fs.NodeOpener)
+	if !ok {
+		return n, nil
+	}
+	return o.Open(ctx, &fuse.OpenRequest{Dir: dir, Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+}
+
+// readDirAll lists the directory n
+func readDirAll(ctx context.Context, n fs.Node) ([]fuse.Dirent, error) {
+	h, err := openNode(ctx, n, true)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseHandle(ctx, h)
+	switch h := h.(type) {
+	case fs.HandleReadDirAller:
+		return h.ReadDirAll(ctx)
+	case *dirHandle:
+		return h.all(), nil
+	}
+	return nil, fuse.ENOSYS
+}
+
+// readHandle reads from h at offset off into p. It returns io.EOF once
+// there's nothing left.
+func readHandle(ctx context.Context, h fs.Handle, p []byte, off int64) (int, error) {
+	r, ok := h.(fs.HandleReader)
+	if !ok {
+		return 0, fuse.EIO
+	}
+	resp := &fuse.ReadResponse{Data: p[:0]}
+	err := r.Read(ctx, &fuse.ReadRequest{Offset: off, Size: len(p)}, resp)
+	n := copy(p, resp.Data)
+	if n == 0 && (err == nil || err == io.EOF) {
+		return 0, io.EOF
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// releaseHandle closes h
+func releaseHandle(ctx context.Context, h fs.Handle) error {
+	if r, ok := h.(fs.HandleReleaser); ok {
+		return r.Release(ctx, &fuse.ReleaseRequest{})
+	}
+	return nil
+}
+
+// A treeFile is a node of the tree opened by path, with the usual file
+// methods. The node is only opened when read, so that listing and stating
+// don't start encoders.
+type treeFile struct {
+	ctx  context.Context
+	name string
+	node fs.Node
+	attr fuse.Attr
+
+	mu     sync.Mutex
+	handle fs.Handle
+	offset int64
+	// Entries not returned by Readdir yet, nil before the first call
+	ents []fuse.Dirent
+}
+
+// openPath opens the node at the slash-separated path p under root
+func openPath(ctx context.Context, root fs.Node, p string) (*treeFile, error) {
+	n, err := lookupPath(ctx, root, p)
+	if err != nil {
+		return nil, err
+	}
+	a, err := nodeAttr(ctx, n)
+	if err != nil {
+		return nil, err
+	}
+	return &treeFile{ctx: ctx, name: path.Base(p), node: n, attr: a}, nil
+}
+
+func (f *treeFile) Stat() (os.FileInfo, error) {
+	return treeFileInfo{f.name, f.node, f.attr}, nil
+}
+
+func (f *treeFile) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err := f.readAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *treeFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var n int
+	for n < len(p) {
+		m, err := f.readAt(p[n:], off+int64(n))
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (f *treeFile) readAt(p []byte, off int64) (int, error) {
+	if f.attr.Mode.IsDir() {
+		return 0, fuse.Errno(syscall.EISDIR)
+	}
+	if f.handle == nil {
+		h, err := openNode(f.ctx, f.node, false)
+		if err != nil {
+			return 0, err
+		}
+		f.handle = h
+	}
+	return readHandle(f.ctx, f.handle, p, off)
+}
+
+// Seeking relative to the end needs the exact size, which takes transcoding
+// the whole file when it isn't known yet
+func (f *treeFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		size, err := f.size()
+		if err != nil {
+			return 0, err
+		}
+		offset += size
+	}
+	if offset < 0 {
+		return 0, fuse.Errno(syscall.EINVAL)
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// knownSize gives the size of the file if it is known without transcoding it
+func (f *treeFile) knownSize() (int64, bool) {
+	n, ok := f.node.(*file)
+	if !ok {
+		return int64(f.attr.Size), true
+	}
+	stat, err := n.profile.root.statSource(n.source)
+	if err != nil {
+		return 0, false
+	}
+	size, exact := n.size(stat)
+	return int64(size), exact
+}
+
+// size gives the exact size of the file
+func (f *treeFile) size() (int64, error) {
+	if size, ok := f.knownSize(); ok {
+		return size, nil
+	}
+
+	var size int64
+	buf := make([]byte, 64*1024)
+	for {
+		m, err := f.readAt(buf, size)
+		size += int64(m)
+		if err == io.EOF {
+			return size, nil
+		} else if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (f *treeFile) Readdir(count int) ([]os.FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.attr.Mode.IsDir() {
+		return nil, fuse.Errno(syscall.ENOTDIR)
+	}
+	if f.ents == nil {
+		ents, err := readDirAll(f.ctx, f.node)
+		if err != nil {
+			return nil, err
+		}
+		f.ents = append([]fuse.Dirent{}, ents...)
+	}
+	if count <= 0 || count > len(f.ents) {
+		if count > 0 && len(f.ents) == 0 {
+			return nil, io.EOF
+		}
+		count = len(f.ents)
+	}
+
+	var out []os.FileInfo
+	for _, ent := range f.ents[:count] {
+		n, err := lookupPath(f.ctx, f.node, ent.Name)
+		if err != nil {
+			continue
+		}
+		a, err := nodeAttr(f.ctx, n)
+		if err != nil {
+			continue
+		}
+		out = append(out, treeFileInfo{ent.Name, n, a})
+	}
+	f.ents = f.ents[count:]
+	return out, nil
+}
+
+func (f *treeFile) Write(p []byte) (int, error) {
+	return 0, errReadOnly
+}
+
+func (f *treeFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.handle == nil {
+		return nil
+	}
+	return releaseHandle(f.ctx, f.handle)
+}
+
+// treeFileInfo describes a node of the tree
+type treeFileInfo struct {
+	name string
+	node fs.Node
+	attr fuse.Attr
+}
+
+// contentType gives the media type of the file, guessed from its name
+// unless it is transcoded. It is empty when unknown.
+func (fi treeFileInfo) contentType() string {
+	if fi.attr.Mode.IsDir() {
+		return ""
+	}
+	if f, ok := fi.node.(*file); ok && f.name != f.source {
+		return f.profile.contentType()
+	}
+	return mime.TypeByExtension(path.Ext(fi.name))
+}
+
+func (fi treeFileInfo) Name() string       { return fi.name }
+func (fi treeFileInfo) Size() int64        { return int64(fi.attr.Size) }
+func (fi treeFileInfo) Mode() os.FileMode  { return fi.attr.Mode }
+func (fi treeFileInfo) ModTime() time.Time { return fi.attr.Mtime }
+func (fi treeFileInfo) IsDir() bool        { return fi.attr.Mode.IsDir() }
+func (fi treeFileInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,54 @@
+package codecfs
+
+import (
+	"testing"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+)
+
+// lookupRecorder is a directory holding every name, which records the names
+// it is asked for
+type lookupRecorder struct {
+	readOnly
+	names *[]string
+}
+
+func (l lookupRecorder) Attr(ctx context.Context, a *fuse.Attr) error {
+	return nil
+}
+
+func (l lookupRecorder) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	*l.names = append(*l.names, name)
+	return l, nil
+}
+
+func TestLookupPathTraversal(t *testing.T) {
+	for _, p := range []string{"a/../../x", "..", "../etc/passwd", "a/./b", "ogg/.."} {
+		var names []string
+		if _, err := lookupPath(context.Background(), lookupRecorder{names: &names}, p); err == nil {
+			t.Errorf("%s: found", p)
+		}
+		for _, name := range names {
+			if name == ".." || name == "." {
+				t.Errorf("%s: looked up %q", p, name)
+			}
+		}
+	}
+	for _, p := range []string{".", "", "/", "a/b", "/a/b/", "a//b"} {
+		var names []string
+		if _, err := lookupPath(context.Background(), lookupRecorder{names: &names}, p); err != nil {
+			t.Errorf("%s: %v", p, err)
+		}
+	}
+}
+
+func TestDirLookupTraversal(t *testing.T) {
+	d := &dir{dir: "/srv/music", profile: &profile{name: "ogg"}}
+	for _, name := range []string{"..", ".", "", "a/b", "../etc"} {
+		if _, err := d.Lookup(context.Background(), name); err != fuse.ENOENT {
+			t.Errorf("%q: got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package codecfs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// unionFS merges several sources into one tree, rooted at "/". Directories
+// are merged, and for other entries the first branch having the path wins.
+type unionFS []unionBranch
+
+// A unionBranch is a source merged in a unionFS, along with the path of its
+// root
+type unionBranch struct {
+	fs   sourceFS
+	root string
+}
+
+func (b unionBranch) path(p string) string {
+	return path.Join(b.root, p)
+}
+
+// unionDirInfo is a merged directory. Its mtime is the latest of the
+// merged directories, so that a change in any of them is noticed.
+type unionDirInfo struct {
+	os.FileInfo
+	mtime time.Time
+}
+
+func (fi unionDirInfo) ModTime() time.Time { return fi.mtime }
+
+// find gives the first branch having p, and its stat
+func (u unionFS) find(p string, lstat bool) (unionBranch, os.FileInfo, error) {
+	var firstErr error
+	for _, b := range u {
+		var stat os.FileInfo
+		var err error
+		if lstat {
+			stat, err = b.fs.Lstat(b.path(p))
+		} else {
+			stat, err = b.fs.Stat(b.path(p))
+		}
+		if err == nil {
+			return b, stat, nil
+		}
+		if firstErr == nil || !errors.Is(err, fs.ErrNotExist) {
+			firstErr = err
+		}
+	}
+	return unionBranch{}, nil, firstErr
+}
+
+func (u unionFS) stat(p string, lstat bool) (os.FileInfo, error) {
+	_, stat, err := u.find(p, lstat)
+	if err != nil || !stat.IsDir() {
+		return stat, err
+	}
+	mtime := stat.ModTime()
+	for _, b := range u[1:] {
+		if other, err := b.fs.Stat(b.path(p)); err == nil && other.IsDir() && other.ModTime().After(mtime) {
+			mtime = other.ModTime()
+		}
+	}
+	return unionDirInfo{stat, mtime}, nil
+}
+
+func (u unionFS) Stat(p string) (os.FileInfo, error) {
+	return u.stat(p, false)
+}
+
+func (u unionFS) Lstat(p string) (os.FileInfo, error) {
+	return u.stat(p, true)
+}
+
+func (u unionFS) ReadDirNames(p string) ([]string, error) {
+	var names []string
+	seen := map[string]bool{}
+	var firstErr error
+	found := false
+	for _, b := range u {
+		branchNames, err := b.fs.ReadDirNames(b.path(p))
+		if err != nil {
+			if firstErr == nil || !errors.Is(err, fs.ErrNotExist) {
+				firstErr = err
+			}
+			continue
+		}
+		found = true
+		for _, name := range branchNames {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	if !found {
+		return nil, firstErr
+	}
+	return names, nil
+}
+
+func (u unionFS) Open(p string) (sourceFile, error) {
+	b, _, err := u.find(p, false)
+	if err != nil {
+		return nil, err
+	}
+	return b.fs.Open(b.path(p))
+}
+
+func (u unionFS) Input(p string) string {
+	b, _, err := u.find(p, false)
+	if err != nil {
+		return u[0].fs.Input(u[0].path(p))
+	}
+	return b.fs.Input(b.path(p))
+}
+
+// sourceList is a flag.Value collecting sources from repeated flags
+type sourceList []string
+
+func (l *sourceList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *sourceList) Set(input string) error {
+	*l = append(*l, input)
+	return nil
+}
@@ -0,0 +1,139 @@
+package codecfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// videoProfile derives the profile of the video tree from base, with videos
+// encoded to WebM: VP9 at the given bitrate, and Opus. With twoPass, each
+// video is analyzed by a first pass before being encoded.
+func videoProfile(base *profile, bitrate string, twoPass bool) *profile {
+	p := *base
+	p.name = "video"
+	p.format = "webm"
+	p.ext = ".webm"
+	p.video = true
+	p.videoBitrate = bitrate
+	p.twoPass = twoPass
+	p.quality = ""
+	p.bitrate = ""
+	p.fixedQuality = true
+	p.rateControl = ""
+	p.sampleRate = 0
+	p.channels = 0
+	p.lossless = false
+	p.minBitrate = 0
+	return &p
+}
+
+// videoArgs gives the arguments of ffmpeg to encode input, read from
+// source. Encodes always start from the beginning: WebM can't be joined from
+// the middle, and the statistics of the first pass cover the whole video.
+func (p *profile) videoArgs(source, input string) []string {
+	args := []string{
+		"-i", input,
+		"-map", "0:v:0",
+		"-map", "0:a:0?",
+		"-c:v", "libvpx-vp9",
+		"-b:v", p.videoBitrate,
+		"-c:a", "libopus",
+	}
+	if p.twoPass {
+		args = append(args, "-pass", "2", "-passlogfile", p.passLog(source))
+	}
+	return append(args,
+		"-fflags", "+bitexact",
+		"-flags:v", "+bitexact",
+		"-flags:a", "+bitexact",
+		"-f", "webm",
+		"-",
+	)
+}
+
+// Where the statistics of first passes are kept, next to the size cache
+// when there is one
+func passLogDir() string {
+	if sizeCachePath != "" {
+		return filepath.Join(filepath.Dir(sizeCachePath), "passlogs")
+	}
+	return filepath.Join(os.TempDir(), "codecfs-passlogs")
+}
+
+// passLog gives the prefix of the statistics of the first pass over source,
+// which ffmpeg completes with the index of the stream
+func (p *profile) passLog(source string) string {
+	sum := sha256.Sum256([]byte(p.root.dir + "\x00" + p.videoBitrate + "\x00" + source))
+	return filepath.Join(passLogDir(), hex.EncodeToString(sum[:12]))
+}
+
+// Serializes the first passes of a video, by pass log prefix
+var firstPasses sync.Map
+
+// firstPass runs the first pass over source unless its statistics are
+// already there and newer than the source
+func (p *profile) firstPass(source string) error {
+	prefix := p.passLog(source)
+	v, _ := firstPasses.LoadOrStore(prefix, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	defer mu.Unlock()
+
+	stat, err := p.root.statSource(source)
+	if err != nil {
+		return err
+	}
+	if log, err := os.Stat(prefix + "-0.log"); err == nil && log.ModTime().After(stat.ModTime()) {
+		return nil
+	}
+	if err := os.MkdirAll(passLogDir(), 0755); err != nil {
+		return err
+	}
+
+	input, stdin, err := p.sourceInput(source)
+	if err != nil {
+		return err
+	}
+	// Written under another prefix, so that an interrupted pass isn't
+	// taken for a complete one
+	partial := prefix + ".partial"
+	cmd := encoderCommand("ffmpeg", "-y",
+		"-i", input,
+		"-map", "0:v:0",
+		"-c:v", "libvpx-vp9",
+		"-b:v", p.videoBitrate,
+		"-pass", "1",
+		"-passlogfile", partial,
+		"-an",
+		"-f", "null",
+		"-",
+	)
+	if stdin != nil {
+		cmd.Stdin = stdin
+		defer stdin.Close()
+	}
+	stderr := &tailBuffer{}
+	cmd.Stderr = stderr
+	began := time.Now()
+	slog.Info("First pass started", "source", source, "profile", p.name)
+	err = cmd.Start()
+	if err == nil {
+		applyPriority(cmd.Process.Pid)
+		err = cmd.Wait()
+	}
+	if err == nil {
+		err = os.Rename(partial+"-0.log", prefix+"-0.log")
+	}
+	if err != nil {
+		os.Remove(partial + "-0.log")
+		return fmt.Errorf("first pass: %v: %s", err, stderr.buf)
+	}
+	slog.Info("First pass done", "source", source, "profile", p.name, "duration", time.Since(began))
+	return nil
+}
@@ -0,0 +1,72 @@
+package codecfs
+
+import (
+	"os"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+)
+
+var _ fs.NodeOpener = &volumeIcon{}
+var _ fs.NodeGetxattrer = &Root{}
+var _ fs.NodeListxattrer = &Root{}
+
+// Finder shows the icon of a volume from this file at its root, when the
+// FinderInfo of the root has the custom icon flag
+const (
+	volumeIconName  = ".VolumeIcon.icns"
+	xattrFinderInfo = "com.apple.FinderInfo"
+)
+
+// volumeIcon is the icon of the volume, the .icns file of -volume-icon
+type volumeIcon struct {
+	readOnly
+	path string
+}
+
+func (v *volumeIcon) Attr(ctx context.Context, a *fuse.Attr) error {
+	stat, err := os.Stat(v.path)
+	if err != nil {
+		return err
+	}
+	a.Inode = inode("", volumeIconName)
+	a.Mode = 0444
+	a.Size = uint64(stat.Size())
+	a.Mtime = stat.ModTime()
+	return nil
+}
+
+func (v *volumeIcon) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	if !req.Flags.IsReadOnly() {
+		return nil, errReadOnly
+	}
+	data, err := os.ReadFile(v.path)
+	if err != nil {
+		return nil, err
+	}
+	return &bytesHandle{data: data}, nil
+}
+
+// finderInfo gives the FinderInfo of the root: all zeroes but the custom
+// icon flag, in the Finder flags at offset 8
+func finderInfo() []byte {
+	info := make([]byte, 32)
+	info[8] = 0x04
+	return info
+}
+
+func (r *Root) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	if r.opts.VolumeIcon != "" {
+		resp.Append(xattrFinderInfo)
+	}
+	return nil
+}
+
+func (r *Root) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	if req.Name != xattrFinderInfo || r.opts.VolumeIcon == "" {
+		return fuse.ErrNoXattr
+	}
+	resp.Xattr = finderInfo()
+	return nil
+}
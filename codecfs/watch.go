@@ -0,0 +1,159 @@
+package codecfs
+
+import (
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"bazil.org/fuse/fs"
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watches source directories once they have been listed, nil if watching is
+// disabled
+var watcher *fsnotify.Watcher
+
+var (
+	// Directories watched, and how many there are
+	watched    sync.Map
+	watchCount atomic.Int64
+	// Most directories watched at once, 0 for no limit. Each takes one of
+	// the inotify watches the user is allowed.
+	maxWatches int
+	// Whether reaching the limit was logged
+	watchLimitLogged atomic.Bool
+)
+
+func watch(path string) {
+	if watcher == nil {
+		return
+	}
+	if _, ok := watched.Load(path); ok {
+		return
+	}
+	if maxWatches > 0 && watchCount.Load() >= int64(maxWatches) {
+		if !watchLimitLogged.Swap(true) {
+			slog.Warn("Too many watched directories, changes in the next ones only show once their listing expires", "max", maxWatches)
+		}
+		return
+	}
+	if _, loaded := watched.LoadOrStore(path, true); loaded {
+		return
+	}
+	if err := watcher.Add(path); err != nil {
+		watched.Delete(path)
+		slog.Warn("Can't watch source directory", "path", path, "err", err)
+		return
+	}
+	watchCount.Add(1)
+}
+
+func watchSource() {
+	for {
+		select {
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename) {
+				// Directories gone stop being watched
+				if _, ok := watched.LoadAndDelete(ev.Name); ok {
+					watcher.Remove(ev.Name)
+					watchCount.Add(-1)
+				}
+			}
+			sourceChanged(ev.Name)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Warn("Watch error", "err", err)
+		}
+	}
+}
+
+// sourceChanged drops everything we know about the source entry at path and
+// tells the kernel to do the same. Errors from the invalidations are ignored:
+// they mostly mean that the kernel doesn't have the node or entry cached.
+func sourceChanged(path string) {
+	parent := filepath.Dir(path)
+	dropSizes(path)
+	failures.Delete(path)
+	dropPrefetched(path)
+
+	// The names the entry is exposed as
+	names := map[string]bool{path: true}
+	for _, root := range roots() {
+		root.stats.Delete(path)
+		for _, name := range root.renamedAs(path) {
+			names[name] = true
+			dropSizes(name)
+		}
+	}
+
+	// A new .codecfs.toml changes the profile of the directory and
+	// everything below: forget about all of them, and have the kernel look
+	// up the directory again
+	stale := ""
+	if filepath.Base(path) == dirConfigName {
+		stale = parent
+		names[parent] = true
+		parent = filepath.Dir(parent)
+		for _, root := range roots() {
+			if stale != root.dir {
+				continue
+			}
+			// Including the trees of named profiles looked up so far
+			for _, key := range nodesAt(root.dir) {
+				if key.root == root {
+					eachServer(func(srv *fs.Server) {
+						srv.InvalidateEntry(root, key.profile)
+					})
+				}
+			}
+		}
+	}
+
+	for _, key := range nodesAt(parent) {
+		dirCache.Delete(key)
+		n, ok := nodes.Load(key)
+		if !ok {
+			continue
+		}
+		eachServer(func(srv *fs.Server) {
+			srv.InvalidateNodeAttr(n.(fs.Node))
+			for name := range names {
+				srv.InvalidateEntry(n.(fs.Node), filepath.Base(name))
+			}
+		})
+	}
+	for name := range names {
+		for _, key := range nodesAt(name) {
+			n, ok := nodes.Load(key)
+			if !ok {
+				continue
+			}
+			if _, isDir := n.(*dir); isDir && stale == "" {
+				// A directory takes what was below it along
+				stale = name
+			}
+			eachServer(func(srv *fs.Server) {
+				srv.InvalidateNodeAttr(n.(fs.Node))
+				srv.InvalidateNodeData(n.(fs.Node))
+			})
+			forgetNode(key, n.(fs.Node))
+		}
+	}
+	if stale == "" {
+		return
+	}
+	// Only changes to directories go through all the nodes
+	nodes.Range(func(k, v interface{}) bool {
+		if key := k.(nodeKey); strings.HasPrefix(key.path, stale+string(filepath.Separator)) {
+			forgetNode(key, v.(fs.Node))
+		}
+		return true
+	})
+}
@@ -0,0 +1,32 @@
+package codecfs
+
+import (
+	"path/filepath"
+	"testing"
+
+	"bazil.org/fuse/fs"
+)
+
+func TestSourceChangedForgetsRenamedNodes(t *testing.T) {
+	src := t.TempDir()
+	opts := DefaultOptions()
+	opts.SizeCache = ""
+	opts.Watch = false
+	r, err := New(src, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := filepath.Join(src, "a.flac")
+	virtual := filepath.Join(src, "a.ogg")
+	r.rename(virtual, source)
+	key := r.ogg.key(virtual)
+	node(key, func() fs.Node { return &file{} })
+
+	sourceChanged(source)
+	if _, ok := nodes.Load(key); ok {
+		t.Error("node of the converted name kept")
+	}
+	if keys := nodesAt(virtual); len(keys) != 0 {
+		t.Errorf("index kept %v", keys)
+	}
+}
@@ -0,0 +1,23 @@
+package codecfs
+
+import "strconv"
+
+// wavProfile derives the profile of the wav tree, for audio editors that
+// only read PCM, from base, keeping its filters. Every source is decoded,
+// even lossy ones, to bits per sample: 16, 24 or 32.
+func wavProfile(base *profile, bits int) *profile {
+	p := *base
+	p.name = "wav"
+	p.format = "wav"
+	p.ext = ".wav"
+	p.quality = ""
+	p.bitrate = ""
+	p.fixedQuality = true
+	p.rateControl = ""
+	p.sampleRate = 0
+	p.channels = 0
+	p.minBitrate = 0
+	p.minSize = 0
+	p.pcmCodec = "pcm_s" + strconv.Itoa(bits) + "le"
+	return &p
+}
@@ -0,0 +1,78 @@
+package codecfs
+
+import (
+	"strconv"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+)
+
+var _ fs.NodeGetxattrer = &file{}
+var _ fs.NodeListxattrer = &file{}
+
+const (
+	xattrSourcePath    = "user.codecfs.source_path"
+	xattrCodec         = "user.codecfs.codec"
+	xattrEstimatedSize = "user.codecfs.estimated_size"
+	xattrCached        = "user.codecfs.cached"
+	xattrDuration      = "user.codecfs.duration"
+	// Only while the file is being transcoded
+	xattrProduced = "user.codecfs.bytes_produced"
+	xattrProgress = "user.codecfs.progress"
+)
+
+func (f *file) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	resp.Append(xattrSourcePath, xattrCodec, xattrEstimatedSize, xattrCached, xattrDuration)
+	if _, ok := runningTranscode(f.source, f.profile); ok {
+		resp.Append(xattrProduced, xattrProgress)
+	}
+	return nil
+}
+
+func (f *file) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	switch req.Name {
+	case xattrSourcePath:
+		resp.Xattr = []byte(f.source)
+	case xattrCodec:
+		if f.name == f.source {
+			resp.Xattr = []byte("none")
+		} else {
+			resp.Xattr = []byte(f.profile.format)
+		}
+	case xattrEstimatedSize, xattrCached:
+		stat, err := f.profile.root.statSource(f.source)
+		if err != nil {
+			return err
+		}
+		size, exact := f.size(stat)
+		if req.Name == xattrEstimatedSize {
+			resp.Xattr = []byte(strconv.FormatUint(size, 10))
+		} else {
+			resp.Xattr = []byte(strconv.FormatBool(exact))
+		}
+	case xattrDuration:
+		d, ok := f.duration()
+		if !ok {
+			return fuse.ErrNoXattr
+		}
+		resp.Xattr = []byte(strconv.FormatFloat(d.Seconds(), 'f', 6, 64))
+	case xattrProduced, xattrProgress:
+		t, ok := runningTranscode(f.source, f.profile)
+		if !ok {
+			return fuse.ErrNoXattr
+		}
+		if req.Name == xattrProduced {
+			resp.Xattr = []byte(strconv.FormatInt(t.produced.Load(), 10))
+			break
+		}
+		percent, ok := t.percent()
+		if !ok {
+			return fuse.ErrNoXattr
+		}
+		resp.Xattr = []byte(strconv.FormatFloat(percent, 'f', 1, 64))
+	default:
+		return fuse.ErrNoXattr
+	}
+	return nil
+}
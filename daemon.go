@@ -0,0 +1,97 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/rakoo/codecfs/codecfs"
+)
+
+// daemonize starts the same command again in a new session, detached from
+// the terminal, and returns its pid. Output goes to logFile if given, and is
+// discarded otherwise.
+func daemonize(logFile string) (int, error) {
+	out, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if logFile != "" {
+		out, err = os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+
+	self, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	cmd := exec.Command(self, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), daemonEnv+"=1")
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	return cmd.Process.Pid, cmd.Process.Release()
+}
+
+// sdNotify sends state to systemd when running as a Type=notify service, see
+// sd_notify(3). It does nothing otherwise.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// Abstract socket
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("sd_notify: %v", err)
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// waitMounted waits until something is mounted on mountpoint, or until the
+// background process with the given pid exits
+func waitMounted(mountpoint string, pid int) error {
+	parent, err := os.Stat(filepath.Dir(mountpoint))
+	if err != nil {
+		return err
+	}
+	for {
+		stat, err := os.Stat(mountpoint)
+		if err == nil && stat.Sys().(*syscall.Stat_t).Dev != parent.Sys().(*syscall.Stat_t).Dev {
+			return nil
+		}
+		// Signal 0 only checks that the process is still there
+		if err := syscall.Kill(pid, 0); err != nil {
+			return errors.New("codecfs exited before mounting, see its logs")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// reloadOnHangup reloads the configuration whenever the process gets SIGHUP
+func reloadOnHangup() {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	for range hangups {
+		if err := codecfs.Reload(); err != nil {
+			slog.Warn("Can't reload configuration, keeping the current one", "err", err)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+// daemonize can't detach without sessions to start the process in
+func daemonize(logFile string) (int, error) {
+	return 0, errors.New("-daemon is only supported on unix")
+}
+
+// sdNotify does nothing, there's no systemd to notify
+func sdNotify(state string) error {
+	return nil
+}
+
+func waitMounted(mountpoint string, pid int) error {
+	return errors.ErrUnsupported
+}
+
+// reloadOnHangup does nothing, there's no SIGHUP to reload on
+func reloadOnHangup() {}
@@ -0,0 +1,69 @@
+// Codecfs mounts a directory of music as a FUSE filesystem where audio files
+// are transcoded on the fly when they are read.
+//
+// Besides the trees listed at the root, trees encoded with other settings
+// are there for whoever names them: a codec among ogg, opus, mp3 and aac,
+// and either a bitrate in kbit/s or a quality prefixed by q, as in ogg-q3,
+// opus-64 or mp3-320, optionally followed by a rate control mode among vbr,
+// cvbr, abr and cbr, as in opus-96-cbr. mp3 trees also take the LAME
+// presets, V0 to V9, insane (320 CBR), extreme, standard and medium, as in
+// mp3-V0 or mp3-insane. Likewise, the name of a source file followed by the
+// extension of one of these codecs, like track.flac.opus, is the file
+// transcoded to that codec, whatever the tree it is looked up in.
+//
+// The size of a transcoded file is only known once it has been transcoded
+// to the end, see -size-strategy. Tools reading files to the end, like cp,
+// copy them exactly as long as the reported size isn't below the real one;
+// a short read tells the kernel where they end. rsync trusts sizes, so it
+// needs exact ones from -size-strategy exact or -size-scan.
+//
+// Albums stay gapless in the ogg and opus trees: ffmpeg drops the delay and
+// padding the source declares, and the encoder declares its own in the Ogg
+// headers, which players skip. The mp3 and aac trees aren't gapless, their
+// delay and padding go in a header written once the encode is done, which
+// can't be done on a stream. The exact duration of a file is in the
+// user.codecfs.duration extended attribute.
+//
+// Trees only hold audio, even for video sources, except the one of -video
+// where videos are encoded to WebM. With -video-two-pass, they are encoded
+// in two passes: the first one reads the whole source before the second can
+// give the first byte, so the first read of a video waits for it. Its
+// statistics are kept in a passlogs directory next to the -size-cache
+// database, or in the temporary directory without one, and are used again
+// until the source changes.
+//
+// Files exposed as they are in the source can be mapped in memory, which
+// some taggers and samplers do. Transcoded ones can too, as long as their
+// size is exact and -direct-io is off; otherwise accesses past their real
+// end fault.
+//
+// FUSE goes through bazil, whose reads are at most 128KiB and copied on
+// their way to the kernel, without splice. go-fuse could splice the files
+// shown as they are straight from the source, but the kernel keeps those in
+// its page cache and reads them ahead by -max-readahead, so most reads of
+// them never reach us. Transcoded files come out of the encoder's buffers,
+// which take the copy anyway, and their encode is what bounds their reads.
+// That isn't worth rewriting the nodes for another library.
+//
+// It runs where bazil.org/fuse does, that is Linux, macOS and FreeBSD.
+// NetBSD isn't supported: its FUSE is served by the perfused daemon on top
+// of PUFFS, and bazil has no code to mount through it. Neither is OpenBSD,
+// whose fuse(4) only works with its own libfuse. Built with -tags cgofuse,
+// -cgofuse serves the tree through cgofuse instead, which goes through the
+// system libfuse or macFUSE by paths, over the io/fs view of the tree built
+// on the path lookups of WebDAV and 9P. That is also the way to WinFsp,
+// which doesn't speak the FUSE kernel protocol bazil implements, but
+// Windows isn't supported yet: the nodes take their types from bazil's fuse
+// package, which only builds where bazil can mount.
+//
+// The mount can be exported by knfsd, given an explicit fsid since FUSE
+// filesystems have no device number, e.g. in /etc/exports:
+//
+//	/mnt/music *(ro,fsid=1,no_subtree_check)
+//
+// Inode numbers are derived from paths, so they stay the same across
+// lookups and restarts, and converted names are found again without a
+// listing. bazil doesn't let the kernel look up nodes by handle though, so
+// clients get ESTALE for files the kernel has evicted from its cache, and
+// open them again by path.
+package main
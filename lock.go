@@ -0,0 +1,53 @@
+//go:build unix
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Locks of the mountpoints, held open for the life of the process
+var mountLocks []*os.File
+
+// lockPath gives the lock file of mountpoint. It can't be in the mountpoint
+// itself, which the mount covers.
+func lockPath(mountpoint string) string {
+	if abs, err := filepath.Abs(mountpoint); err == nil {
+		mountpoint = abs
+	}
+	sum := sha256.Sum256([]byte(mountpoint))
+	return filepath.Join(os.TempDir(), "codecfs-"+hex.EncodeToString(sum[:8])+".lock")
+}
+
+// lockMountpoint takes the lock of mountpoint, so that a second instance
+// doesn't unmount and mount it again under the feet of the first
+func lockMountpoint(mountpoint string) error {
+	path := lockPath(mountpoint)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		defer f.Close()
+		if err == syscall.EWOULDBLOCK {
+			data, _ := os.ReadFile(path)
+			if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+				return fmt.Errorf("already mounted by pid %d", pid)
+			}
+			return fmt.Errorf("already mounted, see %s", path)
+		}
+		return err
+	}
+	// The pid is only for messages, the lock goes away with the process
+	f.Truncate(0)
+	f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	mountLocks = append(mountLocks, f)
+	return nil
+}
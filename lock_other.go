@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+// lockMountpoint doesn't lock anything without flock(2)
+func lockMountpoint(mountpoint string) error {
+	return nil
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/rakoo/codecfs/codecfs"
+)
+
+// Set in the environment of the background process started by -daemon
+const daemonEnv = "CODECFS_DAEMON"
+
+// What the read window holds beyond the kernel readahead, for reads lagging
+// behind
+const readWindowMargin = 1 << 20
+
+// runMount mounts the transcoding filesystem and serves it until it is
+// unmounted
+func runMount(args []string) {
+	flags := flag.NewFlagSet("mount", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: codecfs mount [flags] <input dir or URL> [mountpoint]")
+		flags.PrintDefaults()
+	}
+	opts := codecfs.DefaultOptions()
+	opts.AddFlags(flags)
+	allowOther := flags.Bool("allow-other", false, "Let all users access the mount (needs user_allow_other in /etc/fuse.conf)")
+	allowRoot := flags.Bool("allow-root", false, "Let root access the mount (needs user_allow_other in /etc/fuse.conf)")
+	flags.DurationVar(&opts.DirCacheTTL, "dir-cache-ttl", time.Minute, "How long directory listings are cached for (0 disables the cache)")
+	flags.BoolVar(&opts.Watch, "watch", true, "Watch source directories and propagate changes to the mount, taking an inotify watch for each directory listed")
+	flags.IntVar(&opts.MaxWatches, "max-watches", opts.MaxWatches, "Watch at most that many source directories, within fs.inotify.max_user_watches; changes in the others show once -dir-cache-ttl expires (0 for no limit)")
+	flags.StringVar(&mountsPath, "mounts", "", "Serve every mount listed in this TOML file from this process, instead of the one given as arguments")
+	flags.StringVar(&opts.Ingest, "ingest", "", "Convert files written to /ingest to this format (e.g. flac) and store them at the top of the source")
+	flags.BoolVar(&opts.ForceReadOnly, "force-read-only", false, "Ignore source ownership and permissions, make everything world-readable")
+	metricsAddr := flags.String("metrics-addr", "", "Serve Prometheus metrics on this address (e.g. :9100)")
+	debugAddr := flags.String("debug-addr", "", "Serve pprof debug endpoints on this address (e.g. localhost:6060)")
+	flags.BoolVar(&opts.TraceFUSE, "trace-fuse", false, "Log every FUSE request along with its latency")
+	controlSocket := flags.String("control-socket", "", "Listen for control commands on this unix socket")
+	apiAddr := flags.String("api-addr", "", "Serve the REST API queueing and cancelling transcodes on this address (e.g. localhost:9091)")
+	grpcAddr := flags.String("grpc-addr", "", "Serve the gRPC control API on this address, or on the unix socket given as unix:path (e.g. localhost:9090)")
+	flags.IntVar(&opts.TranscodeRetries, "transcode-retries", opts.TranscodeRetries, "How many times a transcode failing before any output is retried")
+	flags.IntVar(&opts.MaxFailures, "max-failures", opts.MaxFailures, "Stop transcoding a file after that many consecutive failures, until it changes (0 for no limit)")
+	flags.DurationVar(&opts.TranscodeTimeout, "transcode-timeout", 0, "Kill encoders running for longer, counting time spent waiting for readers, and stop transcoding their file until it changes (0 for no limit)")
+	flags.DurationVar(&opts.SeekDistance, "seek-distance", opts.SeekDistance, "Restart the encoder at the matching timestamp for reads further than that much playback ahead (0 to always encode from the start)")
+	flags.Int64Var(&opts.ReadWindow, "read-window", 0, "Only keep that many bytes of each transcoded file in memory, for sequential readers, at least -max-readahead plus 1MiB (0 keeps whole files)")
+	flags.Int64Var(&opts.ReadAhead, "read-ahead", opts.ReadAhead, "How many bytes of output to drain from the encoder ahead of readers (0 disables read-ahead)")
+	flags.BoolVar(&opts.Prefetch, "prefetch", false, "Start transcoding the next file of a directory when one is read to the end")
+	flags.BoolVar(&opts.DirectIO, "direct-io", false, "Bypass the page cache for transcoded files, so that reads are never cut at an estimated size (breaks mmap on them)")
+	maxReadahead := flags.Uint("max-readahead", 1<<20, "Let the kernel read that many bytes ahead of applications (capped by the kernel)")
+	var extraOptions fuseOptions
+	flags.Var(&extraOptions, "o", "Mount with these comma-separated options, as other FUSE filesystems take them (ro, noatime, fsname=..., max_readahead=..., allow_other...)")
+	useCgofuse := flags.Bool("cgofuse", false, "Serve the mount through cgofuse, with libfuse, macFUSE or WinFsp, instead of speaking the FUSE protocol to the kernel (needs a build with -tags cgofuse)")
+	volumeName := flags.String("volume-name", "", "Name of the volume shown by macOS (defaults to the name of the mountpoint)")
+	flags.StringVar(&opts.VolumeIcon, "volume-icon", "", "Icon of the volume shown by macOS, an .icns file served as .VolumeIcon.icns at the root")
+	daemon := flags.Bool("daemon", false, "Run in the background")
+	superviseMounts := flags.Bool("supervise", false, "Serve the mounts from a child process, and mount them again whenever it crashes or loses its connection to the kernel")
+	logFile := flags.String("log-file", "", "Write logs to this file instead of stderr")
+	logLevel := flags.String("log-level", "info", "Minimum level of logs: debug, info, warn or error")
+	logFormat := flags.String("log-format", "text", "Format of logs: text or json")
+	fake := false
+	if isMountHelper() {
+		var err error
+		args, fake, err = mountHelperArgs(flags, args)
+		if err != nil {
+			fatal("Invalid mount arguments", "err", err)
+		}
+		// The background process started by -daemon gets the
+		// translated arguments
+		os.Args = append(os.Args[:1], args...)
+	}
+	flags.Parse(args)
+	opts.Settings = flagSettings(flags)
+
+	// In daemon mode, the log file is set up by the parent as our stderr
+	logOutput := io.Writer(os.Stderr)
+	if *logFile != "" && !*daemon {
+		out, err := os.OpenFile(*logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			fatal("Can't open log file", "err", err)
+		}
+		defer out.Close()
+		logOutput = out
+	}
+	if err := setupLogging(logOutput, *logLevel, *logFormat); err != nil {
+		fatal("Invalid logging options", "err", err)
+	}
+
+	var specs []mountSpec
+	if mountsPath != "" {
+		if flags.NArg() > 0 {
+			fatal("-mounts takes no input dir nor mountpoint")
+		}
+		if len(opts.Union) > 0 {
+			fatal("-union can't be used with -mounts")
+		}
+		var err error
+		if specs, err = loadMounts(mountsPath); err != nil {
+			fatal("Invalid mounts", "path", mountsPath, "err", err)
+		}
+	} else {
+		if flags.NArg() < 1 {
+			fatal("Missing input dir")
+		}
+		if flags.NArg() > 2 {
+			flags.Usage()
+			os.Exit(2)
+		}
+		mountpoint := "/tmp/codecfs"
+		if flags.NArg() == 2 {
+			mountpoint = flags.Arg(1)
+		}
+		specs = []mountSpec{{Source: flags.Arg(0), Mountpoint: mountpoint}}
+	}
+	// Reads are asynchronous, so the kernel has up to max-readahead bytes
+	// of reads in flight in any order. Trimming bytes some of them still
+	// need would restart the encoder.
+	if window := int64(extraOptions.maxReadahead(*maxReadahead)) + readWindowMargin; opts.ReadWindow > 0 && opts.ReadWindow < window {
+		slog.Warn("Growing the read window to hold the kernel readahead", "window", window)
+		opts.ReadWindow = window
+	}
+	// Each mount of a mounts file can have a tree of its own
+	mountOpts := make([]codecfs.Options, len(specs))
+	for i, spec := range specs {
+		o := opts
+		if spec.Profile != "" {
+			o.Profile = spec.Profile
+		}
+		if spec.Original != nil {
+			o.Original = *spec.Original
+		}
+		if err := o.Validate(); err != nil {
+			fatal("Invalid options", "mountpoint", spec.Mountpoint, "err", err)
+		}
+		mountOpts[i] = o
+	}
+	if *allowOther && *allowRoot {
+		fatal("-allow-other and -allow-root are mutually exclusive")
+	}
+
+	if fake {
+		return
+	}
+	if *daemon && os.Getenv(daemonEnv) == "" {
+		pid, err := daemonize(*logFile)
+		if err != nil {
+			fatal("Can't start in the background", "err", err)
+		}
+		for _, spec := range specs {
+			if err := waitMounted(spec.Mountpoint, pid); err != nil {
+				fatal("Mount failed", "mountpoint", spec.Mountpoint, "err", err)
+			}
+		}
+		if !isMountHelper() {
+			fmt.Println(pid)
+		}
+		return
+	}
+
+	if *superviseMounts && !supervised() {
+		mountpoints := make([]string, len(specs))
+		for i, spec := range specs {
+			mountpoints[i] = spec.Mountpoint
+		}
+		supervise(mountpoints)
+		return
+	}
+
+	// Trees are all built before anything is mounted, so that bad
+	// options don't leave mountpoints behind
+	roots := make([]*codecfs.Root, len(specs))
+	for i, spec := range specs {
+		// The sources of a mounts file are all local, they share the
+		// watcher and the size cache
+		root, err := codecfs.New(spec.Source, mountOpts[i])
+		if err != nil {
+			fatal("Can't build tree", "source", spec.Source, "err", err)
+		}
+		roots[i] = root
+	}
+
+	// cgofuse mounts once the listeners are up, as serving blocks
+	var conns []*fuse.Conn
+	if !*useCgofuse {
+		conns = make([]*fuse.Conn, len(specs))
+		for i, spec := range specs {
+			c, err := mount(spec.Mountpoint, *volumeName, opts.VolumeIcon != "", *maxReadahead, *allowOther, *allowRoot, opts.ForceReadOnly, extraOptions.mountOptions())
+			if err != nil {
+				// Don't leave the earlier mounts behind
+				unmountAll(specs[:i], conns[:i])
+				fatal("Mount failed", "mountpoint", spec.Mountpoint, "err", err)
+			}
+			conns[i] = c
+			defer c.Close()
+		}
+	}
+
+	if *metricsAddr != "" {
+		go func() {
+			fatal("Metrics listener failed", "addr", *metricsAddr, "err", codecfs.ListenMetrics(*metricsAddr))
+		}()
+	}
+	if *debugAddr != "" {
+		go func() {
+			fatal("Debug listener failed", "addr", *debugAddr, "err", codecfs.ListenDebug(*debugAddr))
+		}()
+	}
+	if *controlSocket != "" {
+		go func() {
+			fatal("Can't open control socket", "path", *controlSocket, "err", codecfs.ListenControl(*controlSocket))
+		}()
+	}
+	if *apiAddr != "" {
+		go func() {
+			fatal("API listener failed", "addr", *apiAddr, "err", codecfs.ListenAPI(*apiAddr))
+		}()
+	}
+	if *grpcAddr != "" {
+		go func() {
+			fatal("gRPC listener failed", "addr", *grpcAddr, "err", codecfs.ListenGRPC(*grpcAddr, &logLevelVar))
+		}()
+	}
+	go reloadOnHangup()
+
+	if *useCgofuse {
+		serveCgofuse(specs, roots, *volumeName, *allowOther, *allowRoot, extraOptions)
+		return
+	}
+	var wg sync.WaitGroup
+	for i, spec := range specs {
+		wg.Add(1)
+		go func(root *codecfs.Root, c *fuse.Conn, mountpoint string) {
+			defer wg.Done()
+			if err := root.Serve(c, mountpoint); err != nil {
+				unmountAll(specs, conns)
+				fatal("Serving failed", "mountpoint", mountpoint, "err", err)
+			}
+			<-c.Ready
+			if err := c.MountError; err != nil {
+				unmountAll(specs, conns)
+				fatal("Mount failed", "mountpoint", mountpoint, "err", mountError(err, *allowOther || *allowRoot))
+			}
+			fuse.Unmount(mountpoint)
+		}(roots[i], conns[i], spec.Mountpoint)
+	}
+	go func() {
+		for _, c := range conns {
+			<-c.Ready
+			if c.MountError != nil {
+				return
+			}
+		}
+		if err := sdNotify("READY=1"); err != nil {
+			slog.Warn("Can't notify systemd", "err", err)
+		}
+	}()
+	wg.Wait()
+	sdNotify("STOPPING=1")
+}
+
+// unmountAll unmounts the mounts of specs, served by conns, before exiting
+// on the failure of one of them
+func unmountAll(specs []mountSpec, conns []*fuse.Conn) {
+	for i, spec := range specs {
+		fuse.Unmount(spec.Mountpoint)
+		conns[i].Close()
+	}
+}
+
+// mount mounts the filesystem on mountpoint, taking its lock first unless it
+// is supervised. The extra options come last, to override ours.
+func mount(mountpoint, volumeName string, volumeIcon bool, maxReadahead uint, allowOther, allowRoot, forceReadOnly bool, extra []fuse.MountOption) (*fuse.Conn, error) {
+	// The supervisor holds the lock for us
+	if !supervised() {
+		if err := lockMountpoint(mountpoint); err != nil {
+			return nil, fmt.Errorf("can't lock mountpoint: %v", err)
+		}
+	}
+	fuse.Unmount(mountpoint)
+	err := os.Mkdir(mountpoint, os.ModeDir|0755)
+	if err != nil && !os.IsExist(err) {
+		return nil, fmt.Errorf("can't create mountpoint: %v", err)
+	} else if os.IsExist(err) {
+		os.Chmod(mountpoint, os.ModeDir|0755)
+	}
+	mountOptions := []fuse.MountOption{
+		fuse.FSName("codecfs"),
+		fuse.Subtype("codecfs"),
+		fuse.MaxReadahead(uint32(maxReadahead)),
+		fuse.AsyncRead(),
+	}
+	if volumeName == "" {
+		volumeName = filepath.Base(mountpoint)
+	}
+	mountOptions = append(mountOptions, platformMountOptions(volumeName, volumeIcon)...)
+	if !forceReadOnly {
+		// Let the kernel enforce the permissions we copy from the source
+		mountOptions = append(mountOptions, fuse.DefaultPermissions())
+	}
+	switch {
+	case allowOther:
+		mountOptions = append(mountOptions, fuse.AllowOther())
+	case allowRoot:
+		mountOptions = append(mountOptions, fuse.AllowRoot())
+	}
+	mountOptions = append(mountOptions, extra...)
+	c, err := fuse.Mount(mountpoint, mountOptions...)
+	if err != nil {
+		return nil, mountError(err, allowOther || allowRoot)
+	}
+	return c, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Options mount(8) may pass along that only matter to mount itself. The
+// others, like rw, noexec or nodev, go through fuseOption as with -o.
+var genericMountOptions = map[string]bool{
+	"defaults": true,
+	"auto":     true,
+	"noauto":   true,
+	"user":     true,
+	"nouser":   true,
+	"users":    true,
+	"owner":    true,
+	"group":    true,
+	"nofail":   true,
+	"_netdev":  true,
+}
+
+// isMountHelper tells whether we're run by mount(8), through a
+// /sbin/mount.codecfs symlink
+func isMountHelper() bool {
+	return filepath.Base(os.Args[0]) == "mount.codecfs"
+}
+
+// mountHelperArgs translates the arguments mount(8) gives to helpers:
+//
+//	mount.codecfs <source> <mountpoint> [-sfnv] [-o options]
+//
+// into regular command-line arguments, so that an fstab line such as
+//
+//	/srv/music /mnt/music codecfs quality=3,allow_other 0 0
+//
+// mounts like `codecfs -daemon -quality=3 -allow-other /srv/music /mnt/music`.
+// Options are looked up in fs, and passed with -o when they are options of
+// FUSE itself. fake is true when mount only wants us to
+// pretend (-f).
+func mountHelperArgs(fs *flag.FlagSet, args []string) (out []string, fake bool, err error) {
+	var positional, options []string
+	sloppy := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-o":
+			if i+1 == len(args) {
+				return nil, false, errors.New("missing value for -o")
+			}
+			i++
+			options = append(options, strings.Split(args[i], ",")...)
+		case strings.HasPrefix(arg, "-o"):
+			options = append(options, strings.Split(arg[2:], ",")...)
+		case strings.HasPrefix(arg, "-"):
+			sloppy = sloppy || strings.Contains(arg, "s")
+			fake = fake || strings.Contains(arg, "f")
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) != 2 {
+		return nil, false, errors.New("usage: mount.codecfs <source> <mountpoint> [-sfnv] [-o options]")
+	}
+
+	out = []string{"-daemon"}
+	for _, opt := range options {
+		key, value := opt, ""
+		if i := strings.IndexByte(opt, '='); i >= 0 {
+			key, value = opt[:i], opt[i+1:]
+		}
+		if key == "" || genericMountOptions[key] {
+			continue
+		}
+		// Mount options traditionally use underscores
+		key = strings.Replace(key, "_", "-", -1)
+		if fs.Lookup(key) == nil {
+			// Options of FUSE itself go through -o
+			_, err := fuseOption(opt)
+			if err == nil {
+				out = append(out, "-o="+opt)
+				continue
+			} else if !errors.Is(err, errUnknownOption) {
+				return nil, false, err
+			}
+			if sloppy {
+				continue
+			}
+			return nil, false, errors.New("unknown mount option: " + opt)
+		}
+		if value == "" {
+			out = append(out, "-"+key)
+		} else {
+			out = append(out, "-"+key+"="+value)
+		}
+	}
+	return append(out, positional...), fake, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"bazil.org/fuse"
+)
+
+var errUnknownOption = errors.New("unknown mount option")
+
+// fuseOptions are the options given with -o, as other FUSE filesystems take
+// them
+type fuseOptions []string
+
+func (o *fuseOptions) String() string {
+	return strings.Join(*o, ",")
+}
+
+func (o *fuseOptions) Set(value string) error {
+	for _, opt := range strings.Split(value, ",") {
+		if opt == "" {
+			continue
+		}
+		if _, err := fuseOption(opt); err != nil {
+			return err
+		}
+		key, _, _ := strings.Cut(opt, "=")
+		if reason, ok := ignoredOptions[key]; ok {
+			slog.Warn("Ignoring mount option", "option", opt, "reason", reason)
+		}
+		*o = append(*o, opt)
+	}
+	return nil
+}
+
+// mountOptions translates o to the options of bazil
+func (o fuseOptions) mountOptions() []fuse.MountOption {
+	var out []fuse.MountOption
+	for _, opt := range o {
+		// They were checked when set
+		if option, _ := fuseOption(opt); option != nil {
+			out = append(out, option)
+		}
+	}
+	return out
+}
+
+// maxReadahead gives the readahead set with max_readahead, def if none is
+func (o fuseOptions) maxReadahead(def uint) uint {
+	for _, opt := range o {
+		if value, ok := strings.CutPrefix(opt, "max_readahead="); ok {
+			// It was checked when set
+			n, _ := strconv.ParseUint(value, 10, 32)
+			def = uint(n)
+		}
+	}
+	return def
+}
+
+// Options accepted for compatibility that bazil can't pass along, with why
+var ignoredOptions = map[string]string{
+	"noexec":   "files keep the modes of their source",
+	"max_read": "bazil leaves the size of read requests to the kernel, use -max-readahead to tune reads",
+}
+
+// fuseOption translates a mount option of FUSE filesystems to the matching
+// one of bazil. It gives nil for options that change nothing for us, and
+// errUnknownOption for those it doesn't know.
+func fuseOption(opt string) (fuse.MountOption, error) {
+	key, value, hasValue := strings.Cut(opt, "=")
+	withValue := map[string]bool{
+		"fsname":         true,
+		"subtype":        true,
+		"volname":        true,
+		"max_readahead":  true,
+		"daemon_timeout": true,
+		"max_read":       true,
+	}
+	if withValue[key] != hasValue {
+		if hasValue {
+			return nil, fmt.Errorf("mount option %s takes no value", key)
+		}
+		return nil, fmt.Errorf("mount option %s needs a value", key)
+	}
+	switch key {
+	case "ro":
+		return fuse.ReadOnly(), nil
+	case "rw", "exec", "sync", "async", "nosuid", "nodev":
+		// FUSE mounts are nosuid and nodev unless told otherwise
+		return nil, nil
+	case "atime", "noatime", "relatime", "strictatime", "nodiratime":
+		// We never store access times
+		return nil, nil
+	case "suid":
+		return fuse.AllowSUID(), nil
+	case "dev":
+		return fuse.AllowDev(), nil
+	case "allow_other":
+		return fuse.AllowOther(), nil
+	case "allow_root":
+		return fuse.AllowRoot(), nil
+	case "default_permissions":
+		return fuse.DefaultPermissions(), nil
+	case "nonempty":
+		return fuse.AllowNonEmptyMount(), nil
+	case "async_read":
+		return fuse.AsyncRead(), nil
+	case "fsname":
+		return fuse.FSName(value), nil
+	case "subtype":
+		return fuse.Subtype(value), nil
+	case "volname":
+		return volumeNameOption(value), nil
+	case "daemon_timeout":
+		return fuse.DaemonTimeout(value), nil
+	case "max_readahead":
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max_readahead: %v", err)
+		}
+		return fuse.MaxReadahead(uint32(n)), nil
+	case "noexec", "max_read":
+		// See ignoredOptions
+		return nil, nil
+	}
+	return nil, fmt.Errorf("%w: %s", errUnknownOption, opt)
+}
@@ -0,0 +1,25 @@
+package main
+
+import "bazil.org/fuse"
+
+// platformMountOptions gives the mount options specific to macFUSE. With a
+// volume icon, Finder must see the com.apple.FinderInfo attribute of the
+// root, so extended attributes aren't hidden from it.
+func platformMountOptions(volumeName string, volumeIcon bool) []fuse.MountOption {
+	options := []fuse.MountOption{
+		fuse.LocalVolume(),
+		fuse.VolumeName(volumeName),
+		// Finder would otherwise try to store its metadata in ._ files
+		// and extended attributes we can't hold
+		fuse.NoAppleDouble(),
+	}
+	if !volumeIcon {
+		options = append(options, fuse.NoAppleXattr())
+	}
+	return options
+}
+
+// volumeNameOption gives the option of -o volname
+func volumeNameOption(name string) fuse.MountOption {
+	return fuse.VolumeName(name)
+}
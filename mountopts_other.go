@@ -0,0 +1,18 @@
+//go:build !darwin
+// +build !darwin
+
+package main
+
+import "bazil.org/fuse"
+
+func init() {
+	ignoredOptions["volname"] = "only macOS names volumes"
+}
+
+func platformMountOptions(volumeName string, volumeIcon bool) []fuse.MountOption {
+	return nil
+}
+
+func volumeNameOption(name string) fuse.MountOption {
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Path of a TOML file listing the mounts served by the process, as in
+//
+//	[[mount]]
+//	source = "/srv/music"
+//	mountpoint = "/mnt/music"
+//	profile = "opus-96"
+//	original = true
+//
+// The mounts share flags, caches, encoders and metrics. Empty to serve the
+// single mount given as arguments.
+var mountsPath string
+
+// mountSpec is a mount of a mounts file
+type mountSpec struct {
+	Source     string `toml:"source"`
+	Mountpoint string `toml:"mountpoint"`
+	// Named profile of the main tree, as in opus-96, instead of the one
+	// given by flags
+	Profile string `toml:"profile"`
+	// Overrides -original
+	Original *bool `toml:"original"`
+}
+
+// loadMounts reads the mounts of the file at path. Their sources must be
+// local, since remote ones can't share the process.
+func loadMounts(path string) ([]mountSpec, error) {
+	var conf struct {
+		Mounts []mountSpec `toml:"mount"`
+	}
+	if _, err := toml.DecodeFile(path, &conf); err != nil {
+		return nil, err
+	}
+	mountpoints := map[string]bool{}
+	for _, m := range conf.Mounts {
+		switch {
+		case m.Source == "" || m.Mountpoint == "":
+			return nil, errors.New("mounts need a source and a mountpoint")
+		case strings.Contains(m.Source, "://"):
+			return nil, fmt.Errorf("%s: only local sources can share the process", m.Source)
+		}
+		abs, err := filepath.Abs(m.Mountpoint)
+		if err != nil {
+			return nil, err
+		}
+		if mountpoints[abs] {
+			return nil, fmt.Errorf("%s is mounted twice", m.Mountpoint)
+		}
+		mountpoints[abs] = true
+	}
+	if len(conf.Mounts) == 0 {
+		return nil, errors.New("no mount defined")
+	}
+	return conf.Mounts, nil
+}
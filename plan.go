@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/rakoo/codecfs/codecfs"
+)
+
+// runPlan walks a source and prints what a mount of it would expose, and
+// how, without mounting anything
+func runPlan(args []string) {
+	flags := flag.NewFlagSet("plan", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: codecfs plan [flags] <input dir or URL>")
+		flags.PrintDefaults()
+	}
+	opts := codecfs.DefaultOptions()
+	opts.Watch = false
+	opts.AddProfileFlags(flags)
+	tree := flags.String("tree", "ogg", "Tree to plan: ogg, or a named profile like opus-96")
+	flags.StringVar(&opts.SizeCache, "size-cache", opts.SizeCache, "Take the exact sizes known from this database (empty to estimate them all)")
+	flags.StringVar(&opts.SizeStrategy, "size-strategy", "estimate", "How to estimate sizes that aren't known: inflate, source, estimate, huge or exact")
+	flags.BoolVar(&opts.SanitizeNames, "sanitize-names", false, "Make names ASCII and safe for FAT filesystems")
+	flags.BoolVar(&opts.Chapters, "chapters", false, "Show m4b and m4a audiobooks with chapters as directories with a file per chapter")
+	flags.BoolVar(&opts.Playlists, "playlists", false, "Add an album.m3u to each directory with tracks")
+	flags.StringVar(&opts.Sort, "sort", opts.Sort, "Order of listings: bytes, natural or folded")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	root, err := codecfs.New(flags.Arg(0), opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := root.Plan(os.Stdout, *tree); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
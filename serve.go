@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/rakoo/codecfs/codecfs"
+)
+
+// runServe serves the virtual tree over network protocols instead of
+// mounting it
+func runServe(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: codecfs serve [flags] <input dir or URL>")
+		flags.PrintDefaults()
+	}
+	opts := codecfs.DefaultOptions()
+	opts.AddFlags(flags)
+	flags.BoolVar(&opts.Watch, "watch", true, "Watch source directories and propagate changes, taking an inotify watch for each directory listed")
+	flags.IntVar(&opts.MaxWatches, "max-watches", opts.MaxWatches, "Watch at most that many source directories, within fs.inotify.max_user_watches; changes in the others show once their listing expires (0 for no limit)")
+	ninepAddr := flags.String("9p", "", "Serve over 9P2000 on this TCP address (e.g. :5640)")
+	davAddr := flags.String("webdav", "", "Serve over WebDAV on this address (e.g. :8080)")
+	httpAddr := flags.String("http", "", "Stream files over plain HTTP on this address (e.g. :8000)")
+	dlnaAddr := flags.String("dlna", "", "Act as a DLNA media server whose HTTP side listens on this address (e.g. :8200)")
+	hostname, _ := os.Hostname()
+	dlnaName := flags.String("dlna-name", "codecfs on "+hostname, "Name of the DLNA media server")
+	logLevel := flags.String("log-level", "info", "Minimum level of logs: debug, info, warn or error")
+	logFormat := flags.String("log-format", "text", "Format of logs: text or json")
+	flags.Parse(args)
+	opts.Settings = flagSettings(flags)
+
+	if err := setupLogging(os.Stderr, *logLevel, *logFormat); err != nil {
+		fatal("Invalid logging options", "err", err)
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *ninepAddr == "" && *davAddr == "" && *httpAddr == "" && *dlnaAddr == "" {
+		fatal("Nothing to serve, pick a protocol")
+	}
+	root, err := codecfs.New(flags.Arg(0), opts)
+	if err != nil {
+		fatal("Invalid options", "err", err)
+	}
+
+	go reloadOnHangup()
+	if *ninepAddr != "" {
+		go func() {
+			fatal("9P listener failed", "addr", *ninepAddr, "err", root.Listen9P(*ninepAddr))
+		}()
+	}
+	if *davAddr != "" {
+		go func() {
+			fatal("WebDAV listener failed", "addr", *davAddr, "err", root.ListenWebDAV(*davAddr))
+		}()
+	}
+	if *httpAddr != "" {
+		go func() {
+			fatal("HTTP listener failed", "addr", *httpAddr, "err", root.ListenHTTP(*httpAddr))
+		}()
+	}
+	if *dlnaAddr != "" {
+		go func() {
+			fatal("DLNA listener failed", "addr", *dlnaAddr, "err", root.ListenDLNA(*dlnaAddr, *dlnaName))
+		}()
+	}
+	// The listeners exit on failure
+	select {}
+}
+
+// flagSettings gives the value of every flag, for .status
+func flagSettings(flags *flag.FlagSet) map[string]string {
+	settings := map[string]string{}
+	flags.VisitAll(func(f *flag.Flag) {
+		settings[f.Name] = f.Value.String()
+	})
+	return settings
+}
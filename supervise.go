@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+)
+
+// Set in the environment of the process serving the mounts under -supervise
+const supervisedEnv = "CODECFS_SUPERVISED"
+
+const (
+	// Delays before mounting again after a crash, doubling with each crash
+	// in a row up to the longest
+	remountDelay    = time.Second
+	maxRemountDelay = time.Minute
+	// A process that lived that long doesn't count as crashing in a row
+	stableRun = time.Minute
+	// How often mountpoints are checked for a dead connection
+	supervisorCheck = 10 * time.Second
+)
+
+// supervised tells whether this process serves mounts for a supervisor
+func supervised() bool {
+	return os.Getenv(supervisedEnv) != ""
+}
+
+// supervise runs the same command again to serve the mounts on mountpoints,
+// and runs it again whenever it crashes or the connection to the kernel is
+// lost, once the stale mounts are cleaned up. It holds the locks of the
+// mountpoints in the meantime, and returns once the command exits cleanly or
+// the supervisor is told to stop.
+func supervise(mountpoints []string) {
+	for _, mountpoint := range mountpoints {
+		if err := lockMountpoint(mountpoint); err != nil {
+			fatal("Can't lock mountpoint", "mountpoint", mountpoint, "err", err)
+		}
+	}
+	self, err := os.Executable()
+	if err != nil {
+		fatal("Can't find our executable", "err", err)
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	delay := remountDelay
+	for {
+		cmd := exec.Command(self, os.Args[1:]...)
+		cmd.Env = append(os.Environ(), supervisedEnv+"=1")
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Start(); err != nil {
+			fatal("Can't start the mount process", "err", err)
+		}
+		started := time.Now()
+		exited := make(chan error, 1)
+		go func() { exited <- cmd.Wait() }()
+
+		stopping := false
+		ticker := time.NewTicker(supervisorCheck)
+		var err error
+	wait:
+		for {
+			select {
+			case err = <-exited:
+				break wait
+			case sig := <-signals:
+				// Reloads go through, anything else stops us
+				stopping = stopping || sig != syscall.SIGHUP
+				cmd.Process.Signal(sig)
+			case <-ticker.C:
+				for _, mountpoint := range mountpoints {
+					if _, err := os.Stat(mountpoint); errors.Is(err, syscall.ENOTCONN) {
+						slog.Warn("Mount lost its connection, restarting", "mountpoint", mountpoint)
+						cmd.Process.Kill()
+						break
+					}
+				}
+			}
+		}
+		ticker.Stop()
+
+		if err == nil {
+			// Unmounted on purpose
+			return
+		}
+		cleanupMounts(mountpoints)
+		if stopping {
+			return
+		}
+
+		if time.Since(started) > stableRun {
+			delay = remountDelay
+		}
+		slog.Warn("Mount process crashed, mounting again", "err", err, "delay", delay)
+		timer := time.NewTimer(delay)
+	backoff:
+		for {
+			select {
+			case <-timer.C:
+				break backoff
+			case sig := <-signals:
+				// The next process reads the configuration
+				// again anyway
+				if sig != syscall.SIGHUP {
+					timer.Stop()
+					return
+				}
+			}
+		}
+		delay = min(2*delay, maxRemountDelay)
+	}
+}
+
+// cleanupMounts unmounts what a crashed process left on mountpoints. Mounts
+// still in use can only be detached lazily on Linux.
+func cleanupMounts(mountpoints []string) {
+	for _, mountpoint := range mountpoints {
+		if err := fuse.Unmount(mountpoint); err == nil || runtime.GOOS != "linux" {
+			continue
+		}
+		for _, helper := range []string{"fusermount3", "fusermount"} {
+			if exec.Command(helper, "-u", "-z", mountpoint).Run() == nil {
+				break
+			}
+		}
+	}
+}